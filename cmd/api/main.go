@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -26,7 +27,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusOK)
@@ -108,6 +109,9 @@ func main() {
 	)
 
 	router := gin.Default()
+	// Services are called with the gin.Context, so values middleware puts
+	// on the request's context, such as a dataset version, must reach them
+	router.ContextWithFallback = true
 	router.Use(CORSMiddleware())
 
 	// Health check route
@@ -143,16 +147,77 @@ func main() {
 	// Dataset routes
 	datasetService := services.NewDatasetService(repo)
 	datasetHandler := handlers.NewDatasetHandler(datasetService)
+	if key := os.Getenv("DATA_SOURCE_KEY"); key != "" {
+		secrets, err := auth.NewSecretBox(key)
+		if err != nil {
+			logger.Logger.Fatalf("Invalid DATA_SOURCE_KEY: %v", err)
+		}
+		datasetService.Secrets = secrets
+	}
+	if size := os.Getenv("DATASET_CACHE_MB"); size != "" {
+		cacheMB, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || cacheMB < 0 {
+			logger.Logger.Fatalf("Invalid DATASET_CACHE_MB: %q", size)
+		}
+		datasetService.Cache = nil
+		if cacheMB > 0 {
+			datasetService.Cache = services.NewDatasetCache(cacheMB << 20)
+		}
+	}
+	if max := os.Getenv("DATASET_MAX_VERSIONS"); max != "" {
+		maxVersions, err := strconv.Atoi(max)
+		if err != nil || maxVersions < 0 {
+			logger.Logger.Fatalf("Invalid DATASET_MAX_VERSIONS: %q", max)
+		}
+		datasetService.MaxVersions = maxVersions
+	}
+	if err := datasetService.RecoverUploadJobs(context.Background()); err != nil {
+		logger.Logger.Printf("Failed to recover upload jobs: %v", err)
+	}
+	if err := datasetService.CleanupUploadSessions(context.Background()); err != nil {
+		logger.Logger.Printf("Failed to clean up upload sessions: %v", err)
+	}
 	datasetGroup := router.Group("/datasets")
 	datasetGroup.Use(auth.AuthMiddleware(jwtManager))
 	{
 		datasetGroup.POST("/upload", datasetHandler.UploadDataset)
+		datasetGroup.POST("/sqlite/tables", datasetHandler.ListSQLiteTables)
+		datasetGroup.GET("/jobs/:id", datasetHandler.GetUploadJob)
+		datasetGroup.GET("/jobs/:id/rejections", datasetHandler.GetUploadRejections)
+		datasetGroup.POST("/jobs/:id/cancel", datasetHandler.CancelUploadJob)
+		datasetGroup.POST("/uploads", datasetHandler.CreateUploadSession)
+		datasetGroup.GET("/uploads/:id", datasetHandler.GetUploadSession)
+		datasetGroup.PUT("/uploads/:id/chunks/:index", datasetHandler.PutUploadChunk)
+		datasetGroup.POST("/uploads/:id/finalize", datasetHandler.FinalizeUploadSession)
+		datasetGroup.DELETE("/uploads/:id", datasetHandler.CancelUploadSession)
 		datasetGroup.GET("/", datasetHandler.ListDatasets)
 		datasetGroup.GET("/:id", datasetHandler.GetDatasetByID)
+		datasetGroup.GET("/:id/rows", datasetHandler.GetDatasetRowPage)
+		datasetGroup.POST("/:id/rows", datasetHandler.InsertDatasetRows)
+		datasetGroup.DELETE("/:id/rows", datasetHandler.DeleteDatasetRows)
+		datasetGroup.PATCH("/:id/rows/:row", datasetHandler.PatchDatasetRow)
+		datasetGroup.DELETE("/:id/rows/:row", datasetHandler.DeleteDatasetRow)
+		datasetGroup.PUT("/:id/rows/:row/cells/:column", datasetHandler.UpdateDatasetCell)
+		datasetGroup.GET("/:id/export", datasetHandler.ExportDataset)
+		datasetGroup.POST("/:id/append", datasetHandler.AppendDataset)
+		datasetGroup.POST("/:id/merge", datasetHandler.MergeDataset)
+		datasetGroup.GET("/:id/versions", datasetHandler.ListDatasetVersions)
+		datasetGroup.POST("/:id/versions/:version/rollback", datasetHandler.RollbackDataset)
 		datasetGroup.DELETE("/:id", datasetHandler.DeleteDatasetsByID)
 		datasetGroup.PUT("/:id", datasetHandler.UpdateDataset)
 		datasetGroup.POST("/", datasetHandler.CreateDataset)
 		datasetGroup.GET("/search", datasetHandler.SearchDataSets)
+		datasetGroup.GET("/cache", datasetHandler.GetCacheStats)
+	}
+
+	// Data source routes
+	dataSourceGroup := router.Group("/data-sources")
+	dataSourceGroup.Use(auth.AuthMiddleware(jwtManager))
+	{
+		dataSourceGroup.POST("", datasetHandler.CreateDataSource)
+		dataSourceGroup.GET("", datasetHandler.ListDataSources)
+		dataSourceGroup.DELETE("/:id", datasetHandler.DeleteDataSource)
+		dataSourceGroup.POST("/:id/query", datasetHandler.QueryDataSource)
 	}
 
 	// Analytics routes
@@ -161,7 +226,7 @@ func main() {
 		DatasetService: datasetService,
 	}
 	analyticsGroup := router.Group("/analytics")
-	analyticsGroup.Use(auth.AuthMiddleware(jwtManager))
+	analyticsGroup.Use(auth.AuthMiddleware(jwtManager), datasetHandler.ResolveDatasetVersion)
 	{
 		// Descriptives
 		descriptivesGroup := analyticsGroup.Group("/descriptives")
@@ -228,8 +293,8 @@ func main() {
 		cleaningGroup.POST("/apply-log-transformation", datasetHandler.ApplyLogTransformationHandler)
 		cleaningGroup.POST("/normalize-column", datasetHandler.NormalizeColumnHandler)
 		cleaningGroup.POST("/standardize-column", datasetHandler.StandardizeColumnHandler)
-		cleaningGroup.POST("/drop-columns/:datasetID", datasetHandler.DropColumnsHandler)
-		cleaningGroup.POST("/rename-columns/:datasetID", datasetHandler.RenameColumnsHandler)
+		cleaningGroup.POST("/drop-columns/:dataset_id", datasetHandler.DropColumnsHandler)
+		cleaningGroup.POST("/rename-columns/:dataset_id", datasetHandler.RenameColumnsHandler)
 	}
 
 	// Get the port from environment or default to 8080
@@ -0,0 +1,40 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/Bgoodwin24/insightforge/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretBox(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	box, err := auth.NewSecretBox(base64.StdEncoding.EncodeToString(key))
+	require.NoError(t, err)
+
+	sealed, err := box.Seal([]byte("s3cret"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "s3cret")
+
+	opened, err := box.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(opened))
+
+	// Sealing twice uses a fresh nonce
+	again, err := box.Seal([]byte("s3cret"))
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = box.Open(sealed)
+	assert.ErrorIs(t, err, auth.ErrInvalidSecret)
+
+	_, err = auth.NewSecretBox("too short")
+	assert.Error(t, err)
+}
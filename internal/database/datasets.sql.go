@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createDataset = `-- name: CreateDataset :one
@@ -20,11 +21,15 @@ INSERT INTO datasets (
     name,
     description,
     created_at,
-    updated_at
+    updated_at,
+    status,
+    upload_job_id,
+    encoding,
+    content_hash
 ) VALUES (
-    $1, $2, $3, $4, $5, $6
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 )
-RETURNING id, user_id, name, description, created_at, updated_at, public
+RETURNING id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash
 `
 
 type CreateDatasetParams struct {
@@ -34,6 +39,10 @@ type CreateDatasetParams struct {
 	Description sql.NullString
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	Status      string
+	UploadJobID uuid.NullUUID
+	Encoding    sql.NullString
+	ContentHash sql.NullString
 }
 
 func (q *Queries) CreateDataset(ctx context.Context, arg CreateDatasetParams) (Dataset, error) {
@@ -44,6 +53,10 @@ func (q *Queries) CreateDataset(ctx context.Context, arg CreateDatasetParams) (D
 		arg.Description,
 		arg.CreatedAt,
 		arg.UpdatedAt,
+		arg.Status,
+		arg.UploadJobID,
+		arg.Encoding,
+		arg.ContentHash,
 	)
 	var i Dataset
 	err := row.Scan(
@@ -54,13 +67,17 @@ func (q *Queries) CreateDataset(ctx context.Context, arg CreateDatasetParams) (D
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Public,
+		&i.Status,
+		&i.UploadJobID,
+		&i.Encoding,
+		&i.ContentHash,
 	)
 	return i, err
 }
 
 const createDatasetField = `-- name: CreateDatasetField :exec
-INSERT INTO dataset_fields (id, dataset_id, name, data_type, description, created_at)
-VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO dataset_fields (id, dataset_id, name, data_type, description, created_at, date_format)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 `
 
 type CreateDatasetFieldParams struct {
@@ -70,6 +87,7 @@ type CreateDatasetFieldParams struct {
 	DataType    string
 	Description sql.NullString
 	CreatedAt   time.Time
+	DateFormat  sql.NullString
 }
 
 func (q *Queries) CreateDatasetField(ctx context.Context, arg CreateDatasetFieldParams) error {
@@ -80,13 +98,14 @@ func (q *Queries) CreateDatasetField(ctx context.Context, arg CreateDatasetField
 		arg.DataType,
 		arg.Description,
 		arg.CreatedAt,
+		arg.DateFormat,
 	)
 	return err
 }
 
 const createDatasetRecord = `-- name: CreateDatasetRecord :exec
-INSERT INTO dataset_records (id, dataset_id, created_at, updated_at)
-VALUES ($1, $2, $3, $4)
+INSERT INTO dataset_records (id, dataset_id, created_at, updated_at, row_index)
+VALUES ($1, $2, $3, $4, $5)
 `
 
 type CreateDatasetRecordParams struct {
@@ -94,6 +113,7 @@ type CreateDatasetRecordParams struct {
 	DatasetID uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	RowIndex  int64
 }
 
 func (q *Queries) CreateDatasetRecord(ctx context.Context, arg CreateDatasetRecordParams) error {
@@ -102,23 +122,50 @@ func (q *Queries) CreateDatasetRecord(ctx context.Context, arg CreateDatasetReco
 		arg.DatasetID,
 		arg.CreatedAt,
 		arg.UpdatedAt,
+		arg.RowIndex,
 	)
 	return err
 }
 
 const createRecordValue = `-- name: CreateRecordValue :exec
-INSERT INTO record_values (record_id, field_id, value)
-VALUES ($1, $2, $3)
+INSERT INTO record_values (record_id, field_id, value, num_value)
+VALUES ($1, $2, $3, $4)
 `
 
 type CreateRecordValueParams struct {
 	RecordID uuid.UUID
 	FieldID  uuid.UUID
 	Value    sql.NullString
+	NumValue sql.NullFloat64
 }
 
 func (q *Queries) CreateRecordValue(ctx context.Context, arg CreateRecordValueParams) error {
-	_, err := q.db.ExecContext(ctx, createRecordValue, arg.RecordID, arg.FieldID, arg.Value)
+	_, err := q.db.ExecContext(ctx, createRecordValue,
+		arg.RecordID,
+		arg.FieldID,
+		arg.Value,
+		arg.NumValue,
+	)
+	return err
+}
+
+const deleteAllDatasetFields = `-- name: DeleteAllDatasetFields :exec
+DELETE FROM dataset_fields
+WHERE dataset_id = $1
+`
+
+func (q *Queries) DeleteAllDatasetFields(ctx context.Context, datasetID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteAllDatasetFields, datasetID)
+	return err
+}
+
+const deleteAllDatasetRecords = `-- name: DeleteAllDatasetRecords :exec
+DELETE FROM dataset_records
+WHERE dataset_id = $1
+`
+
+func (q *Queries) DeleteAllDatasetRecords(ctx context.Context, datasetID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteAllDatasetRecords, datasetID)
 	return err
 }
 
@@ -137,6 +184,40 @@ func (q *Queries) DeleteDataset(ctx context.Context, arg DeleteDatasetParams) er
 	return err
 }
 
+const deleteDatasetRecords = `-- name: DeleteDatasetRecords :many
+DELETE FROM dataset_records
+WHERE dataset_id = $1 AND id = ANY($2::uuid[])
+RETURNING row_index
+`
+
+type DeleteDatasetRecordsParams struct {
+	DatasetID uuid.UUID
+	Ids       []uuid.UUID
+}
+
+func (q *Queries) DeleteDatasetRecords(ctx context.Context, arg DeleteDatasetRecordsParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, deleteDatasetRecords, arg.DatasetID, pq.Array(arg.Ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var row_index int64
+		if err := rows.Scan(&row_index); err != nil {
+			return nil, err
+		}
+		items = append(items, row_index)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const deleteDatasetField = `-- name: DeleteDatasetField :exec
 DELETE FROM dataset_fields
 WHERE id = $1 AND dataset_id = $2
@@ -152,8 +233,32 @@ func (q *Queries) DeleteDatasetField(ctx context.Context, arg DeleteDatasetField
 	return err
 }
 
+const deleteOrphanedPendingDatasets = `-- name: DeleteOrphanedPendingDatasets :exec
+DELETE FROM datasets d
+WHERE d.status = 'pending'
+  AND NOT EXISTS (
+    SELECT 1 FROM upload_jobs j
+    WHERE j.id = d.upload_job_id AND j.phase IN ('queued', 'parsing', 'inserting')
+  )
+`
+
+func (q *Queries) DeleteOrphanedPendingDatasets(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteOrphanedPendingDatasets)
+	return err
+}
+
+const deletePendingUploadJobDatasets = `-- name: DeletePendingUploadJobDatasets :exec
+DELETE FROM datasets
+WHERE upload_job_id = $1 AND status = 'pending'
+`
+
+func (q *Queries) DeletePendingUploadJobDatasets(ctx context.Context, uploadJobID uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, deletePendingUploadJobDatasets, uploadJobID)
+	return err
+}
+
 const getDatasetByID = `-- name: GetDatasetByID :one
-SELECT id, user_id, name, description, created_at, updated_at, public FROM datasets
+SELECT id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash FROM datasets
 WHERE id = $1
 `
 
@@ -168,12 +273,16 @@ func (q *Queries) GetDatasetByID(ctx context.Context, id uuid.UUID) (Dataset, er
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Public,
+		&i.Status,
+		&i.UploadJobID,
+		&i.Encoding,
+		&i.ContentHash,
 	)
 	return i, err
 }
 
 const getDatasetField = `-- name: GetDatasetField :one
-SELECT id, dataset_id, name, data_type, description, created_at FROM dataset_fields WHERE id = $1 AND dataset_id = $2
+SELECT id, dataset_id, name, data_type, description, created_at, type_confidence, type_counterexamples, date_format FROM dataset_fields WHERE id = $1 AND dataset_id = $2
 `
 
 type GetDatasetFieldParams struct {
@@ -191,12 +300,15 @@ func (q *Queries) GetDatasetField(ctx context.Context, arg GetDatasetFieldParams
 		&i.DataType,
 		&i.Description,
 		&i.CreatedAt,
+		&i.TypeConfidence,
+		pq.Array(&i.TypeCounterexamples),
+		&i.DateFormat,
 	)
 	return i, err
 }
 
 const getDatasetFields = `-- name: GetDatasetFields :many
-SELECT id, dataset_id, name, data_type, description, created_at FROM dataset_fields
+SELECT id, dataset_id, name, data_type, description, created_at, type_confidence, type_counterexamples, date_format FROM dataset_fields
 WHERE dataset_id = $1
 ORDER BY name
 `
@@ -217,6 +329,9 @@ func (q *Queries) GetDatasetFields(ctx context.Context, datasetID uuid.UUID) ([]
 			&i.DataType,
 			&i.Description,
 			&i.CreatedAt,
+			&i.TypeConfidence,
+			pq.Array(&i.TypeCounterexamples),
+			&i.DateFormat,
 		); err != nil {
 			return nil, err
 		}
@@ -259,9 +374,9 @@ func (q *Queries) GetDatasetFieldsForDataset(ctx context.Context, datasetID uuid
 }
 
 const getDatasetRecords = `-- name: GetDatasetRecords :many
-SELECT id, dataset_id, created_at, updated_at FROM dataset_records
+SELECT id, dataset_id, created_at, updated_at, row_index FROM dataset_records
 WHERE dataset_id = $1
-ORDER BY created_at
+ORDER BY row_index
 `
 
 func (q *Queries) GetDatasetRecords(ctx context.Context, datasetID uuid.UUID) ([]DatasetRecord, error) {
@@ -278,6 +393,196 @@ func (q *Queries) GetDatasetRecords(ctx context.Context, datasetID uuid.UUID) ([
 			&i.DatasetID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.RowIndex,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDatasetValues = `-- name: GetDatasetValues :many
+SELECT r.id AS record_id, r.row_index, v.field_id, v.value
+FROM dataset_records r
+LEFT JOIN record_values v ON v.record_id = r.id
+WHERE r.dataset_id = $1
+ORDER BY r.row_index
+`
+
+type GetDatasetValuesRow struct {
+	RecordID uuid.UUID
+	RowIndex int64
+	FieldID  uuid.NullUUID
+	Value    sql.NullString
+}
+
+func (q *Queries) GetDatasetValues(ctx context.Context, datasetID uuid.UUID) ([]GetDatasetValuesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDatasetValues, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDatasetValuesRow
+	for rows.Next() {
+		var i GetDatasetValuesRow
+		if err := rows.Scan(
+			&i.RecordID,
+			&i.RowIndex,
+			&i.FieldID,
+			&i.Value,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDatasetValuesPage = `-- name: GetDatasetValuesPage :many
+SELECT r.id AS record_id, r.row_index, v.field_id, v.value
+FROM (
+    SELECT id, row_index FROM dataset_records
+    WHERE dataset_id = $1 AND row_index > $2
+    ORDER BY row_index
+    LIMIT $3
+) r
+LEFT JOIN record_values v ON v.record_id = r.id AND v.field_id = ANY($4::uuid[])
+ORDER BY r.row_index
+`
+
+type GetDatasetValuesPageParams struct {
+	DatasetID uuid.UUID
+	After     int64
+	Limit     int32
+	FieldIds  []uuid.UUID
+}
+
+type GetDatasetValuesPageRow struct {
+	RecordID uuid.UUID
+	RowIndex int64
+	FieldID  uuid.NullUUID
+	Value    sql.NullString
+}
+
+func (q *Queries) GetDatasetValuesPage(ctx context.Context, arg GetDatasetValuesPageParams) ([]GetDatasetValuesPageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDatasetValuesPage,
+		arg.DatasetID,
+		arg.After,
+		arg.Limit,
+		pq.Array(arg.FieldIds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDatasetValuesPageRow
+	for rows.Next() {
+		var i GetDatasetValuesPageRow
+		if err := rows.Scan(
+			&i.RecordID,
+			&i.RowIndex,
+			&i.FieldID,
+			&i.Value,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDatasetsByContentHash = `-- name: GetDatasetsByContentHash :many
+SELECT id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash FROM datasets
+WHERE user_id = $1 AND content_hash = $2 AND status = 'ready'
+ORDER BY created_at
+`
+
+type GetDatasetsByContentHashParams struct {
+	UserID      uuid.UUID
+	ContentHash sql.NullString
+}
+
+func (q *Queries) GetDatasetsByContentHash(ctx context.Context, arg GetDatasetsByContentHashParams) ([]Dataset, error) {
+	rows, err := q.db.QueryContext(ctx, getDatasetsByContentHash, arg.UserID, arg.ContentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Dataset
+	for rows.Next() {
+		var i Dataset
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Description,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Public,
+			&i.Status,
+			&i.UploadJobID,
+			&i.Encoding,
+			&i.ContentHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDatasetsByUploadJob = `-- name: GetDatasetsByUploadJob :many
+SELECT id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash FROM datasets
+WHERE upload_job_id = $1
+ORDER BY created_at
+`
+
+func (q *Queries) GetDatasetsByUploadJob(ctx context.Context, uploadJobID uuid.NullUUID) ([]Dataset, error) {
+	rows, err := q.db.QueryContext(ctx, getDatasetsByUploadJob, uploadJobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Dataset
+	for rows.Next() {
+		var i Dataset
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Description,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Public,
+			&i.Status,
+			&i.UploadJobID,
+			&i.Encoding,
+			&i.ContentHash,
 		); err != nil {
 			return nil, err
 		}
@@ -293,19 +598,22 @@ func (q *Queries) GetDatasetRecords(ctx context.Context, datasetID uuid.UUID) ([
 }
 
 const getFieldsByDatasetID = `-- name: GetFieldsByDatasetID :many
-SELECT id, name, data_type, description, created_at, dataset_id
+SELECT id, name, data_type, description, created_at, dataset_id, type_confidence, type_counterexamples, date_format
 FROM dataset_fields
 WHERE dataset_id = $1
 ORDER BY created_at ASC
 `
 
 type GetFieldsByDatasetIDRow struct {
-	ID          uuid.UUID
-	Name        string
-	DataType    string
-	Description sql.NullString
-	CreatedAt   time.Time
-	DatasetID   uuid.UUID
+	ID                  uuid.UUID
+	Name                string
+	DataType            string
+	Description         sql.NullString
+	CreatedAt           time.Time
+	DatasetID           uuid.UUID
+	TypeConfidence      sql.NullFloat64
+	TypeCounterexamples []string
+	DateFormat          sql.NullString
 }
 
 func (q *Queries) GetFieldsByDatasetID(ctx context.Context, datasetID uuid.UUID) ([]GetFieldsByDatasetIDRow, error) {
@@ -324,6 +632,9 @@ func (q *Queries) GetFieldsByDatasetID(ctx context.Context, datasetID uuid.UUID)
 			&i.Description,
 			&i.CreatedAt,
 			&i.DatasetID,
+			&i.TypeConfidence,
+			pq.Array(&i.TypeCounterexamples),
+			&i.DateFormat,
 		); err != nil {
 			return nil, err
 		}
@@ -338,8 +649,58 @@ func (q *Queries) GetFieldsByDatasetID(ctx context.Context, datasetID uuid.UUID)
 	return items, nil
 }
 
+const getNextRowIndex = `-- name: GetNextRowIndex :one
+SELECT COALESCE(MAX(row_index) + 1, 0)::BIGINT AS next_row_index
+FROM dataset_records
+WHERE dataset_id = $1
+`
+
+func (q *Queries) GetNextRowIndex(ctx context.Context, datasetID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getNextRowIndex, datasetID)
+	var next_row_index int64
+	err := row.Scan(&next_row_index)
+	return next_row_index, err
+}
+
+const getNumericValuesForField = `-- name: GetNumericValuesForField :many
+SELECT r.row_index, v.num_value, CASE WHEN v.num_value IS NULL THEN v.value END AS value
+FROM record_values v
+JOIN dataset_records r ON r.id = v.record_id
+WHERE v.field_id = $1 AND v.value IS NOT NULL
+ORDER BY r.row_index
+`
+
+type GetNumericValuesForFieldRow struct {
+	RowIndex int64
+	NumValue sql.NullFloat64
+	Value    sql.NullString
+}
+
+func (q *Queries) GetNumericValuesForField(ctx context.Context, fieldID uuid.UUID) ([]GetNumericValuesForFieldRow, error) {
+	rows, err := q.db.QueryContext(ctx, getNumericValuesForField, fieldID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetNumericValuesForFieldRow
+	for rows.Next() {
+		var i GetNumericValuesForFieldRow
+		if err := rows.Scan(&i.RowIndex, &i.NumValue, &i.Value); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getRecordValuesByDatasetID = `-- name: GetRecordValuesByDatasetID :many
-SELECT record_id, field_id, value
+SELECT record_id, field_id, value, num_value
 FROM record_values
 WHERE record_id IN (
     SELECT id FROM dataset_records WHERE dataset_id = $1
@@ -355,7 +716,12 @@ func (q *Queries) GetRecordValuesByDatasetID(ctx context.Context, datasetID uuid
 	var items []RecordValue
 	for rows.Next() {
 		var i RecordValue
-		if err := rows.Scan(&i.RecordID, &i.FieldID, &i.Value); err != nil {
+		if err := rows.Scan(
+			&i.RecordID,
+			&i.FieldID,
+			&i.Value,
+			&i.NumValue,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -370,7 +736,7 @@ func (q *Queries) GetRecordValuesByDatasetID(ctx context.Context, datasetID uuid
 }
 
 const getRecordValuesByRecordID = `-- name: GetRecordValuesByRecordID :many
-SELECT record_id, field_id, value FROM record_values
+SELECT record_id, field_id, value, num_value FROM record_values
 WHERE record_id = $1
 `
 
@@ -383,7 +749,46 @@ func (q *Queries) GetRecordValuesByRecordID(ctx context.Context, recordID uuid.U
 	var items []RecordValue
 	for rows.Next() {
 		var i RecordValue
-		if err := rows.Scan(&i.RecordID, &i.FieldID, &i.Value); err != nil {
+		if err := rows.Scan(
+			&i.RecordID,
+			&i.FieldID,
+			&i.Value,
+			&i.NumValue,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecordValuesForField = `-- name: GetRecordValuesForField :many
+SELECT record_id, value
+FROM record_values
+WHERE field_id = $1
+`
+
+type GetRecordValuesForFieldRow struct {
+	RecordID uuid.UUID
+	Value    sql.NullString
+}
+
+func (q *Queries) GetRecordValuesForField(ctx context.Context, fieldID uuid.UUID) ([]GetRecordValuesForFieldRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecordValuesForField, fieldID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecordValuesForFieldRow
+	for rows.Next() {
+		var i GetRecordValuesForFieldRow
+		if err := rows.Scan(&i.RecordID, &i.Value); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -398,10 +803,10 @@ func (q *Queries) GetRecordValuesByRecordID(ctx context.Context, recordID uuid.U
 }
 
 const getRecordsByDatasetID = `-- name: GetRecordsByDatasetID :many
-SELECT id, dataset_id, created_at, updated_at
+SELECT id, dataset_id, created_at, updated_at, row_index
 FROM dataset_records
 WHERE dataset_id = $1
-ORDER BY created_at ASC
+ORDER BY row_index ASC
 `
 
 func (q *Queries) GetRecordsByDatasetID(ctx context.Context, datasetID uuid.UUID) ([]DatasetRecord, error) {
@@ -418,6 +823,7 @@ func (q *Queries) GetRecordsByDatasetID(ctx context.Context, datasetID uuid.UUID
 			&i.DatasetID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.RowIndex,
 		); err != nil {
 			return nil, err
 		}
@@ -432,9 +838,49 @@ func (q *Queries) GetRecordsByDatasetID(ctx context.Context, datasetID uuid.UUID
 	return items, nil
 }
 
+const getRecordsByRowIndex = `-- name: GetRecordsByRowIndex :many
+SELECT id, row_index
+FROM dataset_records
+WHERE dataset_id = $1 AND row_index = ANY($2::bigint[])
+ORDER BY row_index
+`
+
+type GetRecordsByRowIndexParams struct {
+	DatasetID  uuid.UUID
+	RowIndexes []int64
+}
+
+type GetRecordsByRowIndexRow struct {
+	ID       uuid.UUID
+	RowIndex int64
+}
+
+func (q *Queries) GetRecordsByRowIndex(ctx context.Context, arg GetRecordsByRowIndexParams) ([]GetRecordsByRowIndexRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecordsByRowIndex, arg.DatasetID, pq.Array(arg.RowIndexes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecordsByRowIndexRow
+	for rows.Next() {
+		var i GetRecordsByRowIndexRow
+		if err := rows.Scan(&i.ID, &i.RowIndex); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listDatasetsForUser = `-- name: ListDatasetsForUser :many
-SELECT id, user_id, name, description, created_at, updated_at, public FROM datasets
-WHERE user_id = $3
+SELECT id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash FROM datasets
+WHERE user_id = $3 AND status = 'ready'
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2
 `
@@ -462,6 +908,10 @@ func (q *Queries) ListDatasetsForUser(ctx context.Context, arg ListDatasetsForUs
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Public,
+			&i.Status,
+			&i.UploadJobID,
+			&i.Encoding,
+			&i.ContentHash,
 		); err != nil {
 			return nil, err
 		}
@@ -476,9 +926,51 @@ func (q *Queries) ListDatasetsForUser(ctx context.Context, arg ListDatasetsForUs
 	return items, nil
 }
 
+const lockDataset = `-- name: LockDataset :one
+SELECT id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash FROM datasets
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockDataset(ctx context.Context, id uuid.UUID) (Dataset, error) {
+	row := q.db.QueryRowContext(ctx, lockDataset, id)
+	var i Dataset
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Description,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Public,
+		&i.Status,
+		&i.UploadJobID,
+		&i.Encoding,
+		&i.ContentHash,
+	)
+	return i, err
+}
+
+const publishUploadJobDatasets = `-- name: PublishUploadJobDatasets :exec
+UPDATE datasets
+SET status = 'ready', updated_at = $2
+WHERE upload_job_id = $1 AND status = 'pending'
+`
+
+type PublishUploadJobDatasetsParams struct {
+	UploadJobID uuid.NullUUID
+	UpdatedAt   time.Time
+}
+
+func (q *Queries) PublishUploadJobDatasets(ctx context.Context, arg PublishUploadJobDatasetsParams) error {
+	_, err := q.db.ExecContext(ctx, publishUploadJobDatasets, arg.UploadJobID, arg.UpdatedAt)
+	return err
+}
+
 const searchDatasetByName = `-- name: SearchDatasetByName :many
-SELECT id, user_id, name, description, created_at, updated_at, public FROM datasets
+SELECT id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash FROM datasets
 WHERE user_id = $1
+  AND status = 'ready'
   AND (
     $2::text IS NULL OR name ILIKE '%' || $2::text || '%'
   )
@@ -515,6 +1007,10 @@ func (q *Queries) SearchDatasetByName(ctx context.Context, arg SearchDatasetByNa
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Public,
+			&i.Status,
+			&i.UploadJobID,
+			&i.Encoding,
+			&i.ContentHash,
 		); err != nil {
 			return nil, err
 		}
@@ -529,6 +1025,57 @@ func (q *Queries) SearchDatasetByName(ctx context.Context, arg SearchDatasetByNa
 	return items, nil
 }
 
+const touchDataset = `-- name: TouchDataset :exec
+UPDATE datasets
+SET updated_at = $2
+WHERE id = $1
+`
+
+type TouchDatasetParams struct {
+	ID        uuid.UUID
+	UpdatedAt time.Time
+}
+
+func (q *Queries) TouchDataset(ctx context.Context, arg TouchDatasetParams) error {
+	_, err := q.db.ExecContext(ctx, touchDataset, arg.ID, arg.UpdatedAt)
+	return err
+}
+
+const touchDatasetRecords = `-- name: TouchDatasetRecords :many
+UPDATE dataset_records
+SET updated_at = $1
+WHERE id = ANY($2::uuid[])
+RETURNING row_index
+`
+
+type TouchDatasetRecordsParams struct {
+	UpdatedAt time.Time
+	Ids       []uuid.UUID
+}
+
+func (q *Queries) TouchDatasetRecords(ctx context.Context, arg TouchDatasetRecordsParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, touchDatasetRecords, arg.UpdatedAt, pq.Array(arg.Ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var row_index int64
+		if err := rows.Scan(&row_index); err != nil {
+			return nil, err
+		}
+		items = append(items, row_index)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const updateDataset = `-- name: UpdateDataset :one
 UPDATE datasets
 SET
@@ -536,7 +1083,7 @@ SET
     description = $2,
     updated_at = $3
 WHERE id = $4
-RETURNING id, user_id, name, description, created_at, updated_at, public
+RETURNING id, user_id, name, description, created_at, updated_at, public, status, upload_job_id, encoding, content_hash
 `
 
 type UpdateDatasetParams struct {
@@ -562,10 +1109,53 @@ func (q *Queries) UpdateDataset(ctx context.Context, arg UpdateDatasetParams) (D
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Public,
+		&i.Status,
+		&i.UploadJobID,
+		&i.Encoding,
+		&i.ContentHash,
 	)
 	return i, err
 }
 
+const updateDatasetFieldName = `-- name: UpdateDatasetFieldName :exec
+UPDATE dataset_fields
+SET name = $2
+WHERE id = $1
+`
+
+type UpdateDatasetFieldNameParams struct {
+	ID   uuid.UUID
+	Name string
+}
+
+func (q *Queries) UpdateDatasetFieldName(ctx context.Context, arg UpdateDatasetFieldNameParams) error {
+	_, err := q.db.ExecContext(ctx, updateDatasetFieldName, arg.ID, arg.Name)
+	return err
+}
+
+const updateDatasetFieldType = `-- name: UpdateDatasetFieldType :exec
+UPDATE dataset_fields
+SET data_type = $2, type_confidence = $3, type_counterexamples = $4, date_format = NULL
+WHERE id = $1
+`
+
+type UpdateDatasetFieldTypeParams struct {
+	ID                  uuid.UUID
+	DataType            string
+	TypeConfidence      sql.NullFloat64
+	TypeCounterexamples []string
+}
+
+func (q *Queries) UpdateDatasetFieldType(ctx context.Context, arg UpdateDatasetFieldTypeParams) error {
+	_, err := q.db.ExecContext(ctx, updateDatasetFieldType,
+		arg.ID,
+		arg.DataType,
+		arg.TypeConfidence,
+		pq.Array(arg.TypeCounterexamples),
+	)
+	return err
+}
+
 const updateDatasetRows = `-- name: UpdateDatasetRows :exec
 WITH updated AS (
     UPDATE dataset_records
@@ -574,7 +1164,7 @@ WITH updated AS (
     RETURNING id
 )
 UPDATE record_values
-SET value = $3
+SET value = $3, num_value = $5
 WHERE record_id IN (SELECT id FROM updated) AND field_id = $4
 `
 
@@ -583,6 +1173,7 @@ type UpdateDatasetRowsParams struct {
 	UpdatedAt time.Time
 	Value     sql.NullString
 	FieldID   uuid.UUID
+	NumValue  sql.NullFloat64
 }
 
 func (q *Queries) UpdateDatasetRows(ctx context.Context, arg UpdateDatasetRowsParams) error {
@@ -591,6 +1182,7 @@ func (q *Queries) UpdateDatasetRows(ctx context.Context, arg UpdateDatasetRowsPa
 		arg.UpdatedAt,
 		arg.Value,
 		arg.FieldID,
+		arg.NumValue,
 	)
 	return err
 }
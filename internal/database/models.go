@@ -6,11 +6,21 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type DataSource struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Name      string
+	Config    []byte
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
 type Dataset struct {
 	ID          uuid.UUID
 	UserID      uuid.UUID
@@ -19,15 +29,51 @@ type Dataset struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	Public      bool
+	Status      string
+	UploadJobID uuid.NullUUID
+	Encoding    sql.NullString
+	ContentHash sql.NullString
 }
 
 type DatasetField struct {
-	ID          uuid.UUID
-	DatasetID   uuid.UUID
-	Name        string
-	DataType    string
-	Description sql.NullString
-	CreatedAt   time.Time
+	ID                  uuid.UUID
+	DatasetID           uuid.UUID
+	Name                string
+	DataType            string
+	Description         sql.NullString
+	CreatedAt           time.Time
+	TypeConfidence      sql.NullFloat64
+	TypeCounterexamples []string
+	DateFormat          sql.NullString
+}
+
+type DatasetQuery struct {
+	DatasetID      uuid.UUID
+	DataSourceID   uuid.UUID
+	Query          string
+	MaxRows        int64
+	TimeoutSeconds int32
+	RefreshedAt    time.Time
+}
+
+type DatasetVersion struct {
+	ID        uuid.UUID
+	DatasetID uuid.UUID
+	Version   int32
+	AuthorID  uuid.NullUUID
+	Operation string
+	CreatedAt time.Time
+	RowCount  int64
+	Columns   []string
+	Fields    json.RawMessage
+}
+
+type DatasetVersionRow struct {
+	DatasetID    uuid.UUID
+	RowIndex     int64
+	FirstVersion int32
+	LastVersion  sql.NullInt32
+	Cells        json.RawMessage
 }
 
 type DatasetRecord struct {
@@ -35,6 +81,7 @@ type DatasetRecord struct {
 	DatasetID uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	RowIndex  int64
 }
 
 type PendingUser struct {
@@ -51,6 +98,48 @@ type RecordValue struct {
 	RecordID uuid.UUID
 	FieldID  uuid.UUID
 	Value    sql.NullString
+	NumValue sql.NullFloat64
+}
+
+type UploadJob struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	Filename        string
+	Phase           string
+	RowsProcessed   int64
+	RowsRejected    int64
+	RowsTotal       int64
+	BytesRead       int64
+	BytesTotal      int64
+	Error           sql.NullString
+	CreatedAt       time.Time
+	StartedAt       sql.NullTime
+	FinishedAt      sql.NullTime
+	HeartbeatAt     sql.NullTime
+	CancelRequested bool
+}
+
+type UploadRejection struct {
+	ID          uuid.UUID
+	UploadJobID uuid.UUID
+	DatasetID   uuid.NullUUID
+	RowNumber   int64
+	Raw         string
+	Reason      string
+	CreatedAt   time.Time
+}
+
+type UploadSession struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Filename    string
+	Size        int64
+	ChunkSize   int64
+	Checksum    sql.NullString
+	UploadJobID uuid.NullUUID
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	FinalizedAt sql.NullTime
 }
 
 type User struct {
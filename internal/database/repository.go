@@ -3,13 +3,19 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/lib/pq"
 )
 
 type Repository struct {
 	Queries *Queries
 	DB      *sql.DB
+
+	// tx is set on repositories handed out by InTx
+	tx *sql.Tx
 }
 
 func NewRepository(db *sql.DB) *Repository {
@@ -23,7 +29,62 @@ func (r *Repository) Exec(query string, args ...interface{}) (sql.Result, error)
 	return r.DB.Exec(query, args...)
 }
 
+// InTx runs fn with a repository whose queries all belong to one
+// transaction. The transaction commits if fn returns nil and rolls back
+// otherwise. Calling InTx on a repository that is already in a transaction
+// reuses it.
+func (r *Repository) InTx(ctx context.Context, fn func(*Repository) error) (err error) {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	txRepo := &Repository{
+		Queries: r.Queries.WithTx(tx),
+		DB:      r.DB,
+		tx:      tx,
+	}
+	if err := fn(txRepo); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) conn() DBTX {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.DB
+}
+
 func (r *Repository) BatchInsertRecordValues(ctx context.Context, values []CreateRecordValueParams) error {
+	return r.batchRecordValues(ctx, values, "")
+}
+
+// BatchUpsertRecordValues is BatchInsertRecordValues for values that may
+// already exist, in which case they are overwritten.
+func (r *Repository) BatchUpsertRecordValues(ctx context.Context, values []CreateRecordValueParams) error {
+	return r.batchRecordValues(ctx, values, " ON CONFLICT (record_id, field_id) DO UPDATE SET value = EXCLUDED.value, num_value = EXCLUDED.num_value")
+}
+
+func (r *Repository) batchRecordValues(ctx context.Context, values []CreateRecordValueParams, onConflict string) error {
 	if len(values) == 0 {
 		return nil
 	}
@@ -33,18 +94,74 @@ func (r *Repository) BatchInsertRecordValues(ctx context.Context, values []Creat
 		args         []interface{}
 	)
 
-	queryBuilder.WriteString("INSERT INTO record_values (record_id, field_id, value) VALUES ")
+	queryBuilder.WriteString("INSERT INTO record_values (record_id, field_id, value, num_value) VALUES ")
 
 	for i, v := range values {
-		offset := i * 3
-		queryBuilder.WriteString(fmt.Sprintf("($%d, $%d, $%d)", offset+1, offset+2, offset+3))
+		offset := i * 4
+		queryBuilder.WriteString(fmt.Sprintf("($%d, $%d, $%d, $%d)", offset+1, offset+2, offset+3, offset+4))
 		if i < len(values)-1 {
 			queryBuilder.WriteString(", ")
 		}
-		args = append(args, v.RecordID, v.FieldID, v.Value)
+		args = append(args, v.RecordID, v.FieldID, v.Value, v.NumValue)
 	}
+	queryBuilder.WriteString(onConflict)
 
 	query := queryBuilder.String()
-	_, err := r.DB.ExecContext(ctx, query, args...)
+	_, err := r.conn().ExecContext(ctx, query, args...)
 	return err
 }
+
+// CopyRecords loads records and their values with COPY FROM STDIN, which is
+// far faster than INSERT for large imports and has no parameter limit. COPY
+// holds the connection until it finishes, so it runs in a transaction,
+// starting one when the repository is not already in one.
+func (r *Repository) CopyRecords(ctx context.Context, records []CreateDatasetRecordParams, values []CreateRecordValueParams) error {
+	if r.tx == nil {
+		return r.InTx(ctx, func(tx *Repository) error {
+			return tx.CopyRecords(ctx, records, values)
+		})
+	}
+
+	err := r.copyIn(ctx, pq.CopyIn("dataset_records", "id", "dataset_id", "created_at", "updated_at", "row_index"), len(records), func(i int) []interface{} {
+		rec := records[i]
+		return []interface{}{rec.ID, rec.DatasetID, rec.CreatedAt, rec.UpdatedAt, rec.RowIndex}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy records: %w", err)
+	}
+
+	// Records go first so the values' foreign keys hold
+	err = r.copyIn(ctx, pq.CopyIn("record_values", "record_id", "field_id", "value", "num_value"), len(values), func(i int) []interface{} {
+		v := values[i]
+		return []interface{}{v.RecordID, v.FieldID, v.Value, v.NumValue}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy record values: %w", err)
+	}
+	return nil
+}
+
+// copyIn streams n rows through a COPY statement. row returns the columns of
+// the i-th row.
+func (r *Repository) copyIn(ctx context.Context, query string, n int, row func(i int) []interface{}) error {
+	if n == 0 {
+		return nil
+	}
+
+	stmt, err := r.tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if _, err := stmt.ExecContext(ctx, row(i)...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	// An Exec without arguments sends the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
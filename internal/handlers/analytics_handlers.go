@@ -7,6 +7,7 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,29 +18,12 @@ import (
 	"github.com/Bgoodwin24/insightforge/internal/analytics/descriptives"
 	"github.com/Bgoodwin24/insightforge/internal/analytics/distribution"
 	"github.com/Bgoodwin24/insightforge/internal/analytics/outliers"
+	"github.com/Bgoodwin24/insightforge/internal/database"
 	"github.com/Bgoodwin24/insightforge/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-func convertToFloat64(val interface{}) (float64, bool) {
-	switch v := val.(type) {
-	case float64:
-		return v, true
-	case float32:
-		return float64(v), true
-	case int:
-		return float64(v), true
-	case int64:
-		return float64(v), true
-	case string:
-		f, err := strconv.ParseFloat(v, 64)
-		return f, err == nil
-	default:
-		return 0, false
-	}
-}
-
 type AnalyticsHandler struct {
 	Service        *services.DatasetService
 	DatasetService *services.DatasetService
@@ -62,35 +46,7 @@ func GetUserFromContext(c *gin.Context) (uuid.UUID, error) {
 
 // Aggregation
 func (h *AnalyticsHandler) GroupDatasetBy(ctx context.Context, datasetID, userID uuid.UUID, groupBy, column string) (map[string][]float64, error) {
-	header, rows, err := h.Service.GetDatasetRows(ctx, datasetID, userID)
-	if err != nil {
-		return nil, err
-	}
-
-	// Find index of groupBy and column
-	groupByIdx, columnIdx := -1, -1
-	for i, col := range header {
-		if col == groupBy {
-			groupByIdx = i
-		}
-		if col == column {
-			columnIdx = i
-		}
-	}
-	if groupByIdx == -1 || columnIdx == -1 {
-		return nil, fmt.Errorf("group_by or column not found in dataset")
-	}
-
-	grouped := make(map[string][]float64)
-	for _, row := range rows {
-		group := row[groupByIdx]
-		val, ok := convertToFloat64(row[columnIdx])
-		if !ok {
-			continue
-		}
-		grouped[group] = append(grouped[group], val)
-	}
-	return grouped, nil
+	return h.Service.GroupColumn(ctx, datasetID, userID, groupBy, column)
 }
 
 func (h *AnalyticsHandler) GroupedSumHandler(c *gin.Context) {
@@ -108,13 +64,13 @@ func (h *AnalyticsHandler) GroupedSumHandler(c *gin.Context) {
 		return
 	}
 
-	grouped, err := h.GroupDatasetBy(c.Request.Context(), datasetID, userID, groupBy, column)
+	results, err := h.Service.GroupedStat(c.Request.Context(), datasetID, userID, groupBy, column, services.StatSum)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedSum(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
 func (h *AnalyticsHandler) GroupedMeanHandler(c *gin.Context) {
@@ -131,13 +87,13 @@ func (h *AnalyticsHandler) GroupedMeanHandler(c *gin.Context) {
 		return
 	}
 
-	grouped, err := h.GroupDatasetBy(c.Request.Context(), id, userID, groupBy, column)
+	results, err := h.Service.GroupedStat(c.Request.Context(), id, userID, groupBy, column, services.StatMean)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedMean(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
 func (h *AnalyticsHandler) GroupedCountHandler(c *gin.Context) {
@@ -154,32 +110,13 @@ func (h *AnalyticsHandler) GroupedCountHandler(c *gin.Context) {
 		return
 	}
 
-	header, rows, err := h.Service.GetDatasetRows(c, id, userID)
+	counts, err := h.Service.GroupedCount(c, id, userID, groupBy)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Find the index of groupBy
-	groupByIdx := -1
-	for i, col := range header {
-		if col == groupBy {
-			groupByIdx = i
-			break
-		}
-	}
-	if groupByIdx == -1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by column not found in dataset"})
-		return
-	}
-
-	grouped := make(map[string][]float64)
-	for _, row := range rows {
-		group := row[groupByIdx]
-		grouped[group] = append(grouped[group], 1)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedCount(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": counts})
 }
 
 func (h *AnalyticsHandler) GroupedMinHandler(c *gin.Context) {
@@ -196,13 +133,13 @@ func (h *AnalyticsHandler) GroupedMinHandler(c *gin.Context) {
 		return
 	}
 
-	grouped, err := h.GroupDatasetBy(c.Request.Context(), id, userID, groupBy, column)
+	results, err := h.Service.GroupedStat(c.Request.Context(), id, userID, groupBy, column, services.StatMin)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedMin(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
 func (h *AnalyticsHandler) GroupedMaxHandler(c *gin.Context) {
@@ -219,13 +156,13 @@ func (h *AnalyticsHandler) GroupedMaxHandler(c *gin.Context) {
 		return
 	}
 
-	grouped, err := h.GroupDatasetBy(c.Request.Context(), id, userID, groupBy, column)
+	results, err := h.Service.GroupedStat(c.Request.Context(), id, userID, groupBy, column, services.StatMax)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedMax(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
 func (h *AnalyticsHandler) GroupedMedianHandler(c *gin.Context) {
@@ -242,13 +179,13 @@ func (h *AnalyticsHandler) GroupedMedianHandler(c *gin.Context) {
 		return
 	}
 
-	grouped, err := h.GroupDatasetBy(c.Request.Context(), id, userID, groupBy, column)
+	results, err := h.Service.GroupedStat(c.Request.Context(), id, userID, groupBy, column, services.StatMedian)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedMedian(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
 func (h *AnalyticsHandler) GroupedStdDevHandler(c *gin.Context) {
@@ -265,13 +202,13 @@ func (h *AnalyticsHandler) GroupedStdDevHandler(c *gin.Context) {
 		return
 	}
 
-	grouped, err := h.GroupDatasetBy(c.Request.Context(), id, userID, groupBy, column)
+	results, err := h.Service.GroupedStat(c.Request.Context(), id, userID, groupBy, column, services.StatStdDev)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": aggregation.GroupedStdDev(grouped)})
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
 
 type PivotRequest struct {
@@ -439,7 +376,20 @@ func (h *DatasetHandler) DropRowsWithMissingHandler(c *gin.Context) {
 	full := append([][]string{header}, rows...)
 	cleanedRows := cleaning.DropRowsWithMissing(full, req.Columns)
 
-	c.JSON(http.StatusOK, gin.H{"rows": cleanedRows})
+	operation := "drop rows with missing values in " + strings.Join(req.Columns, ", ")
+	version, ok := h.persistCleaning(c, datasetID, operation, func(header []string, rows [][]string) ([][]string, error) {
+		for _, col := range req.Columns {
+			if !slices.Contains(header, col) {
+				return nil, fmt.Errorf("%w: %s", services.ErrUnknownColumn, col)
+			}
+		}
+		return cleaning.DropRowsWithMissing(append([][]string{header}, rows...), req.Columns)[1:], nil
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, withVersion(gin.H{"rows": cleanedRows}, version))
 }
 
 func (h *DatasetHandler) FillMissingWithHandler(c *gin.Context) {
@@ -469,7 +419,16 @@ func (h *DatasetHandler) FillMissingWithHandler(c *gin.Context) {
 	}
 
 	cleanedRows := cleaning.FillMissingWith(rows, defaultValue)
-	c.JSON(http.StatusOK, gin.H{"rows": cleanedRows})
+
+	operation := fmt.Sprintf("fill missing values with %q", defaultValue)
+	version, ok := h.persistCleaning(c, datasetID, operation, func(header []string, rows [][]string) ([][]string, error) {
+		return cleaning.FillMissingWith(rows, defaultValue), nil
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, withVersion(gin.H{"rows": cleanedRows}, version))
 }
 
 func (h *DatasetHandler) ApplyLogTransformationHandler(c *gin.Context) {
@@ -499,7 +458,7 @@ func (h *DatasetHandler) ApplyLogTransformationHandler(c *gin.Context) {
 		return
 	}
 
-	_, rows, err := h.Service.GetDatasetRows(c, datasetID, userID)
+	header, rows, err := h.Service.GetDatasetRows(c, datasetID, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dataset"})
 		return
@@ -511,7 +470,14 @@ func (h *DatasetHandler) ApplyLogTransformationHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"rows": transformedRows})
+	version, ok := h.persistCleaning(c, datasetID, "log transform column "+columnLabel(header, col), func(header []string, rows [][]string) ([][]string, error) {
+		return cleaning.ApplyLogTransformation(rows, col)
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, withVersion(gin.H{"rows": transformedRows}, version))
 }
 
 func (h *DatasetHandler) NormalizeColumnHandler(c *gin.Context) {
@@ -538,7 +504,7 @@ func (h *DatasetHandler) NormalizeColumnHandler(c *gin.Context) {
 		return
 	}
 
-	_, rows, err := h.Service.GetDatasetRows(c, datasetID, userID)
+	header, rows, err := h.Service.GetDatasetRows(c, datasetID, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dataset rows"})
 		return
@@ -550,6 +516,20 @@ func (h *DatasetHandler) NormalizeColumnHandler(c *gin.Context) {
 		return
 	}
 
+	version, ok := h.persistCleaning(c, datasetID, "normalize column "+columnLabel(header, req.Column), func(header []string, rows [][]string) ([][]string, error) {
+		normalized, err := cleaning.NormalizeColumn(rows, req.Column)
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			row[req.Column] = strconv.FormatFloat(normalized[i], 'f', -1, 64)
+		}
+		return rows, nil
+	})
+	if !ok {
+		return
+	}
+
 	// Build Chart.js-compatible response
 	var resultRows [][]any
 	for i, val := range normalized {
@@ -563,9 +543,9 @@ func (h *DatasetHandler) NormalizeColumnHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, withVersion(gin.H{
 		"rows": resultRows,
-	})
+	}, version))
 }
 
 func (h *DatasetHandler) StandardizeColumnHandler(c *gin.Context) {
@@ -607,6 +587,13 @@ func (h *DatasetHandler) StandardizeColumnHandler(c *gin.Context) {
 		return
 	}
 
+	version, ok := h.persistCleaning(c, datasetID, "standardize column "+headers[req.Column], func(header []string, rows [][]string) ([][]string, error) {
+		return cleaning.StandardizeColumn(rows, req.Column)
+	})
+	if !ok {
+		return
+	}
+
 	// Convert to float64 slice for Chart.js-compatible JSON
 	columnData := make([]float64, 0, len(standardized))
 	for _, row := range standardized {
@@ -620,11 +607,13 @@ func (h *DatasetHandler) StandardizeColumnHandler(c *gin.Context) {
 
 	colName := headers[req.Column]
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, withVersion(gin.H{
 		colName: columnData,
-	})
+	}, version))
 }
 
+// DropColumnsHandler deletes the columns whose field IDs are given as
+// {"columns": [...]}, recording the drop as a version of the dataset.
 func (h *DatasetHandler) DropColumnsHandler(c *gin.Context) {
 	datasetIDStr := c.Param("dataset_id")
 	datasetID, err := uuid.Parse(datasetIDStr)
@@ -644,24 +633,24 @@ func (h *DatasetHandler) DropColumnsHandler(c *gin.Context) {
 		return
 	}
 
-	for _, fieldID := range req.Columns {
-		err := h.Service.DeleteFieldFromDataset(c, datasetID, fieldID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete column with ID %s", fieldID)})
-			return
-		}
+	dataset, ok := h.persistTarget(c, datasetID)
+	if !ok {
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Columns dropped successfully"})
-}
-
-func (h *DatasetHandler) RenameColumnsHandler(c *gin.Context) {
-	userID, err := GetUserFromContext(c)
+	version, err := h.Service.DropColumns(c.Request.Context(), datasetID, dataset.UserID, req.Columns)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		versionError(c, err)
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"message": "Columns dropped successfully", "version": version.Version})
+}
+
+// RenameColumnsHandler gives the columns the names in {"new_headers": [...]},
+// listed in column order. Without "persist" it previews the rename on the
+// rows; with it the columns of the dataset are renamed as a version.
+func (h *DatasetHandler) RenameColumnsHandler(c *gin.Context) {
 	datasetIDStr := c.Param("dataset_id")
 	datasetID, err := uuid.Parse(datasetIDStr)
 	if err != nil {
@@ -677,6 +666,26 @@ func (h *DatasetHandler) RenameColumnsHandler(c *gin.Context) {
 		return
 	}
 
+	if isTruthy(c.Query("persist")) {
+		dataset, ok := h.persistTarget(c, datasetID)
+		if !ok {
+			return
+		}
+		version, err := h.Service.RenameColumns(c.Request.Context(), datasetID, dataset.UserID, req.NewHeaders)
+		if err != nil {
+			versionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Columns renamed successfully", "version": version.Version})
+		return
+	}
+
+	userID, err := GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
 	_, rows, err := h.Service.GetDatasetRows(c, datasetID, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dataset rows"})
@@ -695,6 +704,56 @@ func (h *DatasetHandler) RenameColumnsHandler(c *gin.Context) {
 	})
 }
 
+// persistCleaning saves a cleaning step to the dataset as a version when the
+// "persist" query parameter asks for it, responding with an error if that
+// fails. It returns the number of the version, or 0 when the step is only
+// previewed.
+func (h *DatasetHandler) persistCleaning(c *gin.Context, datasetID uuid.UUID, operation string, clean services.CleaningStep) (int32, bool) {
+	if !isTruthy(c.Query("persist")) {
+		return 0, true
+	}
+	dataset, ok := h.persistTarget(c, datasetID)
+	if !ok {
+		return 0, false
+	}
+
+	version, err := h.Service.CleanDataset(c.Request.Context(), datasetID, dataset.UserID, operation, clean)
+	if err != nil {
+		versionError(c, err)
+		return 0, false
+	}
+	return version.Version, true
+}
+
+// persistTarget returns the dataset a persisted change is saved to,
+// responding with an error if it cannot be. Changes made to an earlier
+// version cannot be saved.
+func (h *DatasetHandler) persistTarget(c *gin.Context, datasetID uuid.UUID) (*database.Dataset, bool) {
+	if c.Query("version") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "changes to earlier versions cannot be persisted; roll back first"})
+		return nil, false
+	}
+	return h.CheckDatasetOwnership(c, datasetID)
+}
+
+// withVersion adds the number of the version a persisted cleaning step
+// created to its response.
+func withVersion(resp gin.H, version int32) gin.H {
+	if version > 0 {
+		resp["version"] = version
+	}
+	return resp
+}
+
+// columnLabel names a column given by index in the description of a
+// cleaning step.
+func columnLabel(header []string, col int) string {
+	if col >= 0 && col < len(header) {
+		return header[col]
+	}
+	return "#" + strconv.Itoa(col)
+}
+
 // Correlation
 func TransposeFloat(data [][]float64) [][]float64 {
 	if len(data) == 0 {
@@ -991,17 +1050,10 @@ func (h *AnalyticsHandler) MeanHandler(c *gin.Context) {
 		return
 	}
 
-	// Call service to get numeric data
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
-
 	// Calculate mean
-	mean, err := descriptives.Mean(data)
+	mean, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatMean)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -1036,18 +1088,12 @@ func (h *AnalyticsHandler) MedianHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	median, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatMedian)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	median, err := descriptives.Median(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1152,18 +1198,12 @@ func (h *AnalyticsHandler) StdDevHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	stddev, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatStdDev)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	stddev, err := descriptives.StdDev(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1194,18 +1234,12 @@ func (h *AnalyticsHandler) VarianceHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	variance, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatVariance)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	variance, err := descriptives.Variance(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1236,18 +1270,12 @@ func (h *AnalyticsHandler) MinHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	min, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatMin)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	min, err := descriptives.Min(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1278,18 +1306,12 @@ func (h *AnalyticsHandler) MaxHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	max, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatMax)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	max, err := descriptives.Max(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1320,18 +1342,12 @@ func (h *AnalyticsHandler) RangeHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	rng, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatRange)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	rng, err := descriptives.Range(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1362,18 +1378,12 @@ func (h *AnalyticsHandler) SumHandler(c *gin.Context) {
 		return
 	}
 
-	data, err := h.DatasetService.GetNumericColumnValues(c, datasetID, userID, column)
+	sum, err := h.DatasetService.ColumnStat(c, datasetID, userID, column, services.StatSum)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	sum, err := descriptives.Sum(data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
 	c.JSON(200, gin.H{
 		"results": []map[string]interface{}{
 			{
@@ -1398,7 +1408,7 @@ func (h *AnalyticsHandler) CountHandler(c *gin.Context) {
 		return
 	}
 
-	_, rows, err := h.Service.GetDatasetRows(c, datasetID, userID)
+	count, err := h.Service.CountRows(c, datasetID, userID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -1408,7 +1418,7 @@ func (h *AnalyticsHandler) CountHandler(c *gin.Context) {
 		"results": []map[string]interface{}{
 			{
 				"label": "count",
-				"value": len(rows),
+				"value": count,
 			},
 		},
 	})
@@ -1642,13 +1652,7 @@ func (h *AnalyticsHandler) ZScoreOutliersHandler(c *gin.Context) {
 		return
 	}
 
-	_, _, err = h.DatasetService.GetDatasetRows(c.Request.Context(), userID, datasetID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	data, err := h.DatasetService.GetNumericColumnValues(c.Request.Context(), datasetID, userID, columnName)
+	data, rowIndexes, err := h.DatasetService.GetNumericColumn(c.Request.Context(), datasetID, userID, columnName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -1660,10 +1664,7 @@ func (h *AnalyticsHandler) ZScoreOutliersHandler(c *gin.Context) {
 		return
 	}
 
-	if indices == nil {
-		indices = []int{}
-	}
-	c.JSON(http.StatusOK, gin.H{"indices": indices})
+	c.JSON(http.StatusOK, gin.H{"indices": toRowIndexes(indices, rowIndexes)})
 }
 
 func (h *AnalyticsHandler) IQROutliersHandler(c *gin.Context) {
@@ -1686,13 +1687,7 @@ func (h *AnalyticsHandler) IQROutliersHandler(c *gin.Context) {
 		return
 	}
 
-	_, _, err = h.DatasetService.GetDatasetRows(c.Request.Context(), userID, datasetID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	data, err := h.DatasetService.GetNumericColumnValues(c.Request.Context(), datasetID, userID, columnName)
+	data, rowIndexes, err := h.DatasetService.GetNumericColumn(c.Request.Context(), datasetID, userID, columnName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -1704,12 +1699,8 @@ func (h *AnalyticsHandler) IQROutliersHandler(c *gin.Context) {
 		return
 	}
 
-	if indices == nil {
-		indices = []int{}
-	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"indices":    indices,
+		"indices":    toRowIndexes(indices, rowIndexes),
 		"column":     columnName,
 		"lowerBound": lowerBound,
 		"upperBound": upperBound,
@@ -1736,12 +1727,6 @@ func (h *AnalyticsHandler) BoxPlotHandler(c *gin.Context) {
 		return
 	}
 
-	_, _, err = h.DatasetService.GetDatasetRows(c.Request.Context(), userID, datasetID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
 	data, err := h.DatasetService.GetNumericColumnValues(c.Request.Context(), datasetID, userID, columnName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -1769,6 +1754,16 @@ func (h *AnalyticsHandler) BoxPlotHandler(c *gin.Context) {
 	})
 }
 
+// toRowIndexes maps positions in a column's values to the row indexes of
+// the rows they came from, which stay the same between requests.
+func toRowIndexes(positions []int, rowIndexes []int64) []int64 {
+	out := make([]int64, len(positions))
+	for i, p := range positions {
+		out[i] = rowIndexes[p]
+	}
+	return out
+}
+
 func Transpose(data [][]string) [][]string {
 	if len(data) == 0 {
 		return [][]string{}
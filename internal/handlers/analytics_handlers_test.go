@@ -1730,6 +1730,7 @@ func TestFilterSortHandler(t *testing.T) {
 	db := testutils.SetupDB()
 	repo := database.NewRepository(db)
 	datasetService := services.NewDatasetService(repo)
+	datasetService.Cache = services.NewDatasetCache(1 << 20)
 	handler := handlers.NewDatasetHandler(datasetService)
 
 	testutils.CleanDB(repo)
@@ -1794,6 +1795,21 @@ func TestFilterSortHandler(t *testing.T) {
 	}
 
 	assert.Equal(t, expected, resp.Data)
+
+	// Sorting without a filter must not reorder the cached dataset
+	url = fmt.Sprintf("/analytics/filtersort/filter-sort?dataset_id=%s&sort_by=age&order=desc", dataset.ID.String())
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.AddCookie(&http.Cookie{Name: "token", Value: token, Path: "/"})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Charlie", resp.Data[0]["name"])
+
+	_, rows, err := datasetService.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"Alice", "24"}, {"Bob", "30"}, {"Charlie", "35"}}, rows)
+	assert.Equal(t, uint64(2), datasetService.Cache.Stats().Hits)
 }
 
 func TestZScoreOutliersHandler(t *testing.T) {
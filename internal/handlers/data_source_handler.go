@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateDataSource saves a connection to an external PostgreSQL database.
+// The JSON body gives a "name" and the "host", "port", "database", "user",
+// "password" and "sslmode" to connect with.
+func (h *DatasetHandler) CreateDataSource(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+		services.PostgresConnection
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	source, err := h.Service.CreateDataSource(c, userID, input.Name, input.PostgresConnection)
+	if err != nil {
+		dataSourceError(c, err, "failed to save data source")
+		return
+	}
+	c.JSON(http.StatusCreated, source)
+}
+
+// ListDataSources lists the user's data sources, without their passwords.
+func (h *DatasetHandler) ListDataSources(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sources, err := h.Service.ListDataSources(c, userID)
+	if err != nil {
+		dataSourceError(c, err, "failed to list data sources")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data_sources": sources})
+}
+
+// DeleteDataSource removes a data source.
+func (h *DatasetHandler) DeleteDataSource(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data source ID"})
+		return
+	}
+
+	if err := h.Service.DeleteDataSource(c, userID, sourceID); err != nil {
+		dataSourceError(c, err, "failed to delete data source")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "data source deleted"})
+}
+
+// QueryDataSource runs a read-only SELECT against a data source and stores
+// the result as a dataset. The JSON body gives the "query", optionally a
+// "name" and "description", "max_rows" and "timeout_seconds". With a
+// "dataset_id" the rows of that dataset are replaced instead, and the query
+// may be left out to rerun the one it was created from.
+func (h *DatasetHandler) QueryDataSource(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data source ID"})
+		return
+	}
+
+	var input struct {
+		Query          string     `json:"query"`
+		Name           string     `json:"name"`
+		Description    string     `json:"description"`
+		MaxRows        int64      `json:"max_rows"`
+		TimeoutSeconds int64      `json:"timeout_seconds"`
+		DatasetID      *uuid.UUID `json:"dataset_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	q := services.SourceQuery{
+		Query:       input.Query,
+		Name:        input.Name,
+		Description: input.Description,
+		MaxRows:     input.MaxRows,
+		Timeout:     time.Duration(input.TimeoutSeconds) * time.Second,
+	}
+	if input.DatasetID != nil {
+		if _, ok := h.CheckDatasetOwnership(c, *input.DatasetID); !ok {
+			return
+		}
+		q.DatasetID = uuid.NullUUID{UUID: *input.DatasetID, Valid: true}
+	}
+
+	dataset, err := h.Service.ImportFromDataSource(c, userID, sourceID, q)
+	if err != nil {
+		dataSourceError(c, err, "failed to import from data source")
+		return
+	}
+
+	columns, err := h.Service.GetColumnsForDataset(c, dataset.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get dataset columns"})
+		return
+	}
+	status := http.StatusCreated
+	if q.DatasetID.Valid {
+		status = http.StatusOK
+	}
+	c.JSON(status, DatasetResponse{
+		ID:      dataset.ID,
+		Name:    dataset.Name,
+		Columns: columns,
+	})
+}
+
+// dataSourceError writes the response for a failed data source request.
+func dataSourceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrDataSourcesDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDataSourceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "data source not found"})
+	case errors.Is(err, services.ErrInvalidDataSource), errors.Is(err, services.ErrInvalidSourceQuery):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSourceRowLimit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Bgoodwin24/insightforge/internal/database"
 	"github.com/Bgoodwin24/insightforge/internal/services"
@@ -115,6 +121,11 @@ func (h *DatasetHandler) ListDatasets(c *gin.Context) {
 	c.JSON(http.StatusOK, datasets)
 }
 
+// GetCacheStats reports the hits, misses and size of the dataset cache.
+func (h *DatasetHandler) GetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Service.Cache.Stats())
+}
+
 func (h *DatasetHandler) SearchDataSets(c *gin.Context) {
 	userID, ok := GetUserIDFromContext(c)
 	if !ok {
@@ -156,9 +167,12 @@ func GetUserIDFromContext(c *gin.Context) (uuid.UUID, bool) {
 }
 
 type DatasetResponse struct {
-	ID      uuid.UUID `json:"id"`
-	Name    string    `json:"name"`
-	Columns []string  `json:"columns"`
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	Columns      []string  `json:"columns"`
+	UploadID     uuid.UUID `json:"upload_id"`
+	RowsRejected int64     `json:"rows_rejected"`
+	Encoding     string    `json:"encoding,omitempty"`
 }
 
 func (h *DatasetHandler) UploadDataset(c *gin.Context) {
@@ -168,48 +182,730 @@ func (h *DatasetHandler) UploadDataset(c *gin.Context) {
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
+	file, header, ok := formUploadFile(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	opts, err := parseImportOptions(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A file that was uploaded before is refused with the IDs of its
+	// datasets, unless force=true asks for a duplicate
+	opts.ContentHash, err = services.HashUpload(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+		return
+	}
+	if !isTruthy(c.DefaultPostForm("force", c.Query("force"))) {
+		duplicates, err := h.Service.FindDuplicateUploads(c, userID, opts.ContentHash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check for duplicate uploads"})
+			return
+		}
+		if len(duplicates) > 0 {
+			duplicateUpload(c, duplicates)
+			return
+		}
+	}
+
+	if isTruthy(c.DefaultPostForm("async", c.Query("async"))) {
+		job, err := h.Service.StartUploadJob(c, userID, header.Filename, file, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to start upload: %v", err)})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id": job.ID,
+			"phase":  job.Phase,
+		})
+		return
+	}
+
+	status, datasets, err := h.Service.ImportUpload(c, userID, header.Filename, file, opts)
+	if errors.Is(err, services.ErrInvalidSchema) || errors.Is(err, services.ErrInvalidDialect) ||
+		errors.Is(err, services.ErrArchiveTooLarge) || errors.Is(err, services.ErrEmptyArchive) ||
+		errors.Is(err, services.ErrInvalidSQLiteSource) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "upload_id": status.Job.ID})
+		return
+	}
+	if errors.Is(err, services.ErrTooManyRejections) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":         err.Error(),
+			"upload_id":     status.Job.ID,
+			"rows_rejected": status.Job.RowsRejected,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to upload dataset: %v", err)})
+		return
+	}
+
+	responses := make([]DatasetResponse, 0, len(datasets))
+	for _, dataset := range datasets {
+		columns, err := h.Service.GetColumnsForDataset(c, dataset.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get dataset columns"})
+			return
+		}
+		responses = append(responses, DatasetResponse{
+			ID:           dataset.ID,
+			Name:         dataset.Name,
+			Columns:      columns,
+			UploadID:     status.Job.ID,
+			RowsRejected: status.Job.RowsRejected,
+			Encoding:     dataset.Encoding.String,
+		})
+	}
+
+	// Workbooks can produce a dataset per sheet
+	if len(responses) == 1 {
+		c.JSON(http.StatusCreated, responses[0])
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"datasets": responses})
+}
+
+// ListSQLiteTables lists the tables and views of an uploaded SQLite
+// database with their columns, so one can be chosen for UploadDataset.
+func (h *DatasetHandler) ListSQLiteTables(c *gin.Context) {
+	if _, ok := GetUserIDFromContext(c); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	file, _, ok := formUploadFile(c)
+	if !ok {
 		return
 	}
 	defer file.Close()
 
+	tables, err := h.Service.ListSQLiteTables(c, file)
+	if errors.Is(err, services.ErrInvalidSQLiteSource) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read SQLite database"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tables": tables})
+}
+
+// formUploadFile opens the "file" form field, enforcing MAX_UPLOAD_SIZE. It
+// writes the error response itself when it fails.
+func formUploadFile(c *gin.Context) (multipart.File, *multipart.FileHeader, bool) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed"})
+		return nil, nil, false
+	}
+
+	limitSize, err := maxUploadSize()
+	if err != nil {
+		file.Close()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	if header.Size > limitSize {
+		file.Close()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file too large"})
+		return nil, nil, false
+	}
+
+	return file, header, true
+}
+
+// maxUploadSize reads MAX_UPLOAD_SIZE, which also caps how far compressed
+// uploads may expand.
+func maxUploadSize() (int64, error) {
 	limitSizeStr := os.Getenv("MAX_UPLOAD_SIZE")
 	if limitSizeStr == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "MAX_UPLOAD_SIZE is not set in .env"})
-		return
+		return 0, errors.New("MAX_UPLOAD_SIZE is not set in .env")
 	}
 	limitSize, err := strconv.ParseInt(limitSizeStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid MAX_UPLOAD_SIZE setting"})
+		return 0, errors.New("invalid MAX_UPLOAD_SIZE setting")
+	}
+	return limitSize, nil
+}
+
+// AppendDataset adds the rows of a CSV or TSV file to an existing dataset.
+// The optional "match" form field is "strict" (the default) or "lenient",
+// and "add_columns=true" creates fields for columns the dataset lacks.
+func (h *DatasetHandler) AppendDataset(c *gin.Context) {
+	h.appendFile(c, false)
+}
+
+// MergeDataset upserts the rows of a CSV or TSV file into an existing
+// dataset. The "key" form field (repeated or comma separated) names the
+// columns identifying a row, and "delete_missing=true" removes rows whose key
+// is not in the file. It accepts the same options as AppendDataset.
+func (h *DatasetHandler) MergeDataset(c *gin.Context) {
+	h.appendFile(c, true)
+}
+
+func (h *DatasetHandler) appendFile(c *gin.Context, merge bool) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
 		return
 	}
 
-	if header.Size > limitSize {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	if _, authorized := h.CheckDatasetOwnership(c, datasetID); !authorized {
+		return
+	}
+
+	file, header, ok := formUploadFile(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	importOpts, err := parseImportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts := services.AppendOptions{
+		ImportOptions: importOpts,
+		Match:         c.DefaultPostForm("match", services.ColumnMatchStrict),
+		AddColumns:    isTruthy(c.PostForm("add_columns")),
+	}
+	if opts.Match != services.ColumnMatchStrict && opts.Match != services.ColumnMatchLenient {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "match must be strict or lenient"})
+		return
+	}
+	if merge {
+		opts.Keys = splitFormList(c.PostFormArray("key"))
+		opts.DeleteMissing = isTruthy(c.PostForm("delete_missing"))
+		if len(opts.Keys) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one key column is required"})
+			return
+		}
+	}
+
+	status, result, err := h.Service.AppendUpload(c, userID, datasetID, header.Filename, file, opts)
+	switch {
+	case errors.Is(err, services.ErrColumnMismatch), errors.Is(err, services.ErrInvalidSchema), errors.Is(err, services.ErrInvalidDialect):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "upload_id": status.Job.ID})
+		return
+	case errors.Is(err, services.ErrDuplicateKey):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "upload_id": status.Job.ID})
+		return
+	case errors.Is(err, services.ErrTooManyRejections):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":         err.Error(),
+			"upload_id":     status.Job.ID,
+			"rows_rejected": status.Job.RowsRejected,
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to append to dataset: %v", err)})
+		return
+	}
+
+	addedColumns := result.AddedColumns
+	if addedColumns == nil {
+		addedColumns = []string{}
+	}
+	resp := gin.H{
+		"id":            datasetID,
+		"upload_id":     status.Job.ID,
+		"rows_rejected": result.RowsRejected,
+		"added_columns": addedColumns,
+		"version":       result.Version,
+	}
+	if merge {
+		resp["rows_inserted"] = result.RowsAppended
+		resp["rows_updated"] = result.RowsUpdated
+		resp["rows_deleted"] = result.RowsDeleted
+	} else {
+		resp["rows_appended"] = result.RowsAppended
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// splitFormList flattens form values that may be repeated, comma separated
+// or both.
+func splitFormList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+// parseImportOptions reads the optional upload form fields. For workbooks,
+// "sheet" (repeated or comma separated, "*" for all) picks the sheets to
+// import and "header_row" sets the 1-based row holding the column names.
+// "max_rejected_rows" and "max_rejected_percent" fail the upload when too
+// many rows are malformed. "schema", "encoding" and the CSV dialect fields
+// override what would otherwise be detected. For SQLite databases, "table"
+// or "query" picks what to import.
+func parseImportOptions(c *gin.Context) (services.ImportOptions, error) {
+	var opts services.ImportOptions
+	opts.Workbook.Sheets = splitFormList(c.PostFormArray("sheet"))
+	opts.SQLite.Table = strings.TrimSpace(c.PostForm("table"))
+	opts.SQLite.Query = c.PostForm("query")
+	if v := c.PostForm("header_row"); v != "" {
+		headerRow, err := strconv.Atoi(v)
+		if err != nil || headerRow < 1 {
+			return opts, fmt.Errorf("header_row must be a positive integer")
+		}
+		opts.Workbook.HeaderRow = headerRow
+	}
+	if v := c.PostForm("max_rejected_rows"); v != "" {
+		maxRows, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxRows < 0 {
+			return opts, fmt.Errorf("max_rejected_rows must be a non-negative integer")
+		}
+		opts.Rejections.MaxRows = maxRows
+	}
+	if v := c.PostForm("max_rejected_percent"); v != "" {
+		maxPercent, err := strconv.ParseFloat(v, 64)
+		if err != nil || maxPercent < 0 || maxPercent > 100 {
+			return opts, fmt.Errorf("max_rejected_percent must be between 0 and 100")
+		}
+		opts.Rejections.MaxPercent = maxPercent
+	}
+	encoding, err := services.NormalizeEncoding(c.PostForm("encoding"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Encoding = encoding
+	if opts.Dialect, err = parseDialectOptions(c); err != nil {
+		return opts, err
+	}
+	if opts.MaxUncompressedSize, err = maxUploadSize(); err != nil {
+		return opts, err
+	}
+	if v := c.PostForm("schema"); v != "" {
+		schema, err := services.ParseSchema([]byte(v))
+		if err != nil {
+			return opts, err
+		}
+		opts.Schema = schema
+	}
+	return opts, nil
+}
+
+// parseDialectOptions reads the CSV dialect overrides. Delimiters are single
+// characters, with "tab" and "\t" accepted for tabs.
+func parseDialectOptions(c *gin.Context) (services.DialectOptions, error) {
+	var opts services.DialectOptions
+	if v := c.PostForm("delimiter"); v != "" {
+		d, ok := parseDelimiter(v)
+		if !ok {
+			return opts, fmt.Errorf("delimiter must be a single character")
+		}
+		opts.Delimiter = d
+	}
+	for _, v := range splitFormList(c.PostFormArray("extra_delimiters")) {
+		d, ok := parseDelimiter(v)
+		if !ok {
+			return opts, fmt.Errorf("extra_delimiters must be single characters")
+		}
+		opts.ExtraDelimiters = append(opts.ExtraDelimiters, d)
+	}
+	switch c.PostForm("quote") {
+	case "":
+	case `"`:
+		opts.Quote = '"'
+	case "'":
+		opts.Quote = '\''
+	case "none":
+		opts.Quote = services.NoQuote
+	default:
+		return opts, fmt.Errorf(`quote must be ", ' or none`)
+	}
+	if v := c.PostForm("header"); v != "" {
+		header, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("header must be true or false")
+		}
+		opts.Header = &header
+	}
+	return opts, nil
+}
+
+func parseDelimiter(v string) (rune, bool) {
+	if v == "tab" || v == `\t` {
+		return '\t', true
+	}
+	r, size := utf8.DecodeRuneInString(v)
+	return r, size == len(v) && r != utf8.RuneError
+}
+
+func isTruthy(v string) bool {
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
+
+// GetUploadJob reports the progress of a background upload.
+func (h *DatasetHandler) GetUploadJob(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job ID"})
+		return
+	}
+
+	status, err := h.Service.GetUploadJob(c, jobID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get upload job"})
+		return
+	}
+
+	job := status.Job
+	resp := gin.H{
+		"job_id":         job.ID,
+		"filename":       job.Filename,
+		"phase":          job.Phase,
+		"rows_processed": job.RowsProcessed,
+		"rows_rejected":  job.RowsRejected,
+		"rows_total":     job.RowsTotal,
+		"bytes_read":     job.BytesRead,
+		"bytes_total":    job.BytesTotal,
+		"dataset_ids":    status.DatasetIDs,
+		"created_at":     job.CreatedAt,
+		"eta_seconds":    nil,
+		"error":          nil,
+	}
+	if job.StartedAt.Valid {
+		resp["started_at"] = job.StartedAt.Time
+	}
+	if job.FinishedAt.Valid {
+		resp["finished_at"] = job.FinishedAt.Time
+	}
+	if job.Error.Valid {
+		resp["error"] = job.Error.String
+	}
+	if status.ETA != nil {
+		resp["eta_seconds"] = int64(status.ETA.Seconds())
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetUploadRejections returns the rows rejected by an upload. The "format"
+// query parameter selects "json" (the default) or "csv" for a download.
+func (h *DatasetHandler) GetUploadRejections(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job ID"})
+		return
+	}
+
+	rejections, err := h.Service.ListUploadRejections(c, jobID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get rejections"})
+		return
+	}
+
+	switch strings.ToLower(c.DefaultQuery("format", "json")) {
+	case "csv":
+		var buf bytes.Buffer
+		if err := services.WriteRejectionsCSV(&buf, rejections); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write rejections"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "rejections-"+jobID.String()+".csv"))
+		c.Data(http.StatusOK, "text/csv", buf.Bytes())
+	case "json":
+		type rejectionResponse struct {
+			DatasetID *uuid.UUID `json:"dataset_id"`
+			RowNumber int64      `json:"row_number"`
+			Reason    string     `json:"reason"`
+			Raw       string     `json:"raw"`
+		}
+		resp := make([]rejectionResponse, 0, len(rejections))
+		for _, rej := range rejections {
+			r := rejectionResponse{RowNumber: rej.RowNumber, Reason: rej.Reason, Raw: rej.Raw}
+			if rej.DatasetID.Valid {
+				id := rej.DatasetID.UUID
+				r.DatasetID = &id
+			}
+			resp = append(resp, r)
+		}
+		c.JSON(http.StatusOK, gin.H{"rejections": resp})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+	}
+}
+
+// CancelUploadJob stops a background upload and discards its datasets.
+func (h *DatasetHandler) CancelUploadJob(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job ID"})
+		return
+	}
+
+	err = h.Service.CancelUploadJob(c, jobID, userID)
+	switch {
+	case errors.Is(err, services.ErrUploadJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload job not found"})
+	case errors.Is(err, services.ErrUploadJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "upload job has already finished"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel upload job"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "upload job cancelled"})
+	}
+}
+
+// CreateUploadSession starts a resumable upload. The JSON body gives the
+// "filename", the total "size" in bytes, an optional "chunk_size" and an
+// optional "checksum", the hex SHA-256 of the whole file.
+func (h *DatasetHandler) CreateUploadSession(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Filename  string `json:"filename"`
+		Size      int64  `json:"size"`
+		ChunkSize int64  `json:"chunk_size"`
+		Checksum  string `json:"checksum"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	limitSize, err := maxUploadSize()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Size > limitSize {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file too large"})
 		return
 	}
 
-	dataset, err := h.Service.UploadDataset(c, userID, header.Filename, file)
+	session, err := h.Service.CreateUploadSession(c, userID, input.Filename, input.Size, input.ChunkSize, input.Checksum)
+	if errors.Is(err, services.ErrInvalidUploadSession) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to upload dataset: %v", err)})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload session"})
+		return
+	}
+
+	status, err := h.Service.GetUploadSession(c, session.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get upload session"})
+		return
+	}
+	c.JSON(http.StatusCreated, uploadSessionResponse(status))
+}
+
+// PutUploadChunk stores one numbered chunk of a resumable upload. The
+// request body is the chunk and the X-Checksum-SHA256 header its hex SHA-256.
+func (h *DatasetHandler) PutUploadChunk(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload session ID"})
+		return
+	}
+	index, err := strconv.ParseInt(c.Param("index"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chunk number"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxChunkSize+1)
+	size, err := h.Service.PutUploadChunk(c, sessionID, userID, index, body, c.GetHeader("X-Checksum-SHA256"))
+	switch {
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+	case errors.Is(err, services.ErrUploadSessionFinalized):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidChunk):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrChecksumMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store chunk"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"chunk": index, "size": size})
+	}
+}
+
+// GetUploadSession reports which chunks of a resumable upload have been
+// received.
+func (h *DatasetHandler) GetUploadSession(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload session ID"})
+		return
+	}
+
+	status, err := h.Service.GetUploadSession(c, sessionID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get upload session"})
+		return
+	}
+	c.JSON(http.StatusOK, uploadSessionResponse(status))
+}
+
+// FinalizeUploadSession assembles a resumable upload and ingests it as a
+// background job. It accepts the same form fields as UploadDataset.
+func (h *DatasetHandler) FinalizeUploadSession(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload session ID"})
+		return
+	}
+
+	opts, err := parseImportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	columns, err := h.Service.GetColumnsForDataset(c, dataset.ID)
+	force := isTruthy(c.DefaultPostForm("force", c.Query("force")))
+	job, err := h.Service.FinalizeUploadSession(c, sessionID, userID, opts, force)
+	var duplicate *services.DuplicateUploadError
+	switch {
+	case errors.As(err, &duplicate):
+		duplicateUpload(c, duplicate.Datasets)
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+	case errors.Is(err, services.ErrUploadSessionFinalized), errors.Is(err, services.ErrUploadIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrChecksumMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to start upload: %v", err)})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id": job.ID,
+			"phase":  job.Phase,
+		})
+	}
+}
+
+// CancelUploadSession abandons a resumable upload, deleting its chunks.
+func (h *DatasetHandler) CancelUploadSession(c *gin.Context) {
+	userID, ok := GetUserIDFromContext(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get dataset columns"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload session ID"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, DatasetResponse{
-		ID:      dataset.ID,
-		Name:    dataset.Name,
-		Columns: columns,
+	err = h.Service.CancelUploadSession(c, sessionID, userID)
+	switch {
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel upload session"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "upload session cancelled"})
+	}
+}
+
+// duplicateUpload refuses a file that was uploaded before with the IDs of
+// the datasets imported from it.
+func duplicateUpload(c *gin.Context, duplicates []database.Dataset) {
+	ids := make([]uuid.UUID, len(duplicates))
+	for i, d := range duplicates {
+		ids[i] = d.ID
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":       services.ErrDuplicateUpload.Error(),
+		"dataset_id":  ids[0],
+		"dataset_ids": ids,
 	})
 }
 
+func uploadSessionResponse(status services.UploadSessionStatus) gin.H {
+	session := status.Session
+	resp := gin.H{
+		"upload_id":      session.ID,
+		"filename":       session.Filename,
+		"size":           session.Size,
+		"chunk_size":     session.ChunkSize,
+		"chunks":         status.Chunks,
+		"received":       status.Received,
+		"missing":        status.Missing,
+		"bytes_received": status.BytesReceived,
+		"offset":         status.Offset,
+		"created_at":     session.CreatedAt,
+		"expires_at":     session.ExpiresAt,
+		"finalized":      session.FinalizedAt.Valid,
+		"job_id":         nil,
+	}
+	if session.UploadJobID.Valid {
+		resp["job_id"] = session.UploadJobID.UUID
+	}
+	return resp
+}
+
 func (h *DatasetHandler) GetDatasetByID(c *gin.Context) {
 	idStr := c.Param("id")
 	datasetID, err := uuid.Parse(idStr)
@@ -230,6 +926,12 @@ func (h *DatasetHandler) GetDatasetByID(c *gin.Context) {
 		return
 	}
 
+	fields, err := h.Service.GetFieldsForDataset(c.Request.Context(), datasetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error fetching dataset fields"})
+		return
+	}
+
 	userID, ok := GetUserIDFromContext(c)
 	if !ok {
 		return
@@ -245,11 +947,55 @@ func (h *DatasetHandler) GetDatasetByID(c *gin.Context) {
 		"id":         dataset.ID,
 		"name":       dataset.Name,
 		"created_at": dataset.CreatedAt,
+		"encoding":   dataset.Encoding.String,
 		"columns":    columns,
+		"fields":     fields,
 		"rows":       rows,
 	})
 }
 
+// ExportDataset downloads a dataset as a file. The "format" query parameter
+// selects "csv" (the default) or "parquet".
+func (h *DatasetHandler) ExportDataset(c *gin.Context) {
+	idStr := c.Param("id")
+	datasetID, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return
+	}
+
+	dataset, authorized := h.CheckDatasetOwnership(c, datasetID)
+	if !authorized {
+		return
+	}
+
+	base := strings.TrimSuffix(dataset.Name, filepath.Ext(dataset.Name))
+	switch c.DefaultQuery("format", "csv") {
+	case "parquet":
+		var buf bytes.Buffer
+		if err := h.Service.ExportParquet(c.Request.Context(), datasetID, dataset.UserID, &buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to export dataset: %v", err)})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+".parquet"))
+		c.Data(http.StatusOK, "application/vnd.apache.parquet", buf.Bytes())
+	case "csv":
+		cursor, err := h.Service.OpenRowCursor(c.Request.Context(), datasetID, services.RowQuery{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to export dataset: %v", err)})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+".csv"))
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+		if err := streamCSV(c.Request.Context(), c.Writer, cursor); err != nil {
+			log.Printf("Error exporting dataset %s: %v", datasetID, err)
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or parquet"})
+	}
+}
+
 func (h *DatasetHandler) DeleteDatasetsByID(c *gin.Context) {
 	idStr := c.Param("id")
 	datasetID, err := uuid.Parse(idStr)
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Bgoodwin24/insightforge/internal/services"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// flushEvery is the number of streamed rows written between flushes.
+const flushEvery = 1000
+
+// GetDatasetRowPage returns the rows of a dataset a page at a time. The
+// "cursor" query parameter continues from a previous page's next_cursor,
+// "limit" sets the page size and "columns" is a comma separated list of
+// the columns to return. With "format" set to "ndjson" or "csv", every row
+// after the cursor is streamed instead of a single page being returned.
+func (h *DatasetHandler) GetDatasetRowPage(c *gin.Context) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return
+	}
+
+	if _, authorized := h.CheckDatasetOwnership(c, datasetID); !authorized {
+		return
+	}
+
+	query := services.RowQuery{
+		Columns: splitColumns(c.Query("columns")),
+		Cursor:  c.Query("cursor"),
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		limit := services.DefaultRowPageSize
+		if raw := c.Query("limit"); raw != "" {
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > services.MaxRowPageSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(services.MaxRowPageSize)})
+				return
+			}
+		}
+
+		page, err := h.Service.ReadRowPage(c.Request.Context(), datasetID, query, limit)
+		if err != nil {
+			rowError(c, err)
+			return
+		}
+
+		var next any
+		if page.NextCursor != "" {
+			next = page.NextCursor
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"columns":     page.Columns,
+			"rows":        page.Rows,
+			"next_cursor": next,
+		})
+	case "ndjson":
+		cursor, err := h.Service.OpenRowCursor(c.Request.Context(), datasetID, query)
+		if err != nil {
+			rowError(c, err)
+			return
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		if err := streamNDJSON(c.Request.Context(), c.Writer, cursor); err != nil {
+			logger.Logger.Printf("ERROR: Failed to stream rows of dataset %s: %s", datasetID, err.Error())
+		}
+	case "csv":
+		cursor, err := h.Service.OpenRowCursor(c.Request.Context(), datasetID, query)
+		if err != nil {
+			rowError(c, err)
+			return
+		}
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+		if err := streamCSV(c.Request.Context(), c.Writer, cursor); err != nil {
+			logger.Logger.Printf("ERROR: Failed to stream rows of dataset %s: %s", datasetID, err.Error())
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, ndjson or csv"})
+	}
+}
+
+// UpdateDatasetCell sets the value of one cell, given as {"value": "..."},
+// and returns the updated row.
+func (h *DatasetHandler) UpdateDatasetCell(c *gin.Context) {
+	datasetID, userID, rowIndex, ok := h.rowParams(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Value *string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.Value == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	columns, row, err := h.Service.UpdateCell(c.Request.Context(), datasetID, userID, rowIndex, c.Param("column"), *input.Value)
+	if err != nil {
+		rowError(c, err)
+		return
+	}
+	respondWithRow(c, columns, row)
+}
+
+// PatchDatasetRow sets the values of several cells of a row, given as
+// {"values": {"column": "value"}}, and returns the updated row.
+func (h *DatasetHandler) PatchDatasetRow(c *gin.Context) {
+	datasetID, userID, rowIndex, ok := h.rowParams(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Values map[string]string `json:"values"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	columns, row, err := h.Service.UpdateRow(c.Request.Context(), datasetID, userID, rowIndex, input.Values)
+	if err != nil {
+		rowError(c, err)
+		return
+	}
+	respondWithRow(c, columns, row)
+}
+
+// InsertDatasetRows adds the rows given as {"rows": [{"column": "value"}]}
+// to the end of a dataset and returns their row indexes.
+func (h *DatasetHandler) InsertDatasetRows(c *gin.Context) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return
+	}
+
+	dataset, authorized := h.CheckDatasetOwnership(c, datasetID)
+	if !authorized {
+		return
+	}
+
+	var input struct {
+		Rows []map[string]string `json:"rows"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	rowIndexes, err := h.Service.InsertRows(c.Request.Context(), datasetID, dataset.UserID, input.Rows)
+	if err != nil {
+		rowError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"row_indexes": rowIndexes})
+}
+
+// DeleteDatasetRow deletes the row in the path.
+func (h *DatasetHandler) DeleteDatasetRow(c *gin.Context) {
+	datasetID, userID, rowIndex, ok := h.rowParams(c)
+	if !ok {
+		return
+	}
+	h.deleteRows(c, datasetID, userID, []int64{rowIndex})
+}
+
+// DeleteDatasetRows deletes the rows given as {"row_indexes": [...]}. If
+// any of them does not exist, none are deleted.
+func (h *DatasetHandler) DeleteDatasetRows(c *gin.Context) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return
+	}
+
+	dataset, authorized := h.CheckDatasetOwnership(c, datasetID)
+	if !authorized {
+		return
+	}
+
+	var input struct {
+		RowIndexes []int64 `json:"row_indexes"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	h.deleteRows(c, datasetID, dataset.UserID, input.RowIndexes)
+}
+
+func (h *DatasetHandler) deleteRows(c *gin.Context, datasetID, userID uuid.UUID, rowIndexes []int64) {
+	deleted, err := h.Service.DeleteRows(c.Request.Context(), datasetID, userID, rowIndexes)
+	if err != nil {
+		rowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rows_deleted": deleted})
+}
+
+// rowParams parses the dataset ID and row index of a row's path and checks
+// that the dataset belongs to the user, responding with an error if not. It
+// also returns the ID of the user.
+func (h *DatasetHandler) rowParams(c *gin.Context) (uuid.UUID, uuid.UUID, int64, bool) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return uuid.Nil, uuid.Nil, 0, false
+	}
+	rowIndex, err := strconv.ParseInt(c.Param("row"), 10, 64)
+	if err != nil || rowIndex < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid row index"})
+		return uuid.Nil, uuid.Nil, 0, false
+	}
+
+	dataset, authorized := h.CheckDatasetOwnership(c, datasetID)
+	if !authorized {
+		return uuid.Nil, uuid.Nil, 0, false
+	}
+	return datasetID, dataset.UserID, rowIndex, true
+}
+
+// respondWithRow writes a row with its values keyed by column.
+func respondWithRow(c *gin.Context, columns []string, row services.DatasetRow) {
+	values := make(gin.H, len(row.Values))
+	for i, v := range row.Values {
+		values[columns[i]] = v
+	}
+	c.JSON(http.StatusOK, gin.H{"row_index": row.RowIndex, "values": values})
+}
+
+// splitColumns parses a comma separated list of column names.
+func splitColumns(raw string) []string {
+	var columns []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+func rowError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRowNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCursor), errors.Is(err, services.ErrUnknownColumn), errors.Is(err, services.ErrInvalidValue):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Logger.Printf("ERROR: Failed to access dataset rows: %s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to access dataset rows"})
+	}
+}
+
+// streamNDJSON writes each row as a JSON object holding its row_index and
+// its values keyed by column, in column order. Once streaming has begun
+// the status can no longer change, so errors end the response early.
+func streamNDJSON(ctx context.Context, w gin.ResponseWriter, cursor *services.RowCursor) error {
+	keys := make([][]byte, len(cursor.Columns))
+	for i, name := range cursor.Columns {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+
+	var line []byte
+	for n := 1; ; n++ {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			w.Flush()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = append(line[:0], `{"row_index":`...)
+		line = strconv.AppendInt(line, row.RowIndex, 10)
+		line = append(line, `,"values":{`...)
+		for i, v := range row.Values {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			line = append(line, keys[i]...)
+			line = append(line, ':')
+			line = append(line, value...)
+		}
+		line = append(line, "}}\n"...)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		if n%flushEvery == 0 {
+			w.Flush()
+		}
+	}
+}
+
+// streamCSV writes a header of the cursor's columns followed by each row.
+func streamCSV(ctx context.Context, w gin.ResponseWriter, cursor *services.RowCursor) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(cursor.Columns); err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			out.Flush()
+			w.Flush()
+			return out.Error()
+		}
+		if err != nil {
+			out.Flush()
+			return err
+		}
+		if err := out.Write(row.Values); err != nil {
+			return err
+		}
+		if n%flushEvery == 0 {
+			out.Flush()
+			w.Flush()
+		}
+	}
+}
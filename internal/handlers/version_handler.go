@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Bgoodwin24/insightforge/internal/services"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ResolveDatasetVersion lets analytics read an earlier version of a dataset.
+// When the request has a "version" query parameter, the version of the
+// dataset in "dataset_id" with that number is looked up and set on the
+// request's context. Requests without one read the dataset as it is now.
+func (h *DatasetHandler) ResolveDatasetVersion(c *gin.Context) {
+	raw := c.Query("version")
+	if raw == "" {
+		c.Next()
+		return
+	}
+
+	number, ok := parseVersionNumber(raw)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	datasetID, err := uuid.Parse(c.Query("dataset_id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "a version requires a valid dataset_id"})
+		return
+	}
+
+	if _, authorized := h.CheckDatasetOwnership(c, datasetID); !authorized {
+		c.Abort()
+		return
+	}
+
+	version, err := h.Service.GetDatasetVersion(c, datasetID, number)
+	if err != nil {
+		versionError(c, err)
+		c.Abort()
+		return
+	}
+	c.Request = c.Request.WithContext(services.WithVersion(c.Request.Context(), version.ID))
+	c.Next()
+}
+
+// ListDatasetVersions returns the versions of a dataset, oldest first.
+func (h *DatasetHandler) ListDatasetVersions(c *gin.Context) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return
+	}
+
+	if _, authorized := h.CheckDatasetOwnership(c, datasetID); !authorized {
+		return
+	}
+
+	versions, err := h.Service.ListDatasetVersions(c.Request.Context(), datasetID)
+	if err != nil {
+		versionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// RollbackDataset restores a dataset to the version in the path and
+// returns the version recording the rollback.
+func (h *DatasetHandler) RollbackDataset(c *gin.Context) {
+	datasetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset ID"})
+		return
+	}
+	number, ok := parseVersionNumber(c.Param("version"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	dataset, authorized := h.CheckDatasetOwnership(c, datasetID)
+	if !authorized {
+		return
+	}
+
+	version, err := h.Service.RollbackDataset(c.Request.Context(), datasetID, dataset.UserID, number)
+	if err != nil {
+		versionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": version})
+}
+
+func parseVersionNumber(raw string) (int32, bool) {
+	number, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil || number < 1 {
+		return 0, false
+	}
+	return int32(number), true
+}
+
+// versionError responds to an error from reading or changing the versions
+// of a dataset, including the changes to its columns and cleaning steps
+// that create them.
+func versionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownColumn), errors.Is(err, services.ErrInvalidColumns), errors.Is(err, services.ErrCleaningStep):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Logger.Printf("ERROR: Failed to access dataset versions: %s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to access dataset versions"})
+	}
+}
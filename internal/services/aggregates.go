@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Bgoodwin24/insightforge/internal/analytics/aggregation"
+	"github.com/Bgoodwin24/insightforge/internal/analytics/descriptives"
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// Statistics of a numeric column, for ColumnStat and GroupedStat.
+const (
+	StatSum      = "sum"
+	StatMean     = "mean"
+	StatMedian   = "median"
+	StatMin      = "min"
+	StatMax      = "max"
+	StatRange    = "range"
+	StatVariance = "variance"
+	StatStdDev   = "stddev"
+)
+
+// ColumnStat computes a statistic of the non-empty values of a column. When
+// every value of the column is stored with its number, PostgreSQL computes
+// it. Otherwise the column is read and the statistic computed in Go, which
+// also reports values that are not numbers.
+func (s *DatasetService) ColumnStat(ctx context.Context, datasetID, userID uuid.UUID, column, stat string) (float64, error) {
+	switch stat {
+	case StatSum, StatMean, StatMedian, StatMin, StatMax, StatRange, StatVariance, StatStdDev:
+	default:
+		return 0, fmt.Errorf("unknown statistic %q", stat)
+	}
+
+	if s.pushdown(ctx) {
+		value, ok, err := s.columnStatSQL(ctx, datasetID, column, stat)
+		if err != nil || ok {
+			return value, err
+		}
+	}
+
+	data, err := s.GetNumericColumnValues(ctx, datasetID, userID, column)
+	if err != nil {
+		return 0, err
+	}
+	switch stat {
+	case StatSum:
+		return descriptives.Sum(data)
+	case StatMean:
+		return descriptives.Mean(data)
+	case StatMedian:
+		return descriptives.Median(data)
+	case StatMin:
+		return descriptives.Min(data)
+	case StatMax:
+		return descriptives.Max(data)
+	case StatRange:
+		return descriptives.Range(data)
+	case StatVariance:
+		return descriptives.Variance(data)
+	default:
+		return descriptives.StdDev(data)
+	}
+}
+
+// columnStatSQL is ColumnStat computed by PostgreSQL. It reports false when
+// the column has to be computed in Go instead: when it is missing or empty,
+// when any of its values has no number, or when the variance of a single
+// value is asked for, which the Go implementation leaves undefined.
+func (s *DatasetService) columnStatSQL(ctx context.Context, datasetID uuid.UUID, column, stat string) (float64, bool, error) {
+	fieldID, ok, err := s.lookupField(ctx, datasetID, column)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	agg, err := s.Repo.Queries.GetColumnAggregates(ctx, fieldID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to aggregate column: %w", err)
+	}
+	if agg.ValueCount == 0 || agg.NumericCount != agg.ValueCount {
+		return 0, false, nil
+	}
+
+	switch stat {
+	case StatSum:
+		return agg.Sum, true, nil
+	case StatMean:
+		return agg.Mean, true, nil
+	case StatMin:
+		return agg.Min, true, nil
+	case StatMax:
+		return agg.Max, true, nil
+	case StatRange:
+		return agg.Max - agg.Min, true, nil
+	case StatMedian:
+		median, err := s.Repo.Queries.GetColumnMedian(ctx, fieldID)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to aggregate column: %w", err)
+		}
+		return median, true, nil
+	}
+
+	if agg.ValueCount < 2 {
+		return 0, false, nil
+	}
+	if stat == StatVariance {
+		return agg.Variance, true, nil
+	}
+	return math.Sqrt(agg.Variance), true, nil
+}
+
+// CountRows returns the number of rows in a dataset.
+func (s *DatasetService) CountRows(ctx context.Context, datasetID, userID uuid.UUID) (int, error) {
+	if !s.pushdown(ctx) {
+		_, rows, err := s.GetDatasetRows(ctx, datasetID, userID)
+		return len(rows), err
+	}
+
+	// Like GetDatasetRows, a dataset without columns has no rows.
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get fields: %w", err)
+	}
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	count, err := s.Repo.Queries.CountDatasetRecords(ctx, datasetID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows: %w", err)
+	}
+	return int(count), nil
+}
+
+// GroupColumn groups the values of column by the value of groupBy in the
+// same row. Values that are not numbers are skipped.
+func (s *DatasetService) GroupColumn(ctx context.Context, datasetID, userID uuid.UUID, groupBy, column string) (aggregation.GroupedResult, error) {
+	header, rows, err := s.GetDatasetRows(ctx, datasetID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find index of groupBy and column
+	groupByIdx, columnIdx := -1, -1
+	for i, col := range header {
+		if col == groupBy {
+			groupByIdx = i
+		}
+		if col == column {
+			columnIdx = i
+		}
+	}
+	if groupByIdx == -1 || columnIdx == -1 {
+		return nil, fmt.Errorf("group_by or column not found in dataset")
+	}
+
+	grouped := make(aggregation.GroupedResult)
+	for _, row := range rows {
+		val, ok := parseStoredNumber(row[columnIdx])
+		if !ok {
+			continue
+		}
+		grouped[row[groupByIdx]] = append(grouped[row[groupByIdx]], val)
+	}
+	return grouped, nil
+}
+
+// GroupedStat computes a statistic of column for each value of groupBy.
+// It supports StatSum, StatMean, StatMedian, StatMin, StatMax and
+// StatStdDev, and like ColumnStat is computed by PostgreSQL when column is
+// cleanly numeric.
+func (s *DatasetService) GroupedStat(ctx context.Context, datasetID, userID uuid.UUID, groupBy, column, stat string) (map[string]float64, error) {
+	switch stat {
+	case StatSum, StatMean, StatMedian, StatMin, StatMax, StatStdDev:
+	default:
+		return nil, fmt.Errorf("unknown grouped statistic %q", stat)
+	}
+
+	if s.pushdown(ctx) {
+		results, ok, err := s.groupedStatSQL(ctx, datasetID, groupBy, column, stat)
+		if err != nil || ok {
+			return results, err
+		}
+	}
+
+	grouped, err := s.GroupColumn(ctx, datasetID, userID, groupBy, column)
+	if err != nil {
+		return nil, err
+	}
+	switch stat {
+	case StatSum:
+		return aggregation.GroupedSum(grouped), nil
+	case StatMean:
+		return aggregation.GroupedMean(grouped), nil
+	case StatMedian:
+		return aggregation.GroupedMedian(grouped), nil
+	case StatMin:
+		return aggregation.GroupedMin(grouped), nil
+	case StatMax:
+		return aggregation.GroupedMax(grouped), nil
+	default:
+		return aggregation.GroupedStdDev(grouped), nil
+	}
+}
+
+// groupedStatSQL is GroupedStat computed by PostgreSQL, reporting false
+// under the same conditions as columnStatSQL.
+func (s *DatasetService) groupedStatSQL(ctx context.Context, datasetID uuid.UUID, groupBy, column, stat string) (map[string]float64, bool, error) {
+	groupFieldID, ok, err := s.lookupField(ctx, datasetID, groupBy)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	valueFieldID, ok, err := s.lookupField(ctx, datasetID, column)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	groups, err := s.Repo.Queries.GetGroupedAggregates(ctx, database.GetGroupedAggregatesParams{
+		ValueFieldID: valueFieldID,
+		GroupFieldID: groupFieldID,
+		DatasetID:    datasetID,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to aggregate column: %w", err)
+	}
+	for _, g := range groups {
+		if g.NumericCount != g.ValueCount || (stat == StatStdDev && g.ValueCount < 2) {
+			return nil, false, nil
+		}
+	}
+
+	results := make(map[string]float64, len(groups))
+	if stat == StatMedian {
+		medians, err := s.Repo.Queries.GetGroupedMedians(ctx, database.GetGroupedMediansParams{
+			ValueFieldID: valueFieldID,
+			GroupFieldID: groupFieldID,
+			DatasetID:    datasetID,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to aggregate column: %w", err)
+		}
+		for _, m := range medians {
+			results[m.GroupKey] = m.Median
+		}
+		return results, true, nil
+	}
+
+	for _, g := range groups {
+		switch stat {
+		case StatSum:
+			results[g.GroupKey] = g.Sum
+		case StatMean:
+			results[g.GroupKey] = g.Mean
+		case StatMin:
+			results[g.GroupKey] = g.Min
+		case StatMax:
+			results[g.GroupKey] = g.Max
+		case StatStdDev:
+			results[g.GroupKey] = g.Stddev
+		}
+	}
+	return results, true, nil
+}
+
+// GroupedCount counts the rows with each value of groupBy.
+func (s *DatasetService) GroupedCount(ctx context.Context, datasetID, userID uuid.UUID, groupBy string) (map[string]int, error) {
+	if s.pushdown(ctx) {
+		groupFieldID, ok, err := s.lookupField(ctx, datasetID, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			groups, err := s.Repo.Queries.CountRecordsByGroup(ctx, database.CountRecordsByGroupParams{
+				GroupFieldID: groupFieldID,
+				DatasetID:    datasetID,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to count groups: %w", err)
+			}
+			counts := make(map[string]int, len(groups))
+			for _, g := range groups {
+				counts[g.GroupKey] = int(g.Count)
+			}
+			return counts, nil
+		}
+	}
+
+	header, rows, err := s.GetDatasetRows(ctx, datasetID, userID)
+	if err != nil {
+		return nil, err
+	}
+	groupByIdx := -1
+	for i, col := range header {
+		if col == groupBy {
+			groupByIdx = i
+			break
+		}
+	}
+	if groupByIdx == -1 {
+		return nil, fmt.Errorf("group_by column not found in dataset")
+	}
+
+	grouped := make(aggregation.GroupedResult)
+	for _, row := range rows {
+		grouped[row[groupByIdx]] = append(grouped[row[groupByIdx]], 1)
+	}
+	return aggregation.GroupedCount(grouped), nil
+}
+
+// lookupField returns the ID of the dataset's field called name.
+func (s *DatasetService) lookupField(ctx context.Context, datasetID uuid.UUID, name string) (uuid.UUID, bool, error) {
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to get fields: %w", err)
+	}
+	for _, f := range fields {
+		if f.Name == name {
+			return f.ID, true, nil
+		}
+	}
+	return uuid.Nil, false, nil
+}
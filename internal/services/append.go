@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// Column matching modes for appends and merges.
+const (
+	// ColumnMatchStrict requires the file to contain every dataset column,
+	// with names matching exactly.
+	ColumnMatchStrict = "strict"
+	// ColumnMatchLenient ignores case, surrounding spaces and the difference
+	// between spaces, hyphens and underscores. Dataset columns missing from
+	// the file are left empty in the appended rows.
+	ColumnMatchLenient = "lenient"
+)
+
+// ErrColumnMismatch is returned when the columns of an appended file do not
+// fit the dataset.
+var ErrColumnMismatch = errors.New("file columns do not match the dataset")
+
+type AppendOptions struct {
+	ImportOptions
+	// Match is ColumnMatchStrict (the default) or ColumnMatchLenient.
+	Match string
+	// AddColumns creates fields for file columns the dataset does not have
+	// yet, instead of failing. Existing rows have no value for them.
+	AddColumns bool
+	// Keys switches to merge mode: rows whose values in these columns match
+	// an existing row update it instead of being added.
+	Keys []string
+	// DeleteMissing removes, in merge mode, existing rows whose key does not
+	// appear in the file.
+	DeleteMissing bool
+}
+
+type AppendResult struct {
+	// RowsAppended counts new rows, RowsUpdated the existing rows a merge
+	// overwrote and RowsDeleted those it removed.
+	RowsAppended int64
+	RowsUpdated  int64
+	RowsDeleted  int64
+	RowsRejected int64
+	AddedColumns []string
+	// Version is the number of the dataset version the append created.
+	Version int32
+}
+
+// AppendToDataset adds the rows of a CSV or TSV file to an existing dataset,
+// matching the file's header against the dataset's fields. When opts.Keys is
+// set the file is merged instead. Like an upload, the append is
+// all-or-nothing, and like other changes it is recorded as a version.
+func (s *DatasetService) AppendToDataset(
+	ctx context.Context,
+	userID, datasetID uuid.UUID,
+	file io.Reader,
+	opts AppendOptions,
+) (AppendResult, error) {
+	var result AppendResult
+	_, err := s.importAtomically(ctx, opts.ImportOptions, func(tx *DatasetService, importOpts ImportOptions) ([]database.Dataset, error) {
+		opts.ImportOptions = importOpts
+		var dataset database.Dataset
+		version, err := tx.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+			var err error
+			dataset, result, err = tx.appendRows(ctx, userID, datasetID, file, opts)
+			return describeAppend(result, len(opts.Keys) > 0), err
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Version = version.Version
+		return []database.Dataset{dataset}, nil
+	})
+	s.Cache.Invalidate(datasetID)
+	return result, err
+}
+
+func (s *DatasetService) appendRows(
+	ctx context.Context,
+	userID, datasetID uuid.UUID,
+	file io.Reader,
+	opts AppendOptions,
+) (database.Dataset, AppendResult, error) {
+	var result AppendResult
+
+	dataset, err := s.GetDatasetByIDForUser(ctx, userID, datasetID)
+	if err != nil {
+		return dataset, result, err
+	}
+
+	headers, reader, _, err := openCSV(file, opts.ImportOptions)
+	if err != nil {
+		return dataset, result, err
+	}
+
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, dataset.ID)
+	if err != nil {
+		return dataset, result, fmt.Errorf("failed to get dataset fields: %w", err)
+	}
+
+	// A file without a header lists the dataset's columns in order
+	if !reader.dialect.HasHeader {
+		if len(headers) != len(fields) {
+			return dataset, result, fmt.Errorf("%w: file has %d columns without a header, dataset has %d", ErrColumnMismatch, len(headers), len(fields))
+		}
+		for i, f := range fields {
+			headers[i] = f.Name
+		}
+	}
+
+	fieldIDs, newColumns, err := matchColumns(fields, headers, opts.Match, opts.AddColumns)
+	if err != nil {
+		return dataset, result, err
+	}
+
+	keyColumns, err := matchKeyColumns(fields, fieldIDs, opts.Keys, opts.Match)
+	if err != nil {
+		return dataset, result, err
+	}
+
+	schema, err := opts.Schema.compile(headers)
+	if err != nil {
+		return dataset, result, err
+	}
+	ingest := opts.ingestOptions()
+	if schema != nil {
+		ingest.validate = schema.validate
+	}
+
+	stream, err := newRowStream(dataset.ID, len(headers), reader, ingest)
+	if err != nil {
+		return dataset, result, err
+	}
+
+	// New columns are typed from the appended rows
+	inference := newColumnInference(len(headers))
+	for _, i := range newColumns {
+		dataType := schema.declaredType(i)
+		if dataType == "" {
+			dataType = TypeText
+			inference.track(i)
+		}
+		fieldIDs[i], err = s.createField(ctx, dataset.ID, headers[i], dataType, schema.format(i), schema.description(i))
+		if err != nil {
+			return dataset, result, err
+		}
+		result.AddedColumns = append(result.AddedColumns, headers[i])
+	}
+	stream.inference = inference
+
+	if len(keyColumns) > 0 {
+		result, err = s.mergeRows(ctx, dataset.ID, fieldIDs, keyColumns, opts.DeleteMissing, stream)
+	} else {
+		err = s.storeRows(ctx, dataset.ID, fieldIDs, stream)
+		result.RowsAppended = stream.progress.RowsProcessed
+	}
+	result.RowsRejected = stream.progress.RowsRejected
+	if err != nil {
+		return dataset, result, err
+	}
+
+	if err := s.storeInferredTypes(ctx, fieldIDs, inference); err != nil {
+		return dataset, result, err
+	}
+
+	err = s.Repo.Queries.TouchDataset(ctx, database.TouchDatasetParams{
+		ID:        dataset.ID,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return dataset, result, fmt.Errorf("failed to update dataset: %w", err)
+	}
+
+	return dataset, result, nil
+}
+
+// describeAppend describes an append or merge for its dataset version.
+func describeAppend(result AppendResult, merge bool) string {
+	var desc string
+	if merge {
+		desc = fmt.Sprintf("merge: %d inserted, %d updated, %d deleted", result.RowsAppended, result.RowsUpdated, result.RowsDeleted)
+	} else {
+		desc = "append " + countRows(result.RowsAppended)
+	}
+	if len(result.AddedColumns) > 0 {
+		desc += "; add columns " + strings.Join(result.AddedColumns, ", ")
+	}
+	return desc
+}
+
+// matchColumns maps every file column to the dataset field it fills. The
+// indexes of file columns that need a new field are returned separately and
+// have uuid.Nil as their field ID.
+func matchColumns(fields []database.GetFieldsByDatasetIDRow, headers []string, match string, addColumns bool) ([]uuid.UUID, []int, error) {
+	key, err := columnKeyFunc(match)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byKey := make(map[string]int, len(fields))
+	for i, f := range fields {
+		byKey[key(f.Name)] = i
+	}
+
+	fieldIDs := make([]uuid.UUID, len(headers))
+	matched := make([]bool, len(fields))
+	var newColumns []int
+	var unknown []string
+	for i, h := range headers {
+		fi, ok := byKey[key(h)]
+		if !ok {
+			if addColumns {
+				newColumns = append(newColumns, i)
+			} else {
+				unknown = append(unknown, h)
+			}
+			continue
+		}
+		if matched[fi] {
+			return nil, nil, fmt.Errorf("%w: column %q appears more than once", ErrColumnMismatch, fields[fi].Name)
+		}
+		matched[fi] = true
+		fieldIDs[i] = fields[fi].ID
+	}
+
+	var missing []string
+	if match != ColumnMatchLenient {
+		for i, f := range fields {
+			if !matched[i] {
+				missing = append(missing, f.Name)
+			}
+		}
+	}
+
+	var problems []string
+	if len(unknown) > 0 {
+		problems = append(problems, "unknown columns "+strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "missing columns "+strings.Join(missing, ", "))
+	}
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrColumnMismatch, strings.Join(problems, "; "))
+	}
+
+	return fieldIDs, newColumns, nil
+}
+
+// matchKeyColumns finds the file columns holding the merge keys. Keys must
+// be existing dataset columns that the file also contains.
+func matchKeyColumns(fields []database.GetFieldsByDatasetIDRow, fieldIDs []uuid.UUID, keys []string, match string) ([]int, error) {
+	key, err := columnKeyFunc(match)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyColumns []int
+	for _, name := range keys {
+		var fieldID uuid.UUID
+		for _, f := range fields {
+			if key(f.Name) == key(name) {
+				fieldID = f.ID
+				break
+			}
+		}
+		if fieldID == uuid.Nil {
+			return nil, fmt.Errorf("%w: key column %q is not in the dataset", ErrColumnMismatch, name)
+		}
+
+		col := -1
+		for i, id := range fieldIDs {
+			if id == fieldID {
+				col = i
+				break
+			}
+		}
+		if col < 0 {
+			return nil, fmt.Errorf("%w: key column %q is not in the file", ErrColumnMismatch, name)
+		}
+		keyColumns = append(keyColumns, col)
+	}
+	return keyColumns, nil
+}
+
+func columnKeyFunc(match string) (func(string) string, error) {
+	switch match {
+	case "", ColumnMatchStrict:
+		return func(name string) string { return name }, nil
+	case ColumnMatchLenient:
+		return lenientColumnKey, nil
+	default:
+		return nil, fmt.Errorf("unknown column match mode %q", match)
+	}
+}
+
+func lenientColumnKey(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxArchiveEntries caps the number of files read from a zip archive.
+const maxArchiveEntries = 1000
+
+var (
+	// ErrArchiveTooLarge is returned when a compressed upload expands past
+	// ImportOptions.MaxUncompressedSize.
+	ErrArchiveTooLarge = errors.New("uncompressed upload is too large")
+	// ErrEmptyArchive is returned for zip archives without a CSV or TSV file.
+	ErrEmptyArchive = errors.New("archive contains no CSV or TSV files")
+)
+
+// importGzip imports the file compressed in a .gz upload, choosing the
+// parser from the name without the .gz suffix.
+func (s *DatasetService) importGzip(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) ([]database.Dataset, error) {
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip file: %w", err)
+	}
+	defer gz.Close()
+
+	name := filename[:len(filename)-len(path.Ext(filename))]
+	limit := &uncompressedLimit{max: opts.MaxUncompressedSize}
+	return s.importByExtension(ctx, userID, name, limit.reader(gz), opts)
+}
+
+// importZip creates a dataset from every CSV or TSV file in a zip archive,
+// named after the archive and the file's path within it. Other files are
+// skipped. Sizes declared by the archive are checked before anything is
+// extracted, and the bytes actually extracted are counted as well since
+// declarations can lie.
+func (s *DatasetService) importZip(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) ([]database.Dataset, error) {
+	// zip needs random access, which uploads do not always offer
+	spooled, err := os.CreateTemp("", "upload-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer archive: %w", err)
+	}
+	defer os.Remove(spooled.Name())
+	defer spooled.Close()
+
+	size, err := io.Copy(spooled, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer archive: %w", err)
+	}
+	archive, err := zip.NewReader(spooled, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip file: %w", err)
+	}
+	if len(archive.File) > maxArchiveEntries {
+		return nil, fmt.Errorf("%w: more than %d files", ErrArchiveTooLarge, maxArchiveEntries)
+	}
+
+	var entries []*zip.File
+	var declared uint64
+	for _, f := range archive.File {
+		if !isArchivedTable(f) {
+			if !f.FileInfo().IsDir() {
+				log.Printf("Skipping %s in %s: not a CSV or TSV file", f.Name, filename)
+			}
+			continue
+		}
+		entries = append(entries, f)
+		declared += f.UncompressedSize64
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmptyArchive
+	}
+	if opts.MaxUncompressedSize > 0 && declared > uint64(opts.MaxUncompressedSize) {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrArchiveTooLarge, declared, opts.MaxUncompressedSize)
+	}
+
+	limit := &uncompressedLimit{max: opts.MaxUncompressedSize}
+	var datasets []database.Dataset
+	for _, f := range entries {
+		dataset, err := s.importArchivedTable(ctx, userID, fmt.Sprintf("%s - %s", filename, f.Name), f, limit, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		datasets = append(datasets, dataset)
+	}
+	return datasets, nil
+}
+
+func (s *DatasetService) importArchivedTable(
+	ctx context.Context,
+	userID uuid.UUID,
+	name string,
+	f *zip.File,
+	limit *uncompressedLimit,
+	opts ImportOptions,
+) (database.Dataset, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return database.Dataset{}, fmt.Errorf("failed to open archived file: %w", err)
+	}
+	defer rc.Close()
+	return s.importCSV(ctx, userID, name, limit.reader(rc), opts)
+}
+
+// isArchivedTable reports whether a zip entry is a CSV or TSV file worth
+// importing, leaving out folders and the metadata macOS adds to archives.
+func isArchivedTable(f *zip.File) bool {
+	if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(path.Base(f.Name), ".") {
+		return false
+	}
+	switch strings.ToLower(path.Ext(f.Name)) {
+	case ".csv", ".tsv", ".txt":
+		return true
+	}
+	return false
+}
+
+// uncompressedLimit counts the bytes decompressed from an upload and fails
+// once they pass max. Zero means no limit.
+type uncompressedLimit struct {
+	max  int64
+	read int64
+}
+
+func (l *uncompressedLimit) reader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, limit: l}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	limit *uncompressedLimit
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.limit.read += int64(n)
+	if lr.limit.max > 0 && lr.limit.read > lr.limit.max {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, lr.limit.max)
+	}
+	return n, err
+}
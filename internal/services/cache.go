@@ -0,0 +1,235 @@
+package services
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DefaultCacheSize is the memory budget of the dataset cache, in bytes,
+// when none is configured.
+const DefaultCacheSize = 128 << 20
+
+// Rough per-item overheads used to estimate the memory a dataset holds.
+const (
+	stringOverhead = 16
+	sliceOverhead  = 24
+	entryOverhead  = 256
+)
+
+// CacheStats reports how the dataset cache has been used since it was
+// created.
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Bytes         int64  `json:"bytes"`
+	MaxBytes      int64  `json:"max_bytes"`
+}
+
+// DatasetCache keeps recently read datasets decoded in memory, along with
+// the numeric columns parsed from them, so repeated analytics on a dataset
+// do not read it from the database each time. Datasets are evicted least
+// recently used first once their estimated size exceeds the budget.
+//
+// Earlier versions of datasets are cached under the ID of the version.
+// Versions never change, so they are only ever evicted.
+//
+// Callers get their own slice of the cached rows, so they may reorder it,
+// but the rows themselves are shared and must not be modified.
+type DatasetCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // of *cachedDataset, most recently used first
+	entries  map[uuid.UUID]*list.Element
+	// generation changes on every invalidation, so that data read while
+	// a dataset was being written is not cached.
+	generation uint64
+	stats      CacheStats
+}
+
+type cachedDataset struct {
+	id      uuid.UUID
+	hasRows bool
+	header  []string
+	rows    [][]string
+	columns map[string]cachedColumn
+	size    int64
+}
+
+type cachedColumn struct {
+	values     []float64
+	rowIndexes []int64
+}
+
+// NewDatasetCache returns a cache holding up to maxBytes of datasets.
+func NewDatasetCache(maxBytes int64) *DatasetCache {
+	return &DatasetCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[uuid.UUID]*list.Element),
+	}
+}
+
+// Stats returns the cache's counters and current size.
+func (c *DatasetCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+// Invalidate drops everything cached for a dataset. It is called after
+// every write to a dataset's fields or rows has been committed.
+func (c *DatasetCache) Invalidate(datasetID uuid.UUID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.stats.Invalidations++
+	if el, ok := c.entries[datasetID]; ok {
+		c.remove(el)
+	}
+}
+
+// begin returns the generation to pass to the put methods once data read
+// from the database is ready to be cached.
+func (c *DatasetCache) begin() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// getRows returns the cached rows of a dataset. The row list is a copy, so
+// callers may reorder it, but the clone is shallow: the rows in it are
+// shared with the cache and must never be written to.
+func (c *DatasetCache) getRows(datasetID uuid.UUID) ([]string, [][]string, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[datasetID]
+	if !ok || !el.Value.(*cachedDataset).hasRows {
+		c.stats.Misses++
+		return nil, nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	entry := el.Value.(*cachedDataset)
+	return entry.header, slices.Clone(entry.rows), true
+}
+
+func (c *DatasetCache) getColumn(datasetID uuid.UUID, name string) (cachedColumn, bool) {
+	if c == nil {
+		return cachedColumn{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[datasetID]
+	if !ok {
+		c.stats.Misses++
+		return cachedColumn{}, false
+	}
+	col, ok := el.Value.(*cachedDataset).columns[name]
+	if !ok {
+		c.stats.Misses++
+		return cachedColumn{}, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return col, true
+}
+
+func (c *DatasetCache) putRows(datasetID uuid.UUID, generation uint64, header []string, rows [][]string) {
+	size := int64(sliceOverhead)
+	for _, h := range header {
+		size += int64(len(h) + stringOverhead)
+	}
+	for _, row := range rows {
+		size += sliceOverhead
+		for _, v := range row {
+			size += int64(len(v) + stringOverhead)
+		}
+	}
+	c.put(datasetID, generation, size, func(entry *cachedDataset) bool {
+		if entry.hasRows {
+			return false
+		}
+		entry.hasRows = true
+		entry.header = header
+		entry.rows = slices.Clone(rows)
+		return true
+	})
+}
+
+func (c *DatasetCache) putColumn(datasetID uuid.UUID, generation uint64, name string, col cachedColumn) {
+	size := int64(len(name)+stringOverhead+2*sliceOverhead) + int64(len(col.values))*16
+	c.put(datasetID, generation, size, func(entry *cachedDataset) bool {
+		if _, ok := entry.columns[name]; ok {
+			return false
+		}
+		entry.columns[name] = col
+		return true
+	})
+}
+
+// put adds size bytes of data to a dataset's entry with fill, unless the
+// dataset was invalidated since generation or the data alone would not fit.
+// fill reports false if the entry already held the data.
+func (c *DatasetCache) put(datasetID uuid.UUID, generation uint64, size int64, fill func(*cachedDataset) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || size+entryOverhead > c.maxBytes {
+		return
+	}
+
+	el, ok := c.entries[datasetID]
+	if !ok {
+		el = c.lru.PushFront(&cachedDataset{
+			id:      datasetID,
+			columns: make(map[string]cachedColumn),
+			size:    entryOverhead,
+		})
+		c.entries[datasetID] = el
+		c.bytes += entryOverhead
+	}
+	c.lru.MoveToFront(el)
+	entry := el.Value.(*cachedDataset)
+	if !fill(entry) {
+		return
+	}
+	entry.size += size
+	c.bytes += size
+
+	for c.bytes > c.maxBytes && c.lru.Len() > 0 {
+		oldest := c.lru.Back()
+		c.remove(oldest)
+		c.stats.Evictions++
+	}
+}
+
+func (c *DatasetCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cachedDataset)
+	delete(c.entries, entry.id)
+	c.bytes -= entry.size
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// ErrCleaningStep is returned when the rows a cleaning step returns cannot
+// be saved: they must have every column and be the rows it was given, in
+// order, with some values changed or some rows left out.
+var ErrCleaningStep = errors.New("cleaning step cannot be saved")
+
+// CleaningStep transforms the rows of a dataset, given with the names of
+// its columns. The rows are copies, so the step may modify them.
+type CleaningStep func(header []string, rows [][]string) ([][]string, error)
+
+// CleanDataset applies a cleaning step to a dataset and saves its result as
+// a version described by operation. Changed values are written back, with
+// the types of their columns inferred again, and rows the step left out are
+// deleted. Other rows keep their row indexes.
+func (s *DatasetService) CleanDataset(ctx context.Context, datasetID, userID uuid.UUID, operation string, clean CleaningStep) (DatasetVersion, error) {
+	version, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		return operation, tx.applyCleaning(ctx, datasetID, clean)
+	})
+	s.Cache.Invalidate(datasetID)
+	return version, err
+}
+
+func (s *DatasetService) applyCleaning(ctx context.Context, datasetID uuid.UUID, clean CleaningStep) error {
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return fmt.Errorf("failed to get fields: %w", err)
+	}
+	header, fieldIDs, _ := projectFields(fields, nil)
+
+	records, err := s.Repo.Queries.GetRecordsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return fmt.Errorf("failed to get records: %w", err)
+	}
+	recordIDs := make(map[int64]uuid.UUID, len(records))
+	for _, r := range records {
+		recordIDs[r.RowIndex] = r.ID
+	}
+
+	cursor, err := s.OpenRowCursor(ctx, datasetID, RowQuery{})
+	if err != nil {
+		return err
+	}
+	var (
+		rows       [][]string
+		rowIndexes []int64
+	)
+	for {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row.Values)
+		rowIndexes = append(rowIndexes, row.RowIndex)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("%w: dataset is empty", ErrCleaningStep)
+	}
+
+	given := make([][]string, len(rows))
+	for i, row := range rows {
+		given[i] = slices.Clone(row)
+	}
+	cleaned, err := clean(slices.Clone(header), given)
+	if err != nil {
+		return err
+	}
+	for _, row := range cleaned {
+		if len(row) != len(header) {
+			return fmt.Errorf("%w: rows must have %d columns", ErrCleaningStep, len(header))
+		}
+	}
+
+	switch {
+	case len(cleaned) == len(rows):
+		return s.storeCleanedValues(ctx, datasetID, fieldIDs, rows, cleaned, rowIndexes, recordIDs)
+	case len(cleaned) < len(rows):
+		return s.deleteCleanedRows(ctx, datasetID, rows, cleaned, rowIndexes, recordIDs)
+	default:
+		return fmt.Errorf("%w: rows cannot be added", ErrCleaningStep)
+	}
+}
+
+// storeCleanedValues writes the values a cleaning step changed and infers
+// the types of the columns it changed from all of their values.
+func (s *DatasetService) storeCleanedValues(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, rows, cleaned [][]string, rowIndexes []int64, recordIDs map[int64]uuid.UUID) error {
+	inference := newColumnInference(len(fieldIDs))
+	changedColumns := make([]bool, len(fieldIDs))
+	batch := &valueBatch{store: s.values(), upsert: true}
+	var changedRecords []uuid.UUID
+
+	rowFieldIDs := make([]uuid.UUID, len(fieldIDs))
+	for i, row := range cleaned {
+		changed := false
+		for j, val := range row {
+			rowFieldIDs[j] = uuid.Nil
+			if val != rows[i][j] {
+				rowFieldIDs[j] = fieldIDs[j]
+				changed = true
+				if !changedColumns[j] {
+					changedColumns[j] = true
+					inference.track(j)
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		recordID := recordIDs[rowIndexes[i]]
+		changedRecords = append(changedRecords, recordID)
+		if err := batch.addRow(ctx, recordID, rowFieldIDs, row); err != nil {
+			return err
+		}
+	}
+	if err := batch.flush(ctx); err != nil {
+		return fmt.Errorf("failed to update values: %w", err)
+	}
+
+	for _, row := range cleaned {
+		inference.observe(row)
+	}
+	if err := s.storeInferredTypes(ctx, fieldIDs, inference); err != nil {
+		return err
+	}
+	return s.touchRows(ctx, datasetID, changedRecords)
+}
+
+// deleteCleanedRows deletes the rows a cleaning step left out. The rows it
+// kept must be unchanged.
+func (s *DatasetService) deleteCleanedRows(ctx context.Context, datasetID uuid.UUID, rows, cleaned [][]string, rowIndexes []int64, recordIDs map[int64]uuid.UUID) error {
+	var deleted []uuid.UUID
+	next := 0
+	for i, row := range rows {
+		if next < len(cleaned) && slices.Equal(row, cleaned[next]) {
+			next++
+			continue
+		}
+		deleted = append(deleted, recordIDs[rowIndexes[i]])
+	}
+	if next < len(cleaned) {
+		return fmt.Errorf("%w: rows that are kept must not change", ErrCleaningStep)
+	}
+
+	if err := s.deleteRecords(ctx, datasetID, deleted); err != nil {
+		return err
+	}
+	return s.touchRows(ctx, datasetID, nil)
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidColumns is returned when columns cannot be dropped or renamed as
+// asked.
+var ErrInvalidColumns = errors.New("invalid columns")
+
+// DropColumns deletes columns of a dataset, with their values, by field ID.
+// Nothing is deleted unless every field belongs to the dataset. The drop is
+// recorded as a version.
+func (s *DatasetService) DropColumns(ctx context.Context, datasetID, userID uuid.UUID, fieldIDs []uuid.UUID) (DatasetVersion, error) {
+	if len(fieldIDs) == 0 {
+		return DatasetVersion{}, fmt.Errorf("%w: no columns given", ErrInvalidColumns)
+	}
+
+	version, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		fields, err := tx.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get fields: %w", err)
+		}
+		names := make(map[uuid.UUID]string, len(fields))
+		for _, f := range fields {
+			names[f.ID] = f.Name
+		}
+
+		var dropped, unknown []string
+		for _, id := range fieldIDs {
+			name, ok := names[id]
+			if !ok {
+				unknown = append(unknown, id.String())
+				continue
+			}
+			dropped = append(dropped, name)
+		}
+		if len(unknown) > 0 {
+			return "", fmt.Errorf("%w: %s", ErrUnknownColumn, strings.Join(unknown, ", "))
+		}
+
+		// Every row holding a value of a dropped column changes
+		tx.changes.addAll()
+		for _, id := range fieldIDs {
+			err := tx.Repo.Queries.DeleteDatasetField(ctx, database.DeleteDatasetFieldParams{
+				ID:        id,
+				DatasetID: datasetID,
+			})
+			if err != nil {
+				return "", fmt.Errorf("failed to delete field: %w", err)
+			}
+		}
+		return "drop columns " + strings.Join(dropped, ", "), tx.touchRows(ctx, datasetID, nil)
+	})
+	s.Cache.Invalidate(datasetID)
+	return version, err
+}
+
+// RenameColumns gives the columns of a dataset new names, listed in column
+// order. The names must be unique and not empty. The rename is recorded as
+// a version.
+func (s *DatasetService) RenameColumns(ctx context.Context, datasetID, userID uuid.UUID, names []string) (DatasetVersion, error) {
+	version, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		fields, err := tx.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get fields: %w", err)
+		}
+		if len(names) != len(fields) {
+			return "", fmt.Errorf("%w: dataset has %d columns, %d names given", ErrInvalidColumns, len(fields), len(names))
+		}
+
+		seen := make(map[string]bool, len(names))
+		var renamed []database.GetFieldsByDatasetIDRow
+		var changes []string
+		for i, f := range fields {
+			name := strings.TrimSpace(names[i])
+			if name == "" {
+				return "", fmt.Errorf("%w: column %d has no name", ErrInvalidColumns, i+1)
+			}
+			if seen[name] {
+				return "", fmt.Errorf("%w: duplicate column name %q", ErrInvalidColumns, name)
+			}
+			seen[name] = true
+			if name != f.Name {
+				changes = append(changes, fmt.Sprintf("%s to %s", f.Name, name))
+				f.Name = name
+				renamed = append(renamed, f)
+			}
+		}
+		if len(renamed) == 0 {
+			return "", fmt.Errorf("%w: no column is renamed", ErrInvalidColumns)
+		}
+
+		// Names are unique within a dataset, so columns that swap names
+		// are moved out of each other's way first.
+		for _, f := range renamed {
+			if err := tx.renameField(ctx, f.ID, f.ID.String()); err != nil {
+				return "", err
+			}
+		}
+		for _, f := range renamed {
+			if err := tx.renameField(ctx, f.ID, f.Name); err != nil {
+				return "", err
+			}
+		}
+
+		err = tx.Repo.Queries.TouchDataset(ctx, database.TouchDatasetParams{
+			ID:        datasetID,
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to update dataset: %w", err)
+		}
+		return "rename columns " + strings.Join(changes, ", "), nil
+	})
+	s.Cache.Invalidate(datasetID)
+	return version, err
+}
+
+func (s *DatasetService) renameField(ctx context.Context, fieldID uuid.UUID, name string) error {
+	err := s.Repo.Queries.UpdateDatasetFieldName(ctx, database.UpdateDatasetFieldNameParams{
+		ID:   fieldID,
+		Name: name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rename field: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateUpload is returned when a file the user has already uploaded
+// is uploaded again without asking for a duplicate.
+var ErrDuplicateUpload = errors.New("this file has already been uploaded")
+
+// DuplicateUploadError is an ErrDuplicateUpload listing the datasets
+// imported from the earlier upload, oldest first.
+type DuplicateUploadError struct {
+	Datasets []database.Dataset
+}
+
+func (e *DuplicateUploadError) Error() string {
+	return ErrDuplicateUpload.Error()
+}
+
+func (e *DuplicateUploadError) Unwrap() error {
+	return ErrDuplicateUpload
+}
+
+// HashUpload returns the hex SHA-256 of an uploaded file and rewinds it so
+// it can be imported afterwards.
+func HashUpload(file io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash upload: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind upload: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FindDuplicateUploads returns the user's datasets that were imported from a
+// file with the given content hash, oldest first.
+func (s *DatasetService) FindDuplicateUploads(ctx context.Context, userID uuid.UUID, contentHash string) ([]database.Dataset, error) {
+	datasets, err := s.Repo.Queries.GetDatasetsByContentHash(ctx, database.GetDatasetsByContentHashParams{
+		UserID:      userID,
+		ContentHash: sql.NullString{String: contentHash, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up duplicate uploads: %w", err)
+	}
+	return datasets, nil
+}
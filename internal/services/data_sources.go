@@ -0,0 +1,490 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultSourceMaxRows is the row limit of source queries given none.
+	DefaultSourceMaxRows = 100000
+	// MaxSourceRows caps the row limit of source queries.
+	MaxSourceRows = 1000000
+	// DefaultSourceTimeout is the timeout of source queries given none.
+	DefaultSourceTimeout = 30 * time.Second
+	// MaxSourceTimeout caps the timeout of source queries.
+	MaxSourceTimeout = 5 * time.Minute
+
+	// sourceConnectTimeout bounds the connection check made when a data
+	// source is saved.
+	sourceConnectTimeout = 10 * time.Second
+)
+
+var (
+	ErrDataSourcesDisabled = errors.New("data sources are not configured, DATA_SOURCE_KEY is not set")
+	ErrDataSourceNotFound  = errors.New("data source not found")
+	ErrInvalidDataSource   = errors.New("invalid data source")
+	ErrInvalidSourceQuery  = errors.New("invalid source query")
+	ErrSourceRowLimit      = errors.New("query returned too many rows")
+)
+
+// sslModes are the sslmode settings accepted by lib/pq.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// PostgresConnection holds the settings for connecting to an external
+// PostgreSQL database. It is stored encrypted.
+type PostgresConnection struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Database string `json:"database"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// SSLMode is one of sslModes, "require" by default.
+	SSLMode string `json:"sslmode"`
+}
+
+func (c *PostgresConnection) normalize() error {
+	if c.Port == 0 {
+		c.Port = 5432
+	}
+	if c.SSLMode == "" {
+		c.SSLMode = "require"
+	}
+	switch {
+	case strings.TrimSpace(c.Host) == "":
+		return fmt.Errorf("%w: host is required", ErrInvalidDataSource)
+	case strings.TrimSpace(c.Database) == "":
+		return fmt.Errorf("%w: database is required", ErrInvalidDataSource)
+	case strings.TrimSpace(c.User) == "":
+		return fmt.Errorf("%w: user is required", ErrInvalidDataSource)
+	case c.Port < 1 || c.Port > 65535:
+		return fmt.Errorf("%w: port must be between 1 and 65535", ErrInvalidDataSource)
+	}
+	for _, mode := range sslModes {
+		if c.SSLMode == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: sslmode must be one of %s", ErrInvalidDataSource, strings.Join(sslModes, ", "))
+}
+
+// dsn builds a lib/pq connection string, quoting every value.
+func (c PostgresConnection) dsn() string {
+	quote := func(v string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+	}
+	return fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s connect_timeout=%d",
+		quote(c.Host), c.Port, quote(c.Database), quote(c.User), quote(c.Password), quote(c.SSLMode),
+		int(sourceConnectTimeout.Seconds()))
+}
+
+// DataSourceInfo describes a saved data source without its password.
+type DataSourceInfo struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Database  string    `json:"database"`
+	User      string    `json:"user"`
+	SSLMode   string    `json:"sslmode"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SourceQuery is a query to materialise from a data source.
+type SourceQuery struct {
+	// Query is a single SELECT statement. It may be empty when refreshing a
+	// dataset, to run the query the dataset was created from again.
+	Query string
+	// Name and Description name a new dataset. Name defaults to the data
+	// source's name.
+	Name        string
+	Description string
+	// MaxRows fails the query when it returns more rows. Zero means
+	// DefaultSourceMaxRows, or the saved limit when refreshing.
+	MaxRows int64
+	// Timeout cancels the query when it runs longer. Zero means
+	// DefaultSourceTimeout, or the saved timeout when refreshing.
+	Timeout time.Duration
+	// DatasetID, when set, replaces the rows of that dataset instead of
+	// creating one.
+	DatasetID uuid.NullUUID
+}
+
+// CreateDataSource saves a connection to an external PostgreSQL database
+// after checking that it can connect.
+func (s *DatasetService) CreateDataSource(ctx context.Context, userID uuid.UUID, name string, conn PostgresConnection) (DataSourceInfo, error) {
+	if s.Secrets == nil {
+		return DataSourceInfo{}, ErrDataSourcesDisabled
+	}
+	if strings.TrimSpace(name) == "" {
+		return DataSourceInfo{}, fmt.Errorf("%w: name is required", ErrInvalidDataSource)
+	}
+	if err := conn.normalize(); err != nil {
+		return DataSourceInfo{}, err
+	}
+	if err := pingSource(ctx, conn); err != nil {
+		return DataSourceInfo{}, err
+	}
+
+	plaintext, err := json.Marshal(conn)
+	if err != nil {
+		return DataSourceInfo{}, fmt.Errorf("failed to encode data source: %w", err)
+	}
+	config, err := s.Secrets.Seal(plaintext)
+	if err != nil {
+		return DataSourceInfo{}, fmt.Errorf("failed to encrypt data source: %w", err)
+	}
+
+	now := time.Now()
+	source, err := s.Repo.Queries.CreateDataSource(ctx, database.CreateDataSourceParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		Config:    config,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return DataSourceInfo{}, fmt.Errorf("failed to save data source: %w", err)
+	}
+	return dataSourceInfo(source, conn), nil
+}
+
+// ListDataSources returns the user's data sources.
+func (s *DatasetService) ListDataSources(ctx context.Context, userID uuid.UUID) ([]DataSourceInfo, error) {
+	if s.Secrets == nil {
+		return nil, ErrDataSourcesDisabled
+	}
+	sources, err := s.Repo.Queries.ListDataSourcesForUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data sources: %w", err)
+	}
+
+	infos := make([]DataSourceInfo, 0, len(sources))
+	for _, source := range sources {
+		conn, err := s.openConnection(source)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, dataSourceInfo(source, conn))
+	}
+	return infos, nil
+}
+
+// DeleteDataSource removes a data source. Datasets created from it are kept
+// but can no longer be refreshed.
+func (s *DatasetService) DeleteDataSource(ctx context.Context, userID, sourceID uuid.UUID) error {
+	if _, err := s.loadDataSource(ctx, userID, sourceID); err != nil {
+		return err
+	}
+	err := s.Repo.Queries.DeleteDataSource(ctx, database.DeleteDataSourceParams{ID: sourceID, UserID: userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete data source: %w", err)
+	}
+	return nil
+}
+
+// ImportFromDataSource runs a read-only query against a data source and
+// stores the result as a new dataset, or as the new contents of
+// q.DatasetID. Column types come from the PostgreSQL result types. The query
+// is saved with the dataset so it can be refreshed later.
+func (s *DatasetService) ImportFromDataSource(ctx context.Context, userID, sourceID uuid.UUID, q SourceQuery) (database.Dataset, error) {
+	source, err := s.loadDataSource(ctx, userID, sourceID)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+	conn, err := s.openConnection(source)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+
+	var target database.Dataset
+	if q.DatasetID.Valid {
+		target, err = s.GetDatasetByIDForUser(ctx, userID, q.DatasetID.UUID)
+		if err != nil {
+			return database.Dataset{}, err
+		}
+		if q.Query == "" {
+			saved, err := s.Repo.Queries.GetDatasetQuery(ctx, target.ID)
+			if err != nil || saved.DataSourceID != sourceID {
+				return database.Dataset{}, fmt.Errorf("%w: the dataset has no saved query for this data source", ErrInvalidSourceQuery)
+			}
+			q.Query = saved.Query
+			if q.MaxRows == 0 {
+				q.MaxRows = saved.MaxRows
+			}
+			if q.Timeout == 0 {
+				q.Timeout = time.Duration(saved.TimeoutSeconds) * time.Second
+			}
+		}
+	}
+
+	query, err := normalizeSourceQuery(q.Query)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+	if q.MaxRows == 0 {
+		q.MaxRows = DefaultSourceMaxRows
+	}
+	if q.MaxRows < 0 || q.MaxRows > MaxSourceRows {
+		return database.Dataset{}, fmt.Errorf("%w: max_rows must be between 1 and %d", ErrInvalidSourceQuery, MaxSourceRows)
+	}
+	if q.Timeout == 0 {
+		q.Timeout = DefaultSourceTimeout
+	}
+	if q.Timeout < time.Second || q.Timeout > MaxSourceTimeout {
+		return database.Dataset{}, fmt.Errorf("%w: timeout must be between 1 second and %s", ErrInvalidSourceQuery, MaxSourceTimeout)
+	}
+
+	result, err := runSourceQuery(ctx, conn, query, q.MaxRows, q.Timeout)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+	defer result.Close()
+
+	if q.DatasetID.Valid {
+		defer s.Cache.Invalidate(q.DatasetID.UUID)
+	}
+	return s.importOne(ctx, ImportOptions{}, func(tx *DatasetService, opts ImportOptions) (database.Dataset, error) {
+		fill := func(tx *DatasetService, dataset database.Dataset) error {
+			ingest := opts.ingestOptions()
+			ingest.fieldTypes = result.fieldTypes
+			if err := tx.ingestRows(ctx, dataset, result.headers, result.reader, ingest); err != nil {
+				return err
+			}
+
+			now := time.Now()
+			err := tx.Repo.Queries.UpsertDatasetQuery(ctx, database.UpsertDatasetQueryParams{
+				DatasetID:      dataset.ID,
+				DataSourceID:   sourceID,
+				Query:          query,
+				MaxRows:        q.MaxRows,
+				TimeoutSeconds: int32(q.Timeout / time.Second),
+				RefreshedAt:    now,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to save dataset query: %w", err)
+			}
+			return nil
+		}
+
+		// A refresh replaces the dataset's rows and is recorded as a version
+		if q.DatasetID.Valid {
+			_, err := tx.versioned(ctx, target.ID, userID, func(tx *DatasetService) (string, error) {
+				if err := tx.clearDataset(ctx, target.ID); err != nil {
+					return "", err
+				}
+				if err := fill(tx, target); err != nil {
+					return "", err
+				}
+				if err := tx.Repo.Queries.TouchDataset(ctx, database.TouchDatasetParams{ID: target.ID, UpdatedAt: time.Now()}); err != nil {
+					return "", fmt.Errorf("failed to update dataset: %w", err)
+				}
+				return fmt.Sprintf("refresh from data source %q", source.Name), nil
+			})
+			return target, err
+		}
+
+		name := q.Name
+		if name == "" {
+			name = source.Name
+		}
+		description := q.Description
+		if description == "" {
+			description = fmt.Sprintf("Imported from data source %q", source.Name)
+		}
+		dataset, err := tx.createImportDataset(ctx, userID, name, description, "", opts)
+		if err != nil {
+			logger.Logger.Printf("Error importing from data source: %v", err)
+			return dataset, err
+		}
+		return dataset, fill(tx, dataset)
+	})
+}
+
+// clearDataset removes every field and row of a dataset so it can be
+// filled again.
+func (s *DatasetService) clearDataset(ctx context.Context, datasetID uuid.UUID) error {
+	s.changes.addAll()
+	if err := s.Repo.Queries.DeleteAllDatasetRecords(ctx, datasetID); err != nil {
+		return fmt.Errorf("failed to delete dataset rows: %w", err)
+	}
+	if err := s.Repo.Queries.DeleteAllDatasetFields(ctx, datasetID); err != nil {
+		return fmt.Errorf("failed to delete dataset fields: %w", err)
+	}
+	return nil
+}
+
+func (s *DatasetService) loadDataSource(ctx context.Context, userID, sourceID uuid.UUID) (database.DataSource, error) {
+	if s.Secrets == nil {
+		return database.DataSource{}, ErrDataSourcesDisabled
+	}
+	source, err := s.Repo.Queries.GetDataSource(ctx, sourceID)
+	if err != nil || source.UserID != userID {
+		return database.DataSource{}, ErrDataSourceNotFound
+	}
+	return source, nil
+}
+
+func (s *DatasetService) openConnection(source database.DataSource) (PostgresConnection, error) {
+	var conn PostgresConnection
+	plaintext, err := s.Secrets.Open(source.Config)
+	if err != nil {
+		return conn, fmt.Errorf("failed to decrypt data source %s: %w", source.ID, err)
+	}
+	if err := json.Unmarshal(plaintext, &conn); err != nil {
+		return conn, fmt.Errorf("failed to decode data source %s: %w", source.ID, err)
+	}
+	return conn, nil
+}
+
+func dataSourceInfo(source database.DataSource, conn PostgresConnection) DataSourceInfo {
+	return DataSourceInfo{
+		ID:        source.ID,
+		Name:      source.Name,
+		Host:      conn.Host,
+		Port:      conn.Port,
+		Database:  conn.Database,
+		User:      conn.User,
+		SSLMode:   conn.SSLMode,
+		CreatedAt: source.CreatedAt,
+	}
+}
+
+func pingSource(ctx context.Context, conn PostgresConnection) error {
+	db, err := sql.Open("postgres", conn.dsn())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDataSource, err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, sourceConnectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%w: cannot connect: %v", ErrInvalidDataSource, err)
+	}
+	return nil
+}
+
+// normalizeSourceQuery checks that a query looks like a single SELECT
+// statement. The database enforces it: the query runs as a subquery, in a
+// read-only transaction, through the extended protocol, which refuses more
+// than one statement.
+func normalizeSourceQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	for strings.HasSuffix(query, ";") {
+		query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	}
+	if query == "" {
+		return "", fmt.Errorf("%w: query is required", ErrInvalidSourceQuery)
+	}
+	keyword := strings.ToUpper(strings.Fields(query)[0])
+	if keyword != "SELECT" && keyword != "WITH" && keyword != "VALUES" && keyword != "TABLE" {
+		return "", fmt.Errorf("%w: query must be a SELECT statement", ErrInvalidSourceQuery)
+	}
+	return query, nil
+}
+
+// sourceResult is a query running against a data source. Close releases the
+// connection.
+type sourceResult struct {
+	headers    []string
+	fieldTypes []string
+	reader     *sqlRowReader
+	close      func()
+}
+
+func (r *sourceResult) Close() {
+	r.close()
+}
+
+// runSourceQuery starts a query in a read-only transaction, limited to
+// maxRows rows and the timeout. Rows are streamed while they are ingested.
+func runSourceQuery(ctx context.Context, conn PostgresConnection, query string, maxRows int64, timeout time.Duration) (*sourceResult, error) {
+	db, err := sql.Open("postgres", conn.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDataSource, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	// The query's context must outlive ingestion, which reads the rows
+	queryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	stop := context.AfterFunc(ctx, cancel)
+	closeAll := func() {
+		stop()
+		cancel()
+		db.Close()
+	}
+
+	tx, err := db.BeginTx(queryCtx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("%w: cannot connect: %v", ErrInvalidDataSource, err)
+	}
+	closeTx := func() {
+		tx.Rollback()
+		closeAll()
+	}
+
+	_, err = tx.ExecContext(queryCtx, "SET LOCAL statement_timeout = "+strconv.FormatInt(timeout.Milliseconds(), 10))
+	if err != nil {
+		closeTx()
+		return nil, fmt.Errorf("failed to set query timeout: %w", err)
+	}
+
+	// One more row than allowed is fetched to tell a full result from a
+	// truncated one
+	rows, err := tx.QueryContext(queryCtx, "SELECT * FROM ("+query+"\n) AS source LIMIT $1", maxRows+1)
+	if err != nil {
+		closeTx()
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSourceQuery, err)
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		closeTx()
+		return nil, fmt.Errorf("failed to read query columns: %w", err)
+	}
+	headers := make([]string, len(columnTypes))
+	fieldTypes := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		headers[i] = ct.Name()
+		fieldTypes[i] = postgresDataType(ct.DatabaseTypeName())
+	}
+
+	return &sourceResult{
+		headers:    normalizeHeaders(headers),
+		fieldTypes: fieldTypes,
+		reader:     &sqlRowReader{rows: rows, width: len(headers), limit: maxRows},
+		close: func() {
+			rows.Close()
+			closeTx()
+		},
+	}, nil
+}
+
+// postgresDataType maps a PostgreSQL result type onto a dataset type.
+func postgresDataType(name string) string {
+	switch name {
+	case "INT2", "INT4", "INT8":
+		return TypeInteger
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return TypeFloat
+	case "BOOL":
+		return TypeBoolean
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ":
+		return TypeDatetime
+	}
+	return TypeText
+}
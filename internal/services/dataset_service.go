@@ -1,49 +1,114 @@
 package services
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strconv"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Bgoodwin24/insightforge/internal/auth"
 	"github.com/Bgoodwin24/insightforge/internal/database"
 	"github.com/Bgoodwin24/insightforge/logger"
-	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+const (
+	// DatasetStatusReady datasets are visible to their owner.
+	DatasetStatusReady = "ready"
+	// DatasetStatusPending datasets are still being filled by an upload job
+	// and are hidden from listings and lookups until it completes.
+	DatasetStatusPending = "pending"
+)
+
 type DatasetService struct {
 	Repo *database.Repository
+	// UploadDir is where files are staged for background upload jobs.
+	UploadDir string
+	// CopyIngest loads new rows with COPY rather than INSERT statements.
+	CopyIngest bool
+	// Storage is the layout dataset values are read and written with,
+	// StorageTyped or StorageText.
+	Storage string
+	// Secrets encrypts the credentials of data sources. Data sources are
+	// unavailable when it is nil.
+	Secrets *auth.SecretBox
+	// Cache holds recently read datasets. Nothing is cached when it is nil.
+	Cache *DatasetCache
+	// SQLAggregates computes statistics of cleanly numeric columns in
+	// PostgreSQL instead of reading every value into Go.
+	SQLAggregates bool
+	// MaxVersions is the number of versions kept of each dataset. Once a
+	// change goes past it the oldest are deleted. Zero keeps every version.
+	MaxVersions int
+	// changes collects the rows written while a change is being recorded
+	// as a version. It is only set inside versioned.
+	changes *rowChanges
+
+	jobsMu     sync.Mutex
+	jobCancels map[uuid.UUID]context.CancelFunc
 }
 
 func NewDatasetService(repo *database.Repository) *DatasetService {
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = "./uploads"
+	}
+
 	return &DatasetService{
-		Repo: repo,
+		Repo:          repo,
+		UploadDir:     uploadDir,
+		CopyIngest:    true,
+		Storage:       StorageTyped,
+		Cache:         NewDatasetCache(DefaultCacheSize),
+		SQLAggregates: true,
+		MaxVersions:   DefaultMaxVersions,
+		jobCancels:    make(map[uuid.UUID]context.CancelFunc),
 	}
 }
 
+// UpdateDatasetRows sets one field of every record of a dataset to the same
+// value. It overwrites the whole column, so the change is recorded as a
+// version, with no author, that can be rolled back.
 func (s *DatasetService) UpdateDatasetRows(ctx context.Context, params database.UpdateDatasetRowsParams) error {
-	err := s.Repo.Queries.UpdateDatasetRows(ctx, params)
-	if err != nil {
-		return fmt.Errorf("failed to update dataset rows: %w", err)
-	}
-	return nil
-}
+	_, err := s.versioned(ctx, params.DatasetID, uuid.Nil, func(tx *DatasetService) (string, error) {
+		field, err := tx.Repo.Queries.GetDatasetField(ctx, database.GetDatasetFieldParams{
+			ID:        params.FieldID,
+			DatasetID: params.DatasetID,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %s", ErrUnknownColumn, params.FieldID)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get field: %w", err)
+		}
 
-func (s *DatasetService) DeleteFieldFromDataset(c *gin.Context, datasetID uuid.UUID, fieldID uuid.UUID) error {
-	// Call your database layer to delete the field from the dataset
-	params := database.DeleteDatasetFieldParams{
-		ID:        fieldID,
-		DatasetID: datasetID,
-	}
+		params.Value = storedValue(params.Value.String)
+		params.NumValue = numericValue(params.Value)
+		tx.changes.addAll()
+		if err := tx.Repo.Queries.UpdateDatasetRows(ctx, params); err != nil {
+			return "", fmt.Errorf("failed to update dataset rows: %w", err)
+		}
+		return "set column " + field.Name, nil
+	})
+	s.Cache.Invalidate(params.DatasetID)
+	return err
+}
 
-	// Perform the deletion
-	err := s.Repo.Queries.DeleteDatasetField(context.Background(), params)
+// DeleteFieldFromDataset deletes a column of a dataset, recording the drop
+// as a version with no author.
+//
+// Deprecated: use DropColumns, which drops several columns as one version
+// and records who dropped them.
+func (s *DatasetService) DeleteFieldFromDataset(ctx context.Context, datasetID uuid.UUID, fieldID uuid.UUID) error {
+	_, err := s.DropColumns(ctx, datasetID, uuid.Nil, []uuid.UUID{fieldID})
 	return err
 }
 
@@ -66,75 +131,42 @@ func (s *DatasetService) GetDatasetByID(ctx context.Context, id uuid.UUID) (data
 	return dataset, nil
 }
 
+// GetDatasetRows returns the column names of a dataset and all of its rows,
+// from the cache when they are in it. The rows may be reordered but must
+// not be modified. When ctx carries a version, the rows of that version are
+// returned instead.
 func (s *DatasetService) GetDatasetRows(ctx context.Context, datasetID, userID uuid.UUID) ([]string, [][]string, error) {
-	// 1. Load fields (columns)
-	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get fields: %w", err)
+	if versionID, ok := versionFromContext(ctx); ok {
+		return s.versionRows(ctx, datasetID, versionID)
+	}
+	if header, rows, ok := s.Cache.getRows(datasetID); ok {
+		return header, rows, nil
 	}
+	generation := s.Cache.begin()
 
-	// 2. Load records (rows)
-	records, err := s.Repo.Queries.GetRecordsByDatasetID(ctx, datasetID)
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get records: %w", err)
+		return nil, nil, fmt.Errorf("failed to get fields: %w", err)
 	}
-
-	if len(fields) == 0 || len(records) == 0 {
+	if len(fields) == 0 {
 		return []string{}, [][]string{}, nil // empty dataset
 	}
 
-	// 3. Build header and field index map
-	fieldIndexMap := make(map[uuid.UUID]int)
 	header := make([]string, len(fields))
+	fieldIDs := make([]uuid.UUID, len(fields))
 	for i, f := range fields {
-		fieldIndexMap[f.ID] = i
 		header[i] = f.Name
+		fieldIDs[i] = f.ID
 	}
 
-	// 4. Load all record values
-	values, err := s.Repo.Queries.GetRecordValuesByDatasetID(ctx, datasetID)
+	rows, err := s.values().Rows(ctx, datasetID, fieldIDs)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get record values: %w", err)
-	}
-
-	// 5. Build value map per record with nil handling
-	recordValueMap := make(map[uuid.UUID]map[int]*string)
-	for _, val := range values {
-		colIdx, ok := fieldIndexMap[val.FieldID]
-		if !ok {
-			continue
-		}
-		if _, exists := recordValueMap[val.RecordID]; !exists {
-			recordValueMap[val.RecordID] = make(map[int]*string)
-		}
-		if val.Value.Valid {
-			v := val.Value.String
-			recordValueMap[val.RecordID][colIdx] = &v
-		} else {
-			recordValueMap[val.RecordID][colIdx] = nil
-		}
+		return nil, nil, err
 	}
-
-	// 6. Reconstruct all rows
-	var rows [][]string
-	for _, record := range records {
-		row := make([]string, len(fields))
-		colMap := recordValueMap[record.ID]
-
-		for i := 0; i < len(fields); i++ {
-			if colMap != nil {
-				if val, ok := colMap[i]; ok && val != nil {
-					row[i] = *val
-				} else {
-					row[i] = ""
-				}
-			} else {
-				row[i] = ""
-			}
-		}
-		rows = append(rows, row)
+	if len(rows) == 0 {
+		return []string{}, [][]string{}, nil // empty dataset
 	}
-
+	s.Cache.putRows(datasetID, generation, header, rows)
 	return header, rows, nil
 }
 
@@ -148,6 +180,32 @@ func (s *DatasetService) CreateDataset(ctx context.Context, userID uuid.UUID, na
 		Description: sql.NullString{String: description, Valid: description != ""},
 		CreatedAt:   now,
 		UpdatedAt:   now,
+		Status:      DatasetStatusReady,
+	})
+}
+
+// createImportDataset creates the dataset a file is imported into. encoding
+// is the text encoding the file was read as, or "" for binary formats.
+// Datasets created by an upload job stay pending until the job publishes
+// them.
+func (s *DatasetService) createImportDataset(ctx context.Context, userID uuid.UUID, name, description, encoding string, opts ImportOptions) (database.Dataset, error) {
+	now := time.Now()
+	status := DatasetStatusReady
+	if opts.UploadJobID.Valid {
+		status = DatasetStatusPending
+	}
+
+	return s.Repo.Queries.CreateDataset(ctx, database.CreateDatasetParams{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Name:        name,
+		Description: sql.NullString{String: description, Valid: description != ""},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Status:      status,
+		UploadJobID: opts.UploadJobID,
+		Encoding:    sql.NullString{String: encoding, Valid: encoding != ""},
+		ContentHash: sql.NullString{String: opts.ContentHash, Valid: opts.ContentHash != ""},
 	})
 }
 
@@ -159,6 +217,9 @@ func (s *DatasetService) GetDatasetByIDForUser(ctx context.Context, userID, data
 	if dataset.UserID != userID {
 		return database.Dataset{}, fmt.Errorf("unauthorized access")
 	}
+	if dataset.Status != DatasetStatusReady {
+		return database.Dataset{}, fmt.Errorf("dataset is still being uploaded")
+	}
 	return dataset, nil
 }
 
@@ -172,6 +233,7 @@ func (s *DatasetService) ListDatasetsForUser(ctx context.Context, userID uuid.UU
 
 func (s *DatasetService) UpdateDataset(ctx context.Context, id uuid.UUID, name, description string) (database.Dataset, error) {
 	now := time.Now()
+	defer s.Cache.Invalidate(id)
 	return s.Repo.Queries.UpdateDataset(ctx, database.UpdateDatasetParams{
 		ID:          id,
 		Name:        name,
@@ -181,6 +243,7 @@ func (s *DatasetService) UpdateDataset(ctx context.Context, id uuid.UUID, name,
 }
 
 func (s *DatasetService) DeleteDataset(ctx context.Context, id, userID uuid.UUID) error {
+	defer s.Cache.Invalidate(id)
 	return s.Repo.Queries.DeleteDataset(ctx, database.DeleteDatasetParams{
 		ID:     id,
 		UserID: userID,
@@ -200,20 +263,130 @@ func (s *DatasetService) GetColumnsForDataset(ctx context.Context, datasetID uui
 	return s.Repo.Queries.GetDatasetFieldsForDataset(ctx, datasetID)
 }
 
-func rowsToDelimited(rows [][]string, delimiter rune) []string {
-	var lines []string
-	sep := string(delimiter)
-	for _, row := range rows {
-		lines = append(lines, strings.Join(row, sep))
+// FieldInfo describes a dataset column. TypeConfidence is nil when the type
+// was declared rather than inferred.
+type FieldInfo struct {
+	Name                string   `json:"name"`
+	DataType            string   `json:"data_type"`
+	Description         string   `json:"description,omitempty"`
+	TypeConfidence      *float64 `json:"type_confidence"`
+	TypeCounterexamples []string `json:"type_counterexamples"`
+}
+
+// GetFieldsForDataset returns the columns of a dataset with how their types
+// were chosen.
+func (s *DatasetService) GetFieldsForDataset(ctx context.Context, datasetID uuid.UUID) ([]FieldInfo, error) {
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fields: %w", err)
+	}
+
+	infos := make([]FieldInfo, len(fields))
+	for i, f := range fields {
+		infos[i] = FieldInfo{
+			Name:                f.Name,
+			DataType:            f.DataType,
+			Description:         f.Description.String,
+			TypeCounterexamples: f.TypeCounterexamples,
+		}
+		if f.TypeConfidence.Valid {
+			confidence := f.TypeConfidence.Float64
+			infos[i].TypeConfidence = &confidence
+		}
+		if infos[i].TypeCounterexamples == nil {
+			infos[i].TypeCounterexamples = []string{}
+		}
 	}
-	return lines
+	return infos, nil
+}
+
+// ImportOptions controls how an uploaded file becomes datasets.
+type ImportOptions struct {
+	// Workbook selects sheets and the header row of .xlsx uploads.
+	Workbook WorkbookOptions
+	// SQLite selects the table or query imported from SQLite databases.
+	SQLite SQLiteOptions
+	// UploadJobID marks the datasets as belonging to an upload job. They
+	// stay pending until the job publishes them, and rejected rows are
+	// stored against the job.
+	UploadJobID uuid.NullUUID
+	// Rejections fails the import when too many rows are malformed.
+	Rejections RejectionLimits
+	// Schema declares the type of some columns instead of inferring it.
+	Schema Schema
+	// Encoding is the text encoding of CSV and JSON files. It is detected
+	// when empty.
+	Encoding string
+	// Dialect overrides the detected delimiter, quoting and header of CSV
+	// files.
+	Dialect DialectOptions
+	// MaxUncompressedSize caps the bytes extracted from a gzip or zip
+	// upload. Zero means no limit.
+	MaxUncompressedSize int64
+	// ContentHash is the SHA-256 of the uploaded file, stored on the
+	// datasets it creates so later uploads of the same file can be found.
+	ContentHash string
+	// Progress, when set, is called periodically while rows are stored.
+	Progress func(IngestProgress)
+
+	rejectionSink func(datasetID uuid.UUID, rej RowRejection)
+}
+
+// IngestProgress reports how far ingestion of a dataset has got. RowsTotal
+// is zero when the number of rows is not known up front.
+type IngestProgress struct {
+	DatasetID     uuid.UUID
+	RowsProcessed int64
+	RowsRejected  int64
+	RowsTotal     int64
+}
+
+// ImportFile imports an uploaded file, choosing the parser from the file
+// extension. Workbooks and zip archives may produce several datasets; every
+// other format produces one. Gzip files are decompressed first.
+func (s *DatasetService) ImportFile(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) ([]database.Dataset, error) {
+	return s.importAtomically(ctx, opts, func(tx *DatasetService, opts ImportOptions) ([]database.Dataset, error) {
+		return tx.importByExtension(ctx, userID, filename, file, opts)
+	})
 }
 
-func detectDelimiter(peeked string) rune {
-	if strings.Count(peeked, "\t") > strings.Count(peeked, ",") {
-		return '\t'
+func (s *DatasetService) importByExtension(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) ([]database.Dataset, error) {
+	var (
+		dataset database.Dataset
+		err     error
+	)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz":
+		return s.importGzip(ctx, userID, filename, file, opts)
+	case ".zip":
+		return s.importZip(ctx, userID, filename, file, opts)
+	case ".xlsx":
+		return s.importWorkbook(ctx, userID, filename, file, opts)
+	case ".json", ".ndjson", ".jsonl":
+		dataset, err = s.importJSON(ctx, userID, filename, file, opts)
+	case ".parquet":
+		dataset, err = s.importParquet(ctx, userID, filename, file, opts)
+	case ".sqlite", ".sqlite3", ".db":
+		dataset, err = s.importSQLite(ctx, userID, filename, file, opts)
+	default:
+		dataset, err = s.importCSV(ctx, userID, filename, file, opts)
 	}
-	return ','
+	if err != nil {
+		return nil, err
+	}
+	return []database.Dataset{dataset}, nil
 }
 
 func (s *DatasetService) UploadDataset(
@@ -222,266 +395,545 @@ func (s *DatasetService) UploadDataset(
 	filename string,
 	file io.Reader,
 ) (database.Dataset, error) {
-	dataset, err := s.CreateDataset(ctx, userID, filename, "Uploaded dataset file")
+	return s.importOne(ctx, ImportOptions{}, func(tx *DatasetService, opts ImportOptions) (database.Dataset, error) {
+		return tx.importCSV(ctx, userID, filename, file, opts)
+	})
+}
+
+// importAtomically runs an import in a single transaction, so either every
+// dataset, field, record and value it creates is stored or none of them
+// are. importFn gets a service bound to the transaction. Rejected rows are
+// stored once the transaction is over, and keep their dataset only if it
+// was committed.
+func (s *DatasetService) importAtomically(
+	ctx context.Context,
+	opts ImportOptions,
+	importFn func(tx *DatasetService, opts ImportOptions) ([]database.Dataset, error),
+) ([]database.Dataset, error) {
+	var rejected []datasetRejection
+	opts.rejectionSink = func(datasetID uuid.UUID, rej RowRejection) {
+		rejected = append(rejected, datasetRejection{datasetID: datasetID, RowRejection: rej})
+	}
+
+	var datasets []database.Dataset
+	err := s.Repo.InTx(ctx, func(txRepo *database.Repository) error {
+		var err error
+		datasets, err = importFn(s.withRepo(txRepo), opts)
+		return err
+	})
+
+	s.storeRejections(context.WithoutCancel(ctx), opts.UploadJobID, rejected, err == nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("import rolled back: %w", err)
+	}
+	return datasets, nil
+}
+
+// withRepo returns a service with the settings of s whose queries go
+// through repo, such as a repository bound to a transaction. It has no
+// cache, so uncommitted data is never cached. Callers invalidate what they
+// wrote once the transaction is over.
+func (s *DatasetService) withRepo(repo *database.Repository) *DatasetService {
+	return &DatasetService{
+		Repo:          repo,
+		UploadDir:     s.UploadDir,
+		CopyIngest:    s.CopyIngest,
+		Storage:       s.Storage,
+		Secrets:       s.Secrets,
+		SQLAggregates: s.SQLAggregates,
+		MaxVersions:   s.MaxVersions,
+	}
+}
+
+// importOne is importAtomically for formats that produce a single dataset.
+func (s *DatasetService) importOne(
+	ctx context.Context,
+	opts ImportOptions,
+	importFn func(tx *DatasetService, opts ImportOptions) (database.Dataset, error),
+) (database.Dataset, error) {
+	datasets, err := s.importAtomically(ctx, opts, func(tx *DatasetService, opts ImportOptions) ([]database.Dataset, error) {
+		dataset, err := importFn(tx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []database.Dataset{dataset}, nil
+	})
+	if err != nil {
+		return database.Dataset{}, err
+	}
+	return datasets[0], nil
+}
+
+func (s *DatasetService) importCSV(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) (database.Dataset, error) {
+	headers, csvReader, encoding, err := openCSV(file, opts)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+
+	dataset, err := s.createImportDataset(ctx, userID, filename, "Uploaded dataset file", encoding, opts)
 	if err != nil {
 		logger.Logger.Printf("Error uploading dataset: %v", err)
 		return database.Dataset{}, err
 	}
 
-	// Peek at the first 512 bytes to detect delimiter
-	peekBuf := make([]byte, 512)
-	n, err := file.Read(peekBuf)
-	if err != nil && err != io.EOF {
-		return dataset, fmt.Errorf("failed to peek file: %w", err)
+	if err := s.ingestRows(ctx, dataset, headers, csvReader, opts.ingestOptions()); err != nil {
+		return dataset, err
 	}
-	peeked := string(peekBuf[:n])
-	delimiter := detectDelimiter(peeked)
 
-	// Reset the reader
-	fullReader := io.MultiReader(strings.NewReader(peeked), file)
-	csvReader := csv.NewReader(fullReader)
-	csvReader.Comma = delimiter
+	return dataset, nil
+}
 
-	headers, err := csvReader.Read()
+// openCSV decodes a delimited text file to UTF-8, detects its dialect and
+// reads its header. Files without a header get their columns named after
+// their position. It returns the encoding the file was read as.
+func openCSV(file io.Reader, opts ImportOptions) ([]string, *csvRowReader, string, error) {
+	text, encoding, err := decodeText(file, opts.Encoding)
 	if err != nil {
-		return dataset, fmt.Errorf("failed to read headers: %w", err)
+		return nil, nil, "", err
 	}
 
-	const sampleLimit = 100
-	samples := make([][]string, len(headers))
-	for i := range samples {
-		samples[i] = []string{}
+	// Sniff the dialect from the start of the file
+	br := bufio.NewReaderSize(text, dialectSniffSize)
+	sample, err := br.Peek(dialectSniffSize)
+	if err != nil && err != io.EOF {
+		return nil, nil, "", fmt.Errorf("failed to peek file: %w", err)
+	}
+	dialect, err := sniffDialect(sample, len(sample) == dialectSniffSize, opts.Dialect)
+	if err != nil {
+		return nil, nil, "", err
 	}
 
-	var sampleRows [][]string
-	for len(sampleRows) < sampleLimit {
-		row, err := csvReader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("Skipping malformed sample row: %v", err)
-			continue
-		}
-		if len(row) != len(headers) {
-			log.Printf("Skipping malformed sample row: expected %d fields, got %d", len(headers), len(row))
-			continue
-		}
-		sampleRows = append(sampleRows, row)
-		for i, val := range row {
-			if i < len(samples) {
-				samples[i] = append(samples[i], val)
-			}
-		}
+	csvReader := newCSVRowReader(br, dialect)
+	first, err := csvReader.Read()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to read headers: %w", err)
+	}
+	if dialect.HasHeader {
+		return first, csvReader, encoding, nil
+	}
+	csvReader.unreadRow(first)
+	return normalizeHeaders(make([]string, len(first))), csvReader, encoding, nil
+}
+
+// rowReader is the minimal interface ingestRows needs from a parsed file.
+// CSV files are read through csvRowReader; other formats adapt their rows to
+// it.
+// Read returns io.EOF once there are no more rows.
+type rowReader interface {
+	Read() ([]string, error)
+}
+
+// sliceRowReader serves rows that have already been decoded into memory.
+type sliceRowReader struct {
+	rows [][]string
+	pos  int
+}
+
+// Len reports the total number of rows, letting ingestion report progress.
+func (r *sliceRowReader) Len() int {
+	return len(r.rows)
+}
+
+func (r *sliceRowReader) Read() ([]string, error) {
+	if r.pos >= len(r.rows) {
+		return nil, io.EOF
 	}
+	row := r.rows[r.pos]
+	r.pos++
+	return row, nil
+}
 
-	// Infer column types
-	fieldTypes := make([]string, len(headers))
-	for i := range headers {
-		fieldTypes[i] = inferType(samples[i])
+// ingestOptions tunes ingestRows for a particular source.
+type ingestOptions struct {
+	// fieldTypes overrides type inference when the source already knows the
+	// type of every column.
+	fieldTypes []string
+	// rejected lists rows the parser already had to drop. They are reported
+	// and counted like rows rejected during ingestion.
+	rejected      []RowRejection
+	limits        RejectionLimits
+	schema        Schema
+	rejectionSink func(datasetID uuid.UUID, rej RowRejection)
+	progress      func(IngestProgress)
+	// validate, when set, returns why a well-formed row must be rejected
+	validate func(row []string) string
+}
+
+func (o ImportOptions) ingestOptions() ingestOptions {
+	return ingestOptions{
+		limits:        o.Rejections,
+		schema:        o.Schema,
+		rejectionSink: o.rejectionSink,
+		progress:      o.Progress,
+	}
+}
+
+// ingestRows creates the dataset fields and stores every well-formed row in
+// the dataset. Column types are inferred from every stored value unless
+// opts.fieldTypes or the schema declares them. Malformed rows are skipped
+// and reported; the import fails with ErrTooManyRejections once opts.limits
+// are exceeded.
+func (s *DatasetService) ingestRows(ctx context.Context, dataset database.Dataset, headers []string, reader rowReader, opts ingestOptions) error {
+	schema, err := opts.schema.compile(headers)
+	if err != nil {
+		return err
+	}
+	if schema != nil {
+		opts.validate = schema.validate
 	}
 
-	// Insert fields
+	stream, err := newRowStream(dataset.ID, len(headers), reader, opts)
+	if err != nil {
+		return err
+	}
+
+	// Insert fields. Inferred columns start as text and get their type once
+	// every row has been seen.
 	fieldIDs := make([]uuid.UUID, len(headers))
+	inference := newColumnInference(len(headers))
 	for i, fieldName := range headers {
-		fieldID := uuid.New()
-		fieldIDs[i] = fieldID
-		err := s.Repo.Queries.CreateDatasetField(ctx, database.CreateDatasetFieldParams{
-			ID:          fieldID,
-			DatasetID:   dataset.ID,
-			Name:        fieldName,
-			DataType:    fieldTypes[i],
-			Description: sql.NullString{Valid: false},
-			CreatedAt:   time.Now(),
-		})
+		dataType := schema.declaredType(i)
+		if dataType == "" && opts.fieldTypes != nil {
+			dataType = opts.fieldTypes[i]
+		}
+		if dataType == "" {
+			dataType = TypeText
+			inference.track(i)
+		}
+
+		fieldID, err := s.createField(ctx, dataset.ID, fieldName, dataType, schema.format(i), schema.description(i))
 		if err != nil {
-			return database.Dataset{}, fmt.Errorf("failed to insert dataset field: %w", err)
+			return err
 		}
+		fieldIDs[i] = fieldID
 	}
+	stream.inference = inference
 
-	// Rebuild reader for full parse
-	headerLine := strings.Join(headers, string(delimiter))
-	dataLines := rowsToDelimited(sampleRows, delimiter)
-	reader := io.MultiReader(
-		strings.NewReader(strings.Join(append([]string{headerLine}, dataLines...), "\n")),
-		file,
-	)
-
-	csvReader = csv.NewReader(reader)
-	csvReader.Comma = delimiter
-	_, _ = csvReader.Read() // skip headers
+	if err := s.storeRows(ctx, dataset.ID, fieldIDs, stream); err != nil {
+		return err
+	}
+	return s.storeInferredTypes(ctx, fieldIDs, inference)
+}
 
-	type recordValue struct {
-		RecordID uuid.UUID
-		FieldID  uuid.UUID
-		Value    sql.NullString
+func (s *DatasetService) createField(ctx context.Context, datasetID uuid.UUID, name, dataType, dateFormat, description string) (uuid.UUID, error) {
+	fieldID := uuid.New()
+	err := s.Repo.Queries.CreateDatasetField(ctx, database.CreateDatasetFieldParams{
+		ID:          fieldID,
+		DatasetID:   datasetID,
+		Name:        name,
+		DataType:    dataType,
+		Description: sql.NullString{String: description, Valid: description != ""},
+		CreatedAt:   time.Now(),
+		DateFormat:  sql.NullString{String: dateFormat, Valid: dateFormat != ""},
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to insert dataset field: %w", err)
 	}
+	return fieldID, nil
+}
 
-	const batchSize = 1000
-	var batch []recordValue
+// storeRows inserts every remaining row of the stream into the dataset.
+// fieldIDs gives the field of each column of the rows.
+func (s *DatasetService) storeRows(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, stream *rowStream) error {
+	batch := &valueBatch{store: s.values(), copy: s.CopyIngest, inference: stream.inference, onFlush: stream.report}
 
-	flush := func() error {
-		if len(batch) == 0 {
-			return nil
-		}
-		values := make([]database.CreateRecordValueParams, len(batch))
-		for i, v := range batch {
-			values[i] = database.CreateRecordValueParams{
-				RecordID: v.RecordID,
-				FieldID:  v.FieldID,
-				Value:    v.Value,
-			}
-		}
-		err := s.Repo.BatchInsertRecordValues(ctx, values)
-		batch = batch[:0]
-		return err
+	// Rows are numbered in file order, after any the dataset already has
+	rowIndex, err := s.Repo.Queries.GetNextRowIndex(ctx, datasetID)
+	if err != nil {
+		return fmt.Errorf("failed to get next row index: %w", err)
 	}
 
-	for rowNum := sampleLimit + 2; ; rowNum++ {
-		row, err := csvReader.Read()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		row, pos, err := stream.next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Printf("Skipping malformed CSV row %d: read error: %v", rowNum, err)
-			continue
-		}
-		if len(row) != len(headers) {
-			log.Printf("Skipping malformed CSV row %d: expected %d fields, got %d", rowNum, len(headers), len(row))
-			continue
+			return err
 		}
 
+		now := time.Now()
 		recordID := uuid.New()
-		err = s.Repo.Queries.CreateDatasetRecord(ctx, database.CreateDatasetRecordParams{
+		batch.records = append(batch.records, database.CreateDatasetRecordParams{
 			ID:        recordID,
-			DatasetID: dataset.ID,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+			DatasetID: datasetID,
+			CreatedAt: now,
+			UpdatedAt: now,
+			RowIndex:  rowIndex,
 		})
-		if err != nil {
-			log.Printf("Failed to insert record for row %d: %v", rowNum, err)
-			continue
-		}
+		rowIndex++
+		stream.progress.RowsProcessed++
 
-		for i, val := range row {
-			val = strings.TrimSpace(val)
-			batch = append(batch, recordValue{
-				RecordID: recordID,
-				FieldID:  fieldIDs[i],
-				Value:    sql.NullString{String: val, Valid: val != ""},
-			})
-		}
-
-		if len(batch) >= batchSize {
-			if err := flush(); err != nil {
-				return dataset, fmt.Errorf("batch insert failed: %w", err)
-			}
+		if err := batch.addRow(ctx, recordID, fieldIDs, row); err != nil {
+			return fmt.Errorf("row %d: %w", pos.RowNumber, err)
 		}
 	}
 
-	if err := flush(); err != nil {
-		return dataset, fmt.Errorf("final batch insert failed: %w", err)
+	if err := batch.flush(ctx); err != nil {
+		return fmt.Errorf("final batch insert failed: %w", err)
 	}
+	stream.report()
 
-	return dataset, nil
+	return stream.checkLimits()
 }
 
-func inferType(values []string) string {
-	intCount := 0
-	floatCount := 0
-	boolCount := 0
-	dateCount := 0
-	total := len(values)
+// valueBatch buffers record values and writes them in batches, along with
+// the new records they belong to.
+type valueBatch struct {
+	store ValueStore
+	// upsert overwrites values that already exist
+	upsert bool
+	// copy batches are larger, as the store writes them with COPY
+	copy bool
+	// records are inserted before the values
+	records []database.CreateDatasetRecordParams
+	// inference sees the values of every row added
+	inference *columnInference
+	onFlush   func()
+	values    []database.CreateRecordValueParams
+}
 
-	for _, val := range values {
-		val = strings.TrimSpace(val)
-		if val == "" {
-			total--
-			continue
-		}
-		if _, err := strconv.Atoi(val); err == nil {
-			intCount++
-			continue
-		}
-		if _, err := strconv.ParseFloat(val, 64); err == nil {
-			floatCount++
-			continue
-		}
-		if _, err := strconv.ParseBool(val); err == nil {
-			boolCount++
+const valueBatchSize = 1000
+
+// copyBatchSize is the number of values written by one COPY. Larger batches
+// amortise the round trips but hold more rows in memory.
+const copyBatchSize = 50000
+
+// addRow queues the values of a row, flushing once the batch is full. A nil
+// field ID skips the column.
+func (b *valueBatch) addRow(ctx context.Context, recordID uuid.UUID, fieldIDs []uuid.UUID, row []string) error {
+	b.inference.observe(row)
+	for i, val := range row {
+		if fieldIDs[i] == uuid.Nil {
 			continue
 		}
-		if isDate(val) {
-			dateCount++
-			continue
+		b.values = append(b.values, database.CreateRecordValueParams{
+			RecordID: recordID,
+			FieldID:  fieldIDs[i],
+			Value:    storedValue(val),
+		})
+	}
+
+	size := valueBatchSize
+	if b.copy {
+		size = copyBatchSize
+	}
+	if len(b.values) >= size || len(b.records) >= size {
+		if err := b.flush(ctx); err != nil {
+			return fmt.Errorf("batch insert failed: %w", err)
 		}
+	}
+	return nil
+}
 
+func (b *valueBatch) flush(ctx context.Context) error {
+	if len(b.values) == 0 && len(b.records) == 0 {
+		return nil
+	}
+	var err error
+	if b.upsert {
+		err = b.store.UpsertRecords(ctx, b.records, b.values)
+	} else {
+		err = b.store.InsertRecords(ctx, b.records, b.values)
 	}
+	b.records = b.records[:0]
+	b.values = b.values[:0]
+	if err == nil && b.onFlush != nil {
+		b.onFlush()
+	}
+	return err
+}
 
-	if intCount == total {
-		return "integer"
+// rowStream reads the well-formed rows of a file, rejecting the rest, and
+// keeps track of ingestion progress.
+type rowStream struct {
+	reader    rowReader
+	width     int
+	opts      ingestOptions
+	recorder  *rejectionRecorder
+	progress  IngestProgress
+	recordNum int64
+	// inference, when set, infers column types from the stored rows
+	inference *columnInference
+}
+
+func newRowStream(datasetID uuid.UUID, width int, reader rowReader, opts ingestOptions) (*rowStream, error) {
+	stream := &rowStream{
+		reader:   reader,
+		width:    width,
+		opts:     opts,
+		recorder: &rejectionRecorder{sink: opts.rejectionSink, datasetID: datasetID},
+		progress: IngestProgress{DatasetID: datasetID},
 	}
-	if floatCount+intCount >= total {
-		return "float"
+	if sized, ok := reader.(interface{ Len() int }); ok {
+		stream.progress.RowsTotal = int64(sized.Len() + len(opts.rejected))
 	}
-	if boolCount >= total {
-		return "boolean"
+
+	for _, rej := range opts.rejected {
+		if err := stream.reject(rej); err != nil {
+			return nil, err
+		}
 	}
-	if dateCount >= total {
-		return "datetime"
+	return stream, nil
+}
+
+func (st *rowStream) report() {
+	if st.opts.progress != nil {
+		st.opts.progress(st.progress)
+	}
+}
+
+// reject records a skipped row and fails once the rejection limits are
+// exceeded.
+func (st *rowStream) reject(rej RowRejection) error {
+	st.recorder.record(rej)
+	st.progress.RowsRejected++
+	if reason := st.opts.limits.exceeded(st.progress, false); reason != "" {
+		st.report()
+		return fmt.Errorf("%w: %s", ErrTooManyRejections, reason)
+	}
+	return nil
+}
+
+// checkLimits applies the rejection limits once every row has been read.
+func (st *rowStream) checkLimits() error {
+	if reason := st.opts.limits.exceeded(st.progress, true); reason != "" {
+		return fmt.Errorf("%w: %s", ErrTooManyRejections, reason)
+	}
+	return nil
+}
+
+// next returns the next well-formed row along with where it came from.
+func (st *rowStream) next() ([]string, RowRejection, error) {
+	for {
+		row, err := st.reader.Read()
+		if err == io.EOF {
+			return nil, RowRejection{}, io.EOF
+		}
+
+		st.recordNum++
+		pos := RowRejection{RowNumber: st.recordNum}
+		if p, ok := st.reader.(rowPositioner); ok {
+			pos = p.position()
+		}
+		if pos.Raw == "" && row != nil {
+			pos.Raw = encodeRow(row)
+		}
+
+		if err != nil && !isRowError(err) {
+			return nil, pos, fmt.Errorf("failed to read row %d: %w", pos.RowNumber, err)
+		}
+
+		switch {
+		case err != nil:
+			pos.Reason = rejectionReason(err, row, st.width)
+		case len(row) != st.width:
+			pos.Reason = fmt.Sprintf("expected %d fields, got %d", st.width, len(row))
+		case st.opts.validate != nil:
+			pos.Reason = st.opts.validate(row)
+		}
+		if pos.Reason == "" {
+			return row, pos, nil
+		}
+		if err := st.reject(pos); err != nil {
+			return nil, pos, err
+		}
 	}
-	return "text"
 }
 
 func isDate(val string) bool {
+	_, ok := parseDate(val)
+	return ok
+}
+
+// parseDate parses val using the date layouts recognised by type inference.
+func parseDate(val string) (time.Time, bool) {
 	formats := []string{
 		time.RFC3339, "2006-01-02", "01/02/2006", "02-Jan-2006", "Jan-02-2006", "02-Jan-06", "Jan-02-06",
 	}
 	for _, format := range formats {
-		if _, err := time.Parse(format, val); err == nil {
-			return true
+		if t, err := time.Parse(format, val); err == nil {
+			return t, true
 		}
 	}
-	return false
+	return time.Time{}, false
 }
 
+// GetNumericColumnValues returns the non-empty values of a column as
+// numbers, in row order.
 func (s *DatasetService) GetNumericColumnValues(ctx context.Context, datasetID, userID uuid.UUID, column string) ([]float64, error) {
-	headers, rows, err := s.GetDatasetRows(ctx, datasetID, userID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get dataset rows: %w", err)
-	}
+	values, _, err := s.GetNumericColumn(ctx, datasetID, userID, column)
+	return values, err
+}
 
-	// Find the index of the requested column
-	colIdx := -1
-	for i, h := range headers {
-		if h == column {
-			colIdx = i
-			break
-		}
-	}
-	if colIdx == -1 {
-		return nil, fmt.Errorf("column '%s' not found in dataset", column)
+// GetNumericColumn is GetNumericColumnValues along with the row index of
+// each value, so results can be traced back to their rows. Like
+// GetDatasetRows, it reads the version carried by ctx, if any.
+func (s *DatasetService) GetNumericColumn(ctx context.Context, datasetID, userID uuid.UUID, column string) ([]float64, []int64, error) {
+	// Earlier versions are cached under their own ID
+	cacheKey := datasetID
+	versionID, versioned := versionFromContext(ctx)
+	if versioned {
+		cacheKey = versionID
 	}
 
-	var values []float64
-	for i, row := range rows {
-		if colIdx >= len(row) {
-			continue // skip malformed rows
-		}
-		raw := row[colIdx]
-		if raw == "" {
-			continue
-		}
-		parsed, err := strconv.ParseFloat(raw, 64)
-		if err != nil {
-			return nil, fmt.Errorf("row %d: cannot parse '%s' as float64", i, raw)
-		}
-		values = append(values, parsed)
+	// Callers may sort the values in place, so they get a copy of the
+	// cached ones.
+	if col, ok := s.Cache.getColumn(cacheKey, column); ok {
+		return slices.Clone(col.values), slices.Clone(col.rowIndexes), nil
 	}
+	generation := s.Cache.begin()
 
+	var (
+		values     []float64
+		rowIndexes []int64
+		err        error
+	)
+	if versioned {
+		values, rowIndexes, err = s.versionNumericColumn(ctx, datasetID, versionID, column)
+	} else {
+		values, rowIndexes, err = s.numericColumn(ctx, datasetID, column)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 	if len(values) == 0 {
-		return nil, fmt.Errorf("no valid numeric values found in column '%s'", column)
+		return nil, nil, fmt.Errorf("no valid numeric values found in column '%s'", column)
+	}
+
+	s.Cache.putColumn(cacheKey, generation, column, cachedColumn{
+		values:     slices.Clone(values),
+		rowIndexes: slices.Clone(rowIndexes),
+	})
+	return values, rowIndexes, nil
+}
+
+func (s *DatasetService) numericColumn(ctx context.Context, datasetID uuid.UUID, column string) ([]float64, []int64, error) {
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get fields: %w", err)
 	}
 
-	return values, nil
+	fieldID := uuid.Nil
+	for _, f := range fields {
+		if f.Name == column {
+			fieldID = f.ID
+			break
+		}
+	}
+	if fieldID == uuid.Nil {
+		return nil, nil, fmt.Errorf("column '%s' not found in dataset", column)
+	}
+	return s.values().NumericColumn(ctx, datasetID, fieldID)
 }
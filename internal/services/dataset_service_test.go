@@ -1,18 +1,35 @@
 package services_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Bgoodwin24/insightforge/internal/auth"
 	"github.com/Bgoodwin24/insightforge/internal/database"
 	"github.com/Bgoodwin24/insightforge/internal/services"
 	"github.com/Bgoodwin24/insightforge/internal/testutils"
 	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/unicode"
 )
 
 func TestCreateDataset(t *testing.T) {
@@ -208,3 +225,1901 @@ func TestUploadDataset(t *testing.T) {
 		assert.Len(t, values, 2) // id and value fields
 	}
 }
+
+func TestUploadWorkbook(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	wb := excelize.NewFile()
+	defer wb.Close()
+	require.NoError(t, wb.SetSheetRow("Sheet1", "A1", &[]interface{}{"Quarterly report"}))
+	require.NoError(t, wb.SetSheetRow("Sheet1", "A2", &[]interface{}{"region", "", "amount"}))
+	require.NoError(t, wb.SetSheetRow("Sheet1", "A3", &[]interface{}{"North", "a", 10}))
+	require.NoError(t, wb.SetSheetRow("Sheet1", "A4", &[]interface{}{"", "b", 20}))
+	require.NoError(t, wb.SetSheetRow("Sheet1", "A6", &[]interface{}{"South", "c", 5}))
+	require.NoError(t, wb.MergeCell("Sheet1", "A3", "A4"))
+	_, err := wb.NewSheet("Totals")
+	require.NoError(t, err)
+	require.NoError(t, wb.SetSheetRow("Totals", "A1", &[]interface{}{"total"}))
+	require.NoError(t, wb.SetSheetRow("Totals", "A2", &[]interface{}{30}))
+
+	var buf bytes.Buffer
+	require.NoError(t, wb.Write(&buf))
+
+	datasets, err := svc.UploadWorkbook(context.Background(), user.ID, "report.xlsx", bytes.NewReader(buf.Bytes()), services.WorkbookOptions{
+		Sheets:    []string{"Sheet1"},
+		HeaderRow: 2,
+	})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	assert.Equal(t, "report.xlsx", datasets[0].Name)
+
+	header, rows, err := svc.GetDatasetRows(context.Background(), datasets[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"region", "column_2", "amount"}, header)
+	assert.Equal(t, [][]string{{"North", "a", "10"}, {"North", "b", "20"}, {"South", "c", "5"}}, rows)
+
+	fields, err := repo.Queries.GetDatasetFields(context.Background(), datasets[0].ID)
+	require.NoError(t, err)
+	fieldTypes := map[string]string{}
+	for _, f := range fields {
+		fieldTypes[f.Name] = f.DataType
+	}
+	assert.Equal(t, "integer", fieldTypes["amount"])
+
+	// Every sheet becomes its own dataset
+	datasets, err = svc.UploadWorkbook(context.Background(), user.ID, "all.xlsx", bytes.NewReader(buf.Bytes()), services.WorkbookOptions{
+		Sheets: []string{services.AllSheets},
+	})
+	require.NoError(t, err)
+	require.Len(t, datasets, 2)
+	assert.Equal(t, "all.xlsx - Totals", datasets[1].Name)
+}
+
+func TestUploadJSON(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	content := `{"id": 1, "user": {"name": "ann", "address": {"city": "Oslo"}}, "tags": ["a", "b"]}
+{"id": 2, "user": {"name": "bob"}, "active": true}
+`
+	dataset, err := svc.UploadJSON(context.Background(), user.ID, "events.ndjson", bytes.NewReader([]byte(content)))
+	require.NoError(t, err)
+	assert.Equal(t, "events.ndjson", dataset.Name)
+
+	header, rows, err := svc.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"id", "tags", "user.address.city", "user.name", "active"}, header)
+	require.Len(t, rows, 2)
+
+	row := map[string]string{}
+	for i, h := range header {
+		row[h] = rows[0][i]
+	}
+	assert.Equal(t, "Oslo", row["user.address.city"])
+	assert.Equal(t, `["a","b"]`, row["tags"])
+	assert.Equal(t, "", row["active"])
+
+	fields, err := repo.Queries.GetDatasetFields(context.Background(), dataset.ID)
+	require.NoError(t, err)
+	fieldTypes := map[string]string{}
+	for _, f := range fields {
+		fieldTypes[f.Name] = f.DataType
+	}
+	assert.Equal(t, "integer", fieldTypes["id"])
+	assert.Equal(t, "boolean", fieldTypes["active"])
+
+	// A JSON array of records is accepted as well
+	dataset, err = svc.UploadJSON(context.Background(), user.ID, "events.json", bytes.NewReader([]byte(`[{"a": 1}, {"b": 2}]`)))
+	require.NoError(t, err)
+	header, rows, err = svc.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, header)
+	assert.Equal(t, [][]string{{"1", ""}, {"", "2"}}, rows)
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	content := []byte("zip,amount,active,joined\n01234,1.5,true,2024-01-02\n98765,2,false,\n")
+	original, err := svc.UploadDataset(context.Background(), user.ID, "typed.csv", bytes.NewReader(content))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, svc.ExportParquet(context.Background(), original.ID, user.ID, &buf))
+
+	imported, err := svc.UploadParquet(context.Background(), user.ID, "typed.parquet", bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	header, rows, err := svc.GetDatasetRows(context.Background(), imported.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zip", "amount", "active", "joined"}, header)
+	require.Len(t, rows, 2)
+	assert.Equal(t, []string{"1234", "1.5", "true", "2024-01-02T00:00:00Z"}, rows[0])
+	assert.Equal(t, "", rows[1][3])
+
+	fields, err := repo.Queries.GetDatasetFields(context.Background(), imported.ID)
+	require.NoError(t, err)
+	fieldTypes := map[string]string{}
+	for _, f := range fields {
+		fieldTypes[f.Name] = f.DataType
+	}
+	assert.Equal(t, "integer", fieldTypes["zip"])
+	assert.Equal(t, "float", fieldTypes["amount"])
+	assert.Equal(t, "boolean", fieldTypes["active"])
+	assert.Equal(t, "datetime", fieldTypes["joined"])
+}
+
+func TestUploadJob(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	svc.UploadDir = t.TempDir()
+	user := testutils.CreateTestUser(t, repo, email)
+
+	content := "name,age\nAlice,30\nBob,25\nCarol,oops,extra\n"
+	job, err := svc.StartUploadJob(context.Background(), user.ID, "people.csv", bytes.NewReader([]byte(content)), services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, services.JobPhaseQueued, job.Phase)
+
+	var status services.UploadJobStatus
+	require.Eventually(t, func() bool {
+		status, err = svc.GetUploadJob(context.Background(), job.ID, user.ID)
+		require.NoError(t, err)
+		return status.Job.Phase == services.JobPhaseCompleted || status.Job.Phase == services.JobPhaseFailed
+	}, 5*time.Second, 20*time.Millisecond)
+
+	assert.Equal(t, services.JobPhaseCompleted, status.Job.Phase)
+	assert.Equal(t, int64(2), status.Job.RowsProcessed)
+	assert.Equal(t, int64(1), status.Job.RowsRejected)
+	assert.Equal(t, int64(len(content)), status.Job.BytesTotal)
+	require.Len(t, status.DatasetIDs, 1)
+
+	dataset, err := svc.GetDatasetByIDForUser(context.Background(), user.ID, status.DatasetIDs[0])
+	require.NoError(t, err)
+	assert.Equal(t, services.DatasetStatusReady, dataset.Status)
+
+	// Jobs of other users are not visible
+	other := testutils.CreateTestUser(t, repo, fmt.Sprintf("other_%d@example.com", time.Now().UnixNano()))
+	_, err = svc.GetUploadJob(context.Background(), job.ID, other.ID)
+	assert.ErrorIs(t, err, services.ErrUploadJobNotFound)
+
+	err = svc.CancelUploadJob(context.Background(), job.ID, user.ID)
+	assert.ErrorIs(t, err, services.ErrUploadJobFinished)
+}
+
+func TestRecoverUploadJobs(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	// One job was left behind long ago, the other is running elsewhere
+	startJob := func(createdAt time.Time) (database.UploadJob, database.Dataset) {
+		job, err := repo.Queries.CreateUploadJob(ctx, database.CreateUploadJobParams{
+			ID:        uuid.New(),
+			UserID:    user.ID,
+			Filename:  "people.csv",
+			Phase:     services.JobPhaseInserting,
+			CreatedAt: createdAt,
+		})
+		require.NoError(t, err)
+		dataset, err := repo.Queries.CreateDataset(ctx, database.CreateDatasetParams{
+			ID:          uuid.New(),
+			UserID:      user.ID,
+			Name:        "people",
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+			Status:      services.DatasetStatusPending,
+			UploadJobID: uuid.NullUUID{UUID: job.ID, Valid: true},
+		})
+		require.NoError(t, err)
+		return job, dataset
+	}
+	staleJob, staleDataset := startJob(time.Now().Add(-time.Hour))
+	liveJob, liveDataset := startJob(time.Now())
+
+	require.NoError(t, svc.RecoverUploadJobs(ctx))
+
+	job, err := repo.Queries.GetUploadJob(ctx, staleJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, services.JobPhaseFailed, job.Phase)
+	_, err = repo.Queries.GetDatasetByID(ctx, staleDataset.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	job, err = repo.Queries.GetUploadJob(ctx, liveJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, services.JobPhaseInserting, job.Phase)
+	_, err = repo.Queries.GetDatasetByID(ctx, liveDataset.ID)
+	assert.NoError(t, err)
+}
+
+func TestCancelUploadJobElsewhere(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	// Neither job runs in this process: one was left behind long ago, the
+	// other is running in another instance
+	startJob := func(createdAt time.Time) (database.UploadJob, database.Dataset) {
+		job, err := repo.Queries.CreateUploadJob(ctx, database.CreateUploadJobParams{
+			ID:        uuid.New(),
+			UserID:    user.ID,
+			Filename:  "people.csv",
+			Phase:     services.JobPhaseInserting,
+			CreatedAt: createdAt,
+		})
+		require.NoError(t, err)
+		dataset, err := repo.Queries.CreateDataset(ctx, database.CreateDatasetParams{
+			ID:          uuid.New(),
+			UserID:      user.ID,
+			Name:        "people",
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+			Status:      services.DatasetStatusPending,
+			UploadJobID: uuid.NullUUID{UUID: job.ID, Valid: true},
+		})
+		require.NoError(t, err)
+		return job, dataset
+	}
+	staleJob, staleDataset := startJob(time.Now().Add(-time.Hour))
+	liveJob, liveDataset := startJob(time.Now())
+
+	require.NoError(t, svc.CancelUploadJob(ctx, staleJob.ID, user.ID))
+	job, err := repo.Queries.GetUploadJob(ctx, staleJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, services.JobPhaseCancelled, job.Phase)
+	_, err = repo.Queries.GetDatasetByID(ctx, staleDataset.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// The running job is left to stop itself at its next heartbeat
+	require.NoError(t, svc.CancelUploadJob(ctx, liveJob.ID, user.ID))
+	job, err = repo.Queries.GetUploadJob(ctx, liveJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, services.JobPhaseInserting, job.Phase)
+	assert.True(t, job.CancelRequested)
+	_, err = repo.Queries.GetDatasetByID(ctx, liveDataset.ID)
+	assert.NoError(t, err)
+
+	cancelRequested, err := repo.Queries.HeartbeatUploadJob(ctx, database.HeartbeatUploadJobParams{
+		ID:          liveJob.ID,
+		HeartbeatAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	require.NoError(t, err)
+	assert.True(t, cancelRequested)
+}
+
+func TestUploadRejections(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	content := "name,age\nAlice,30\nBob\nCarol,41,extra\nDan,52\n"
+	status, datasets, err := svc.ImportUpload(context.Background(), user.ID, "people.csv", bytes.NewReader([]byte(content)), services.ImportOptions{})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	assert.Equal(t, services.JobPhaseCompleted, status.Job.Phase)
+	assert.Equal(t, int64(2), status.Job.RowsProcessed)
+	assert.Equal(t, int64(2), status.Job.RowsRejected)
+
+	rejections, err := svc.ListUploadRejections(context.Background(), status.Job.ID, user.ID)
+	require.NoError(t, err)
+	require.Len(t, rejections, 2)
+	assert.Equal(t, int64(3), rejections[0].RowNumber)
+	assert.Equal(t, "Bob", rejections[0].Raw)
+	assert.Equal(t, "expected 2 fields, got 1", rejections[0].Reason)
+	assert.Equal(t, int64(4), rejections[1].RowNumber)
+	assert.Equal(t, "Carol,41,extra", rejections[1].Raw)
+	assert.Equal(t, "expected 2 fields, got 3", rejections[1].Reason)
+
+	var buf bytes.Buffer
+	require.NoError(t, services.WriteRejectionsCSV(&buf, rejections))
+	assert.Contains(t, buf.String(), "dataset_id,row_number,reason,raw\n")
+	assert.Contains(t, buf.String(), ",3,\"expected 2 fields, got 1\",Bob\n")
+
+	// Exceeding the threshold fails the upload but keeps the report
+	status, _, err = svc.ImportUpload(context.Background(), user.ID, "people.csv", bytes.NewReader([]byte(content)), services.ImportOptions{
+		Rejections: services.RejectionLimits{MaxPercent: 25},
+	})
+	assert.ErrorIs(t, err, services.ErrTooManyRejections)
+	assert.Equal(t, services.JobPhaseFailed, status.Job.Phase)
+	assert.Empty(t, status.DatasetIDs)
+
+	rejections, err = svc.ListUploadRejections(context.Background(), status.Job.ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, rejections, 2)
+
+	_, _, err = svc.ImportUpload(context.Background(), user.ID, "people.csv", bytes.NewReader([]byte(content)), services.ImportOptions{
+		Rejections: services.RejectionLimits{MaxRows: 1},
+	})
+	assert.ErrorIs(t, err, services.ErrTooManyRejections)
+}
+
+func TestImportFileIsAtomic(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	var content bytes.Buffer
+	content.WriteString("id,value\n")
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&content, "%d,%d\n", i, i*2)
+	}
+	content.WriteString("bad\nworse\n")
+
+	_, err := svc.ImportFile(context.Background(), user.ID, "big.csv", bytes.NewReader(content.Bytes()), services.ImportOptions{
+		Rejections: services.RejectionLimits{MaxRows: 1},
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, services.ErrTooManyRejections)
+
+	// Nothing from the failed import may be left behind
+	var datasets, records int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM datasets WHERE user_id = $1", user.ID).Scan(&datasets))
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM dataset_records").Scan(&records))
+	assert.Zero(t, datasets)
+	assert.Zero(t, records)
+
+	imported, err := svc.ImportFile(context.Background(), user.ID, "big.csv", bytes.NewReader(content.Bytes()), services.ImportOptions{})
+	require.NoError(t, err)
+	require.Len(t, imported, 1)
+
+	_, rows, err := svc.GetDatasetRows(context.Background(), imported[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, rows, 2500)
+}
+
+func TestAppendToDataset(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	dataset, err := svc.UploadDataset(context.Background(), user.ID, "weekly.csv", bytes.NewReader([]byte("region,sales\nnorth,10\nsouth,20\n")))
+	require.NoError(t, err)
+
+	// Strict matching accepts the same columns in any order
+	result, err := svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte("sales,region\n30,east\n")), services.AppendOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsAppended)
+
+	// ...but not a missing or unknown column
+	_, err = svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte("Region\nwest\n")), services.AppendOptions{})
+	assert.ErrorIs(t, err, services.ErrColumnMismatch)
+
+	// Lenient matching ignores case and leaves missing columns empty
+	result, err = svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte(" REGION \nwest\n")), services.AppendOptions{
+		Match: services.ColumnMatchLenient,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsAppended)
+
+	result, err = svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte("region,sales,units\ncentral,50,5\n")), services.AppendOptions{
+		AddColumns: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"units"}, result.AddedColumns)
+
+	header, rows, err := svc.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"region", "sales", "units"}, header)
+	assert.ElementsMatch(t, [][]string{
+		{"north", "10", ""},
+		{"south", "20", ""},
+		{"east", "30", ""},
+		{"west", "", ""},
+		{"central", "50", "5"},
+	}, rows)
+}
+
+func TestMergeIntoDataset(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	dataset, err := svc.UploadDataset(context.Background(), user.ID, "stock.csv", bytes.NewReader([]byte("store,sku,qty\n1,A,10\n1,B,20\n2,A,30\n")))
+	require.NoError(t, err)
+
+	file := "store,sku,qty\n1,A,11\n2,B,5\n2,B,6\n,C,1\n"
+	result, err := svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte(file)), services.AppendOptions{
+		Keys: []string{"store", "sku"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsUpdated)
+	assert.Equal(t, int64(1), result.RowsAppended)
+	assert.Equal(t, int64(0), result.RowsDeleted)
+	// The repeated key and the empty key are rejected
+	assert.Equal(t, int64(2), result.RowsRejected)
+
+	_, rows, err := svc.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"1", "A", "11"}, {"1", "B", "20"}, {"2", "A", "30"}, {"2", "B", "5"}}, rows)
+
+	result, err = svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte("store,sku,qty\n1,A,12\n")), services.AppendOptions{
+		Keys:          []string{"store", "sku"},
+		DeleteMissing: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.RowsUpdated)
+	assert.Equal(t, int64(3), result.RowsDeleted)
+
+	_, rows, err = svc.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"1", "A", "12"}}, rows)
+
+	_, err = svc.AppendToDataset(context.Background(), user.ID, dataset.ID, bytes.NewReader([]byte("store,sku,qty\n1,A,1\n")), services.AppendOptions{
+		Keys: []string{"warehouse"},
+	})
+	assert.ErrorIs(t, err, services.ErrColumnMismatch)
+}
+
+func TestUploadWithSchema(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	schema, err := services.ParseSchema([]byte(`{
+		"zip": {"type": "text", "description": "Postal code"},
+		"visits": {"type": "integer", "nullable": false},
+		"joined": {"type": "datetime", "format": "DD/MM/YYYY"}
+	}`))
+	require.NoError(t, err)
+
+	content := "zip,visits,joined\n02134,3,01/02/2024\n10001,N/A,02/02/2024\n94105,,03/02/2024\n60601,7,2024-02-04\n30301,1,\n"
+	status, datasets, err := svc.ImportUpload(context.Background(), user.ID, "visits.csv", bytes.NewReader([]byte(content)), services.ImportOptions{
+		Schema: schema,
+	})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	assert.Equal(t, int64(2), status.Job.RowsProcessed)
+	assert.Equal(t, int64(3), status.Job.RowsRejected)
+
+	fields, err := repo.Queries.GetFieldsByDatasetID(context.Background(), datasets[0].ID)
+	require.NoError(t, err)
+	types := make(map[string]string)
+	for _, f := range fields {
+		types[f.Name] = f.DataType
+		if f.Name == "zip" {
+			assert.Equal(t, "Postal code", f.Description.String)
+		}
+	}
+	assert.Equal(t, map[string]string{"zip": "text", "visits": "integer", "joined": "datetime"}, types)
+
+	_, rows, err := svc.GetDatasetRows(context.Background(), datasets[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"02134", "3", "01/02/2024"}, {"30301", "1", ""}}, rows)
+
+	rejections, err := svc.ListUploadRejections(context.Background(), status.Job.ID, user.ID)
+	require.NoError(t, err)
+	require.Len(t, rejections, 3)
+	assert.Equal(t, `column "visits": "N/A" is not a valid integer`, rejections[0].Reason)
+	assert.Equal(t, `column "visits" is empty but not nullable`, rejections[1].Reason)
+	assert.Equal(t, `column "joined": "2024-02-04" does not match the date format "DD/MM/YYYY"`, rejections[2].Reason)
+
+	_, _, err = svc.ImportUpload(context.Background(), user.ID, "visits.csv", bytes.NewReader([]byte(content)), services.ImportOptions{
+		Schema: services.Schema{"country": {Type: services.TypeText}},
+	})
+	assert.ErrorIs(t, err, services.ErrInvalidSchema)
+
+	_, err = services.ParseSchema([]byte(`{"zip": {"type": "zipcode"}}`))
+	assert.ErrorIs(t, err, services.ErrInvalidSchema)
+}
+
+func TestTypeInference(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	// Rows past the first hundred still count towards the inferred type
+	var content bytes.Buffer
+	content.WriteString("active,visitors,revenue,share,code\n")
+	for i := 0; i < 150; i++ {
+		fmt.Fprintf(&content, "%d,\"%d,000\",$%d.50,%d%%,%d\n", i%2, i+1, i, i%100, i)
+	}
+	content.WriteString("NA,null,-,,X12\n")
+
+	dataset, err := svc.UploadDataset(context.Background(), user.ID, "traffic.csv", bytes.NewReader(content.Bytes()))
+	require.NoError(t, err)
+
+	fields, err := svc.GetFieldsForDataset(context.Background(), dataset.ID)
+	require.NoError(t, err)
+	byName := make(map[string]services.FieldInfo)
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	assert.Equal(t, "boolean", byName["active"].DataType)
+	assert.Equal(t, "integer", byName["visitors"].DataType)
+	assert.Equal(t, "float", byName["revenue"].DataType)
+	assert.Equal(t, "float", byName["share"].DataType)
+	require.NotNil(t, byName["active"].TypeConfidence)
+	assert.Equal(t, 1.0, *byName["active"].TypeConfidence)
+
+	// One value in 151 is not an integer, which is within tolerance
+	code := byName["code"]
+	assert.Equal(t, "integer", code.DataType)
+	require.NotNil(t, code.TypeConfidence)
+	assert.InDelta(t, 150.0/151.0, *code.TypeConfidence, 1e-9)
+	assert.Equal(t, []string{"X12"}, code.TypeCounterexamples)
+
+	// Numeric analytics read the values the way they were typed, and null
+	// tokens are stored as empty cells
+	_, rows, err := svc.GetDatasetRows(context.Background(), dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "", "", "", "X12"}, rows[150])
+	visitors, err := svc.GetNumericColumnValues(context.Background(), dataset.ID, user.ID, "visitors")
+	require.NoError(t, err)
+	require.Len(t, visitors, 150)
+	assert.Equal(t, 1000.0, visitors[0])
+	revenue, err := svc.GetNumericColumnValues(context.Background(), dataset.ID, user.ID, "revenue")
+	require.NoError(t, err)
+	require.Len(t, revenue, 150)
+	assert.Equal(t, 5.5, revenue[5])
+	share, err := svc.GetNumericColumnValues(context.Background(), dataset.ID, user.ID, "share")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.45, share[45], 1e-9)
+
+	svc.Storage = services.StorageText
+	text, err := svc.ImportFile(context.Background(), user.ID, "traffic.csv", bytes.NewReader(content.Bytes()), services.ImportOptions{})
+	require.NoError(t, err)
+	revenue, err = svc.GetNumericColumnValues(context.Background(), text[0].ID, user.ID, "revenue")
+	require.NoError(t, err)
+	assert.Equal(t, 5.5, revenue[5])
+	svc.Storage = services.StorageTyped
+
+	// Declared types are not inferred
+	schema := services.Schema{"code": {Type: services.TypeText}}
+	datasets, err := svc.ImportFile(context.Background(), user.ID, "traffic.csv", bytes.NewReader(content.Bytes()), services.ImportOptions{Schema: schema})
+	require.NoError(t, err)
+	fields, err = svc.GetFieldsForDataset(context.Background(), datasets[0].ID)
+	require.NoError(t, err)
+	for _, f := range fields {
+		if f.Name == "code" {
+			assert.Equal(t, "text", f.DataType)
+			assert.Nil(t, f.TypeConfidence)
+		}
+	}
+}
+
+func TestUploadEncodings(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	text := "name,city\nJosé,Zürich\n"
+	utf16, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(text)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		encoding string
+		detected string
+	}{
+		{"utf-8 with BOM", append([]byte{0xEF, 0xBB, 0xBF}, text...), "", services.EncodingUTF8},
+		{"utf-16 with BOM", []byte(utf16), "", services.EncodingUTF16LE},
+		{"windows-1252", []byte("name,city\nJos\xe9,Z\xfcrich\n"), "", services.EncodingWindows1252},
+		{"explicit latin-1", []byte("name,city\nJos\xe9,Z\xfcrich\n"), "latin1", services.EncodingLatin1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datasets, err := svc.ImportFile(context.Background(), user.ID, "cities.csv", bytes.NewReader(tt.content), services.ImportOptions{
+				Encoding: tt.encoding,
+			})
+			require.NoError(t, err)
+			require.Len(t, datasets, 1)
+			assert.Equal(t, tt.detected, datasets[0].Encoding.String)
+
+			header, rows, err := svc.GetDatasetRows(context.Background(), datasets[0].ID, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"name", "city"}, header)
+			assert.Equal(t, [][]string{{"José", "Zürich"}}, rows)
+		})
+	}
+
+	_, err = svc.ImportFile(context.Background(), user.ID, "cities.csv", bytes.NewReader([]byte(text)), services.ImportOptions{
+		Encoding: "ebcdic",
+	})
+	assert.ErrorIs(t, err, services.ErrUnknownEncoding)
+}
+
+func TestCSVDialects(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	noHeader := false
+	tests := []struct {
+		name    string
+		content string
+		dialect services.DialectOptions
+		header  []string
+		rows    [][]string
+	}{
+		{
+			name:    "semicolons with decimal commas",
+			content: "name;amount\nAnn;1,5\nBob;2,75\n",
+			header:  []string{"name", "amount"},
+			rows:    [][]string{{"Ann", "1,5"}, {"Bob", "2,75"}},
+		},
+		{
+			name:    "pipes",
+			content: "ACCT|NAME|BAL\n0001|SMITH, J|100\n0002|DOE, A|200\n",
+			header:  []string{"ACCT", "NAME", "BAL"},
+			rows:    [][]string{{"0001", "SMITH, J", "100"}, {"0002", "DOE, A", "200"}},
+		},
+		{
+			name:    "single quotes",
+			content: "name,note\n'Smith, J','hi'\n'Doe, A','it''s'\n",
+			header:  []string{"name", "note"},
+			rows:    [][]string{{"Smith, J", "hi"}, {"Doe, A", "it's"}},
+		},
+		{
+			name:    "no header",
+			content: "1,Ann,30\n2,Bob,41\n",
+			header:  []string{"column_1", "column_2", "column_3"},
+			rows:    [][]string{{"1", "Ann", "30"}, {"2", "Bob", "41"}},
+		},
+		{
+			name:    "overridden",
+			content: "a~b\nc~d\n",
+			dialect: services.DialectOptions{Delimiter: '~', Header: &noHeader},
+			header:  []string{"column_1", "column_2"},
+			rows:    [][]string{{"a", "b"}, {"c", "d"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datasets, err := svc.ImportFile(context.Background(), user.ID, "export.csv", bytes.NewReader([]byte(tt.content)), services.ImportOptions{
+				Dialect: tt.dialect,
+			})
+			require.NoError(t, err)
+			require.Len(t, datasets, 1)
+
+			header, rows, err := svc.GetDatasetRows(context.Background(), datasets[0].ID, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.header, header)
+			assert.ElementsMatch(t, tt.rows, rows)
+		})
+	}
+
+	_, err := svc.ImportFile(context.Background(), user.ID, "export.csv", bytes.NewReader([]byte("a,b\n")), services.ImportOptions{
+		Dialect: services.DialectOptions{Delimiter: '"'},
+	})
+	assert.ErrorIs(t, err, services.ErrInvalidDialect)
+}
+
+func TestCompressedUploads(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte("id,value\n1,100\n2,200\n"))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	datasets, err := svc.ImportFile(context.Background(), user.ID, "sales.csv.gz", bytes.NewReader(gz.Bytes()), services.ImportOptions{})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	assert.Equal(t, "sales.csv", datasets[0].Name)
+	_, rows, err := svc.GetDatasetRows(context.Background(), datasets[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"1", "100"}, {"2", "200"}}, rows)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"north.csv":            "id,value\n1,10\n",
+		"regions/south.tsv":    "id\tvalue\n2\t20\n",
+		"README.md":            "not a table",
+		"__MACOSX/._north.csv": "metadata",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	datasets, err = svc.ImportFile(context.Background(), user.ID, "bundle.zip", bytes.NewReader(archive.Bytes()), services.ImportOptions{})
+	require.NoError(t, err)
+	require.Len(t, datasets, 2)
+	names := []string{datasets[0].Name, datasets[1].Name}
+	assert.ElementsMatch(t, []string{"bundle.zip - north.csv", "bundle.zip - regions/south.tsv"}, names)
+
+	// Compressed files may not expand past the limit
+	_, err = svc.ImportFile(context.Background(), user.ID, "bundle.zip", bytes.NewReader(archive.Bytes()), services.ImportOptions{
+		MaxUncompressedSize: 10,
+	})
+	assert.ErrorIs(t, err, services.ErrArchiveTooLarge)
+
+	var bomb bytes.Buffer
+	gw = gzip.NewWriter(&bomb)
+	_, err = gw.Write(append([]byte("id\n"), bytes.Repeat([]byte("1\n"), 1<<20)...))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	_, err = svc.ImportFile(context.Background(), user.ID, "bomb.csv.gz", bytes.NewReader(bomb.Bytes()), services.ImportOptions{
+		MaxUncompressedSize: 1 << 16,
+	})
+	assert.ErrorIs(t, err, services.ErrArchiveTooLarge)
+}
+
+func TestUploadSQLite(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "tool.db")
+	lite, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		"CREATE TABLE runs (id INTEGER PRIMARY KEY, label VARCHAR(20), score REAL, passed BOOLEAN, ran_on DATE, notes)",
+		"INSERT INTO runs VALUES (1, 'alpha', 1.5, 1, '2024-01-02', 'ok'), (2, 'beta', NULL, 0, '2024-02-03', 7)",
+		"CREATE VIEW passed_runs AS SELECT label, score * 2 AS doubled FROM runs WHERE passed",
+	} {
+		_, err := lite.Exec(stmt)
+		require.NoError(t, err)
+	}
+	require.NoError(t, lite.Close())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tables, err := svc.ListSQLiteTables(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, tables, 2)
+	assert.Equal(t, "passed_runs", tables[0].Name)
+	assert.Equal(t, "view", tables[0].Type)
+	assert.Equal(t, "runs", tables[1].Name)
+
+	// A database with several tables needs one to be chosen
+	_, err = svc.ImportFile(ctx, user.ID, "tool.db", bytes.NewReader(content), services.ImportOptions{})
+	assert.ErrorIs(t, err, services.ErrInvalidSQLiteSource)
+
+	datasets, err := svc.ImportFile(ctx, user.ID, "tool.db", bytes.NewReader(content), services.ImportOptions{
+		SQLite: services.SQLiteOptions{Table: "runs"},
+	})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	assert.Equal(t, "tool.db - runs", datasets[0].Name)
+
+	fields, err := svc.GetFieldsForDataset(ctx, datasets[0].ID)
+	require.NoError(t, err)
+	types := make(map[string]string)
+	for _, f := range fields {
+		types[f.Name] = f.DataType
+	}
+	assert.Equal(t, map[string]string{
+		"id": "integer", "label": "text", "score": "float", "passed": "boolean", "ran_on": "datetime", "notes": "text",
+	}, types)
+
+	headers, rows, err := svc.GetDatasetRows(ctx, datasets[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "label", "score", "passed", "ran_on", "notes"}, headers)
+	assert.ElementsMatch(t, [][]string{
+		{"1", "alpha", "1.5", "1", "2024-01-02", "ok"},
+		{"2", "beta", "", "0", "2024-02-03", "7"},
+	}, rows)
+
+	datasets, err = svc.ImportFile(ctx, user.ID, "tool.db", bytes.NewReader(content), services.ImportOptions{
+		SQLite: services.SQLiteOptions{Query: "SELECT label, score FROM runs WHERE score IS NOT NULL"},
+	})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	_, rows, err = svc.GetDatasetRows(ctx, datasets[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"alpha", "1.5"}}, rows)
+
+	_, err = svc.ImportFile(ctx, user.ID, "tool.db", bytes.NewReader(content), services.ImportOptions{
+		SQLite: services.SQLiteOptions{Query: "DELETE FROM runs"},
+	})
+	assert.ErrorIs(t, err, services.ErrInvalidSQLiteSource)
+}
+
+func TestResumableUpload(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	svc.UploadDir = t.TempDir()
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	content := []byte("name,age\nAlice,30\nBob,25\nCarol,41\n")
+	sum := func(b []byte) string {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+
+	session, err := svc.CreateUploadSession(ctx, user.ID, "people.csv", int64(len(content)), 10, sum(content))
+	require.NoError(t, err)
+	chunk := func(i int) []byte {
+		return content[i*10 : min((i+1)*10, len(content))]
+	}
+
+	// Chunks may arrive in any order, and the offset covers only the
+	// contiguous prefix
+	for _, i := range []int{0, 2} {
+		_, err := svc.PutUploadChunk(ctx, session.ID, user.ID, int64(i), bytes.NewReader(chunk(i)), sum(chunk(i)))
+		require.NoError(t, err)
+	}
+	status, err := svc.GetUploadSession(ctx, session.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), status.Chunks)
+	assert.Equal(t, []int64{0, 2}, status.Received)
+	assert.Equal(t, []int64{1, 3}, status.Missing)
+	assert.Equal(t, int64(10), status.Offset)
+
+	_, err = svc.FinalizeUploadSession(ctx, session.ID, user.ID, services.ImportOptions{}, false)
+	assert.ErrorIs(t, err, services.ErrUploadIncomplete)
+
+	// Corrupt or wrongly sized chunks are refused
+	_, err = svc.PutUploadChunk(ctx, session.ID, user.ID, 1, bytes.NewReader([]byte("xxxxxxxxxx")), sum(chunk(1)))
+	assert.ErrorIs(t, err, services.ErrChecksumMismatch)
+	_, err = svc.PutUploadChunk(ctx, session.ID, user.ID, 1, bytes.NewReader(chunk(1)[:5]), sum(chunk(1)[:5]))
+	assert.ErrorIs(t, err, services.ErrInvalidChunk)
+	_, err = svc.PutUploadChunk(ctx, session.ID, user.ID, 4, bytes.NewReader(chunk(1)), sum(chunk(1)))
+	assert.ErrorIs(t, err, services.ErrInvalidChunk)
+
+	for _, i := range []int{1, 3} {
+		_, err := svc.PutUploadChunk(ctx, session.ID, user.ID, int64(i), bytes.NewReader(chunk(i)), sum(chunk(i)))
+		require.NoError(t, err)
+	}
+
+	other := testutils.CreateTestUser(t, repo, fmt.Sprintf("other_%d@example.com", time.Now().UnixNano()))
+	_, err = svc.GetUploadSession(ctx, session.ID, other.ID)
+	assert.ErrorIs(t, err, services.ErrUploadSessionNotFound)
+
+	job, err := svc.FinalizeUploadSession(ctx, session.ID, user.ID, services.ImportOptions{}, false)
+	require.NoError(t, err)
+	_, err = svc.FinalizeUploadSession(ctx, session.ID, user.ID, services.ImportOptions{}, false)
+	assert.ErrorIs(t, err, services.ErrUploadSessionFinalized)
+
+	var jobStatus services.UploadJobStatus
+	require.Eventually(t, func() bool {
+		jobStatus, err = svc.GetUploadJob(ctx, job.ID, user.ID)
+		require.NoError(t, err)
+		return jobStatus.Job.Phase == services.JobPhaseCompleted || jobStatus.Job.Phase == services.JobPhaseFailed
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, services.JobPhaseCompleted, jobStatus.Job.Phase)
+	assert.Equal(t, int64(3), jobStatus.Job.RowsProcessed)
+	require.Len(t, jobStatus.DatasetIDs, 1)
+
+	status, err = svc.GetUploadSession(ctx, session.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.NullUUID{UUID: job.ID, Valid: true}, status.Session.UploadJobID)
+
+	// Sending the same file again is refused with the dataset it created,
+	// and the session can then be finalized with force
+	again, err := svc.CreateUploadSession(ctx, user.ID, "copy.csv", int64(len(content)), int64(len(content)), "")
+	require.NoError(t, err)
+	_, err = svc.PutUploadChunk(ctx, again.ID, user.ID, 0, bytes.NewReader(content), sum(content))
+	require.NoError(t, err)
+	_, err = svc.FinalizeUploadSession(ctx, again.ID, user.ID, services.ImportOptions{}, false)
+	var duplicate *services.DuplicateUploadError
+	require.ErrorAs(t, err, &duplicate)
+	assert.ErrorIs(t, err, services.ErrDuplicateUpload)
+	require.Len(t, duplicate.Datasets, 1)
+	assert.Equal(t, jobStatus.DatasetIDs[0], duplicate.Datasets[0].ID)
+	_, err = svc.FinalizeUploadSession(ctx, again.ID, user.ID, services.ImportOptions{}, true)
+	require.NoError(t, err)
+
+	// A file not matching the session checksum is not ingested
+	bad, err := svc.CreateUploadSession(ctx, user.ID, "people.csv", int64(len(content)), int64(len(content)), sum([]byte("other")))
+	require.NoError(t, err)
+	_, err = svc.PutUploadChunk(ctx, bad.ID, user.ID, 0, bytes.NewReader(content), sum(content))
+	require.NoError(t, err)
+	_, err = svc.FinalizeUploadSession(ctx, bad.ID, user.ID, services.ImportOptions{}, false)
+	assert.ErrorIs(t, err, services.ErrChecksumMismatch)
+
+	require.NoError(t, svc.CancelUploadSession(ctx, bad.ID, user.ID))
+	_, err = svc.GetUploadSession(ctx, bad.ID, user.ID)
+	assert.ErrorIs(t, err, services.ErrUploadSessionNotFound)
+}
+
+func TestCopyIngest(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+
+	content := "name,age,city\nAlice,30,Paris\nBob,,Oslo\nCarol,41,\n"
+	var results [][][]string
+	for _, copyIngest := range []bool{false, true} {
+		svc.CopyIngest = copyIngest
+		datasets, err := svc.ImportFile(context.Background(), user.ID, "people.csv", bytes.NewReader([]byte(content)), services.ImportOptions{})
+		require.NoError(t, err)
+		require.Len(t, datasets, 1)
+
+		_, rows, err := svc.GetDatasetRows(context.Background(), datasets[0].ID, user.ID)
+		require.NoError(t, err)
+		assert.Len(t, rows, 3)
+		results = append(results, rows)
+	}
+	assert.ElementsMatch(t, results[0], results[1])
+}
+
+// benchmarkRows is the length of the synthetic CSV imported by
+// BenchmarkImportCSV.
+const benchmarkRows = 1_000_000
+
+// BenchmarkImportCSV compares ingesting with INSERT statements and with COPY,
+// reporting rows/s for each. Run it with
+// go test ./internal/services -run '^$' -bench ImportCSV -benchtime 1x
+func BenchmarkImportCSV(b *testing.B) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+	user := testutils.CreateTestUser(b, repo, email)
+
+	var buf bytes.Buffer
+	buf.WriteString("id,name,score,active,joined\n")
+	for i := 0; i < benchmarkRows; i++ {
+		fmt.Fprintf(&buf, "%d,user %d,%.2f,%t,2024-01-%02d\n", i, i, float64(i%1000)/7, i%2 == 0, i%28+1)
+	}
+	content := buf.Bytes()
+
+	for _, bc := range []struct {
+		name string
+		copy bool
+	}{
+		{"insert", false},
+		{"copy", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			svc := services.NewDatasetService(repo)
+			svc.CopyIngest = bc.copy
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				datasets, err := svc.ImportFile(context.Background(), user.ID, "synthetic.csv", bytes.NewReader(content), services.ImportOptions{})
+				require.NoError(b, err)
+
+				b.StopTimer()
+				for _, d := range datasets {
+					require.NoError(b, svc.DeleteDataset(context.Background(), d.ID, user.ID))
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(benchmarkRows*b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+func TestDataSourceImport(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	// Without a key data sources are disabled.
+	_, err := svc.ListDataSources(ctx, user.ID)
+	assert.ErrorIs(t, err, services.ErrDataSourcesDisabled)
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	svc.Secrets, err = auth.NewSecretBox(base64.StdEncoding.EncodeToString(key))
+	require.NoError(t, err)
+
+	// The test database itself stands in for the external one.
+	_, err = repo.Exec(`DROP TABLE IF EXISTS source_people;
+		CREATE TABLE source_people (name TEXT, age INTEGER, score NUMERIC, active BOOLEAN, joined DATE);
+		INSERT INTO source_people VALUES ('Alice', 30, 1.5, true, '2024-01-02'), ('Bob', NULL, 2.25, false, '2024-03-04')`)
+	require.NoError(t, err)
+	defer repo.Exec("DROP TABLE IF EXISTS source_people")
+
+	port, err := strconv.Atoi(os.Getenv("DB_PORT"))
+	require.NoError(t, err)
+	conn := services.PostgresConnection{
+		Host:     os.Getenv("DB_HOST"),
+		Port:     port,
+		Database: os.Getenv("DB_NAME"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+		SSLMode:  "disable",
+	}
+	source, err := svc.CreateDataSource(ctx, user.ID, "Local", conn)
+	require.NoError(t, err)
+
+	sources, err := svc.ListDataSources(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, source.ID, sources[0].ID)
+
+	dataset, err := svc.ImportFromDataSource(ctx, user.ID, source.ID, services.SourceQuery{
+		Query: "SELECT name, age, score, active, joined FROM source_people ORDER BY name;",
+		Name:  "People",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "People", dataset.Name)
+
+	fields, err := svc.GetFieldsForDataset(ctx, dataset.ID)
+	require.NoError(t, err)
+	types := map[string]string{}
+	for _, f := range fields {
+		types[f.Name] = f.DataType
+	}
+	assert.Equal(t, map[string]string{
+		"name":   "text",
+		"age":    "integer",
+		"score":  "float",
+		"active": "boolean",
+		"joined": "datetime",
+	}, types)
+
+	_, rows, err := svc.GetDatasetRows(ctx, dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, rows, 2)
+
+	// Refreshing with no query reruns the saved one.
+	_, err = repo.Exec("INSERT INTO source_people VALUES ('Carol', 41, 3, true, '2024-05-06')")
+	require.NoError(t, err)
+	refreshed, err := svc.ImportFromDataSource(ctx, user.ID, source.ID, services.SourceQuery{
+		DatasetID: uuid.NullUUID{UUID: dataset.ID, Valid: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, dataset.ID, refreshed.ID)
+	_, rows, err = svc.GetDatasetRows(ctx, dataset.ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	_, err = svc.ImportFromDataSource(ctx, user.ID, source.ID, services.SourceQuery{
+		Query:   "SELECT * FROM source_people",
+		MaxRows: 2,
+	})
+	assert.ErrorIs(t, err, services.ErrSourceRowLimit)
+
+	for _, query := range []string{
+		"DELETE FROM source_people",
+		"SELECT 1; DROP TABLE source_people",
+		"SELECT 1) AS s; DROP TABLE source_people; SELECT (1",
+	} {
+		_, err = svc.ImportFromDataSource(ctx, user.ID, source.ID, services.SourceQuery{Query: query})
+		assert.Error(t, err, query)
+	}
+	var count int
+	require.NoError(t, repo.DB.QueryRow("SELECT COUNT(*) FROM source_people").Scan(&count))
+	assert.Equal(t, 3, count)
+
+	other := testutils.CreateTestUser(t, repo, "other@example.com")
+	_, err = svc.ImportFromDataSource(ctx, other.ID, source.ID, services.SourceQuery{Query: "SELECT 1"})
+	assert.ErrorIs(t, err, services.ErrDataSourceNotFound)
+	assert.ErrorIs(t, svc.DeleteDataSource(ctx, other.ID, source.ID), services.ErrDataSourceNotFound)
+
+	require.NoError(t, svc.DeleteDataSource(ctx, user.ID, source.ID))
+}
+
+func TestDuplicateUploads(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	file := bytes.NewReader([]byte("name,age\nAlice,30\nBob,25\n"))
+	hash, err := services.HashUpload(file)
+	require.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	duplicates, err := svc.FindDuplicateUploads(ctx, user.ID, hash)
+	require.NoError(t, err)
+	assert.Empty(t, duplicates)
+
+	// The file is rewound, so it can still be imported
+	_, datasets, err := svc.ImportUpload(ctx, user.ID, "people.csv", file, services.ImportOptions{ContentHash: hash})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	assert.Equal(t, hash, datasets[0].ContentHash.String)
+	_, rows, err := svc.GetDatasetRows(ctx, datasets[0].ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, rows, 2)
+
+	duplicates, err = svc.FindDuplicateUploads(ctx, user.ID, hash)
+	require.NoError(t, err)
+	require.Len(t, duplicates, 1)
+	assert.Equal(t, datasets[0].ID, duplicates[0].ID)
+
+	other, err := services.HashUpload(bytes.NewReader([]byte("name,age\nAlice,31\nBob,25\n")))
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+	duplicates, err = svc.FindDuplicateUploads(ctx, user.ID, other)
+	require.NoError(t, err)
+	assert.Empty(t, duplicates)
+
+	// Other users' uploads are not duplicates
+	otherUser := testutils.CreateTestUser(t, repo, "other@example.com")
+	duplicates, err = svc.FindDuplicateUploads(ctx, otherUser.ID, hash)
+	require.NoError(t, err)
+	assert.Empty(t, duplicates)
+}
+
+func TestValueStorageLayouts(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	svc.Cache = nil // read through each layout rather than the cache
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	content := "name,score,note\nAlice,1.5,x\nBob,,y\nCarol,-2e3,z\nDan,7,\n"
+	imported := map[string]uuid.UUID{}
+	for _, storage := range []string{services.StorageTyped, services.StorageText} {
+		svc.Storage = storage
+		datasets, err := svc.ImportFile(ctx, user.ID, storage+".csv", bytes.NewReader([]byte(content)), services.ImportOptions{})
+		require.NoError(t, err)
+		require.Len(t, datasets, 1)
+		imported[storage] = datasets[0].ID
+	}
+
+	// Only the typed layout stores numbers
+	var typedNumbers, textNumbers int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(num_value) FROM record_values v
+		JOIN dataset_records r ON r.id = v.record_id WHERE r.dataset_id = $1`, imported[services.StorageTyped]).Scan(&typedNumbers))
+	require.NoError(t, db.QueryRow(`SELECT COUNT(num_value) FROM record_values v
+		JOIN dataset_records r ON r.id = v.record_id WHERE r.dataset_id = $1`, imported[services.StorageText]).Scan(&textNumbers))
+	assert.Equal(t, 3, typedNumbers)
+	assert.Equal(t, 0, textNumbers)
+
+	// Either layout reads datasets written by the other
+	for _, storage := range []string{services.StorageTyped, services.StorageText} {
+		svc.Storage = storage
+		for _, id := range imported {
+			header, rows, err := svc.GetDatasetRows(ctx, id, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"name", "score", "note"}, header)
+			assert.ElementsMatch(t, [][]string{
+				{"Alice", "1.5", "x"},
+				{"Bob", "", "y"},
+				{"Carol", "-2e3", "z"},
+				{"Dan", "7", ""},
+			}, rows)
+
+			values, err := svc.GetNumericColumnValues(ctx, id, user.ID, "score")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []float64{1.5, -2000, 7}, values)
+
+			_, err = svc.GetNumericColumnValues(ctx, id, user.ID, "name")
+			assert.Error(t, err)
+			_, err = svc.GetNumericColumnValues(ctx, id, user.ID, "missing")
+			assert.Error(t, err)
+		}
+	}
+}
+
+// BenchmarkNumericColumn compares reading a numeric column of a
+// benchmarkRows-row dataset through each storage layout. Run it with
+// go test ./internal/services -run '^$' -bench NumericColumn -benchtime 5x
+func BenchmarkNumericColumn(b *testing.B) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+	user := testutils.CreateTestUser(b, repo, email)
+
+	var buf bytes.Buffer
+	buf.WriteString("id,name,score,active,joined\n")
+	for i := 0; i < benchmarkRows; i++ {
+		fmt.Fprintf(&buf, "%d,user %d,%.2f,%t,2024-01-%02d\n", i, i, float64(i%1000)/7, i%2 == 0, i%28+1)
+	}
+
+	svc := services.NewDatasetService(repo)
+	svc.Cache = nil // read through each layout rather than the cache
+	datasets, err := svc.ImportFile(context.Background(), user.ID, "synthetic.csv", &buf, services.ImportOptions{})
+	require.NoError(b, err)
+	datasetID := datasets[0].ID
+
+	for _, storage := range []string{services.StorageText, services.StorageTyped} {
+		b.Run(storage, func(b *testing.B) {
+			svc.Storage = storage
+			for i := 0; i < b.N; i++ {
+				values, err := svc.GetNumericColumnValues(context.Background(), datasetID, user.ID, "score")
+				require.NoError(b, err)
+				require.Len(b, values, benchmarkRows)
+			}
+			b.ReportMetric(float64(benchmarkRows*b.N)/b.Elapsed().Seconds(), "values/s")
+		})
+	}
+}
+
+func TestRowIndex(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	svc.Cache = nil // read through each layout rather than the cache
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	// Enough rows that many share a timestamp, with a malformed one that
+	// gets no index
+	var buf bytes.Buffer
+	buf.WriteString("id,score\n")
+	for i := 0; i < 500; i++ {
+		if i == 3 {
+			buf.WriteString("broken\n")
+		}
+		if i%10 == 0 {
+			fmt.Fprintf(&buf, "%d,\n", i)
+			continue
+		}
+		fmt.Fprintf(&buf, "%d,%d\n", i, i)
+	}
+	datasets, err := svc.ImportFile(ctx, user.ID, "indexed.csv", &buf, services.ImportOptions{})
+	require.NoError(t, err)
+	datasetID := datasets[0].ID
+
+	records, err := repo.Queries.GetDatasetRecords(ctx, datasetID)
+	require.NoError(t, err)
+	require.Len(t, records, 500)
+	for i, r := range records {
+		assert.Equal(t, int64(i), r.RowIndex)
+	}
+
+	for _, storage := range []string{services.StorageTyped, services.StorageText} {
+		svc.Storage = storage
+		_, rows, err := svc.GetDatasetRows(ctx, datasetID, user.ID)
+		require.NoError(t, err)
+		for i, row := range rows {
+			assert.Equal(t, strconv.Itoa(i), row[0])
+		}
+
+		// Empty cells are skipped, but their rows keep their indexes
+		values, rowIndexes, err := svc.GetNumericColumn(ctx, datasetID, user.ID, "score")
+		require.NoError(t, err)
+		require.Len(t, values, 450)
+		for i, v := range values {
+			assert.Equal(t, v, float64(rowIndexes[i]))
+		}
+	}
+
+	// Appended rows continue the numbering
+	result, err := svc.AppendToDataset(ctx, user.ID, datasetID, bytes.NewReader([]byte("id,score\n500,500\n501,501\n")), services.AppendOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.RowsAppended)
+	records, err = repo.Queries.GetDatasetRecords(ctx, datasetID)
+	require.NoError(t, err)
+	require.Len(t, records, 502)
+	assert.Equal(t, int64(501), records[501].RowIndex)
+}
+
+func TestRowPages(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	buf.WriteString("id,name,score\n")
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&buf, "%d,row %d,%d\n", i, i, i*2)
+	}
+	datasets, err := svc.ImportFile(ctx, user.ID, "paged.csv", &buf, services.ImportOptions{})
+	require.NoError(t, err)
+	datasetID := datasets[0].ID
+
+	// Walking the pages visits every row once, in order
+	query := services.RowQuery{Columns: []string{"score", "id"}}
+	var seen int
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10)
+		page, err := svc.ReadRowPage(ctx, datasetID, query, 300)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"score", "id"}, page.Columns)
+		for _, row := range page.Rows {
+			assert.Equal(t, int64(seen), row.RowIndex)
+			assert.Equal(t, []string{strconv.Itoa(seen * 2), strconv.Itoa(seen)}, row.Values)
+			seen++
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	assert.Equal(t, 2500, seen)
+
+	// A page that ends exactly on the last row has no next cursor
+	page, err := svc.ReadRowPage(ctx, datasetID, services.RowQuery{}, 2500)
+	require.NoError(t, err)
+	assert.Len(t, page.Rows, 2500)
+	assert.Empty(t, page.NextCursor)
+
+	// The cursor streams across several batches
+	cursor, err := svc.OpenRowCursor(ctx, datasetID, services.RowQuery{Columns: []string{"name"}})
+	require.NoError(t, err)
+	var streamed int
+	for {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []string{fmt.Sprintf("row %d", streamed)}, row.Values)
+		streamed++
+	}
+	assert.Equal(t, 2500, streamed)
+
+	_, err = svc.ReadRowPage(ctx, datasetID, services.RowQuery{Columns: []string{"missing"}}, 10)
+	assert.ErrorIs(t, err, services.ErrUnknownColumn)
+	_, err = svc.ReadRowPage(ctx, datasetID, services.RowQuery{Cursor: "not a cursor"}, 10)
+	assert.ErrorIs(t, err, services.ErrInvalidCursor)
+}
+
+func TestDatasetCache(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	svc.Cache = services.NewDatasetCache(1 << 20)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	datasets, err := svc.ImportFile(ctx, user.ID, "cached.csv", bytes.NewReader([]byte("name,score\na,1\nb,2\nc,3\n")), services.ImportOptions{})
+	require.NoError(t, err)
+	datasetID := datasets[0].ID
+
+	_, rows, err := svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "1"}, {"b", "2"}, {"c", "3"}}, rows)
+	_, _, err = svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+
+	values, err := svc.GetNumericColumnValues(ctx, datasetID, user.ID, "score")
+	require.NoError(t, err)
+	// Sorting the result must not reorder the cached column
+	sort.Sort(sort.Reverse(sort.Float64Slice(values)))
+	values, err = svc.GetNumericColumnValues(ctx, datasetID, user.ID, "score")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1, 2, 3}, values)
+
+	stats := svc.Cache.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+
+	// Writes drop the cached dataset
+	fields, err := repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	require.NoError(t, err)
+	scoreID := fields[1].ID
+	err = svc.UpdateDatasetRows(ctx, database.UpdateDatasetRowsParams{
+		DatasetID: datasetID,
+		UpdatedAt: time.Now(),
+		Value:     sql.NullString{String: "9", Valid: true},
+		FieldID:   scoreID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, svc.Cache.Stats().Entries)
+
+	values, err = svc.GetNumericColumnValues(ctx, datasetID, user.ID, "score")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{9, 9, 9}, values)
+
+	// Overwriting a whole column is recorded as a version
+	versions, err := svc.ListDatasetVersions(ctx, datasetID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "set column score", versions[1].Operation)
+	assert.False(t, versions[1].AuthorID.Valid)
+
+	_, err = svc.AppendToDataset(ctx, user.ID, datasetID, bytes.NewReader([]byte("name,score\nd,4\n")), services.AppendOptions{})
+	require.NoError(t, err)
+	_, rows, err = svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, rows, 4)
+
+	// Datasets larger than the budget are evicted least recently used first
+	svc.Cache = services.NewDatasetCache(16 << 10)
+	var buf bytes.Buffer
+	buf.WriteString("id,payload\n")
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&buf, "%d,%s\n", i, strings.Repeat("x", 100))
+	}
+	content := buf.Bytes()
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		datasets, err := svc.ImportFile(ctx, user.ID, fmt.Sprintf("big%d.csv", i), bytes.NewReader(content), services.ImportOptions{})
+		require.NoError(t, err)
+		ids = append(ids, datasets[0].ID)
+		_, _, err = svc.GetDatasetRows(ctx, datasets[0].ID, user.ID)
+		require.NoError(t, err)
+	}
+	stats = svc.Cache.Stats()
+	assert.Positive(t, stats.Evictions)
+	assert.LessOrEqual(t, stats.Bytes, stats.MaxBytes)
+
+	_, _, err = svc.GetDatasetRows(ctx, ids[2], user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stats.Hits+1, svc.Cache.Stats().Hits)
+}
+
+func TestSQLAggregatesMatchGo(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	svc.Cache = nil
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	buf.WriteString("region,amount,mixed\n")
+	for i := 0; i < 1000; i++ {
+		region := []string{"north", "south", "east", ""}[i%4]
+		mixed := strconv.Itoa(i)
+		if i%7 == 0 {
+			mixed = "n/a"
+		}
+		fmt.Fprintf(&buf, "%s,%.3f,%s\n", region, float64(i%97)*1.25-30, mixed)
+	}
+	datasets, err := svc.ImportFile(ctx, user.ID, "aggregates.csv", &buf, services.ImportOptions{})
+	require.NoError(t, err)
+	datasetID := datasets[0].ID
+
+	run := func(sqlAggregates bool, fn func() (any, error)) (any, error) {
+		svc.SQLAggregates = sqlAggregates
+		defer func() { svc.SQLAggregates = true }()
+		return fn()
+	}
+
+	stats := []string{
+		services.StatSum, services.StatMean, services.StatMedian, services.StatMin,
+		services.StatMax, services.StatRange, services.StatVariance, services.StatStdDev,
+	}
+	for _, stat := range stats {
+		pushed, err := svc.ColumnStat(ctx, datasetID, user.ID, "amount", stat)
+		require.NoError(t, err, stat)
+		inGo, err := run(false, func() (any, error) {
+			return svc.ColumnStat(ctx, datasetID, user.ID, "amount", stat)
+		})
+		require.NoError(t, err, stat)
+		assert.InDelta(t, inGo.(float64), pushed, 1e-6, stat)
+	}
+
+	// Columns that are not cleanly numeric fall back to Go and fail the same way
+	_, err = svc.ColumnStat(ctx, datasetID, user.ID, "mixed", services.StatMean)
+	assert.ErrorContains(t, err, "cannot parse 'n/a'")
+	_, err = svc.ColumnStat(ctx, datasetID, user.ID, "missing", services.StatMean)
+	assert.ErrorContains(t, err, "not found")
+
+	for _, stat := range []string{services.StatSum, services.StatMean, services.StatMedian, services.StatMin, services.StatMax, services.StatStdDev} {
+		for _, column := range []string{"amount", "mixed"} {
+			pushed, err := svc.GroupedStat(ctx, datasetID, user.ID, "region", column, stat)
+			require.NoError(t, err, stat)
+			inGo, err := run(false, func() (any, error) {
+				return svc.GroupedStat(ctx, datasetID, user.ID, "region", column, stat)
+			})
+			require.NoError(t, err, stat)
+			expected := inGo.(map[string]float64)
+			require.Len(t, pushed, len(expected), stat)
+			for group, value := range expected {
+				assert.InDelta(t, value, pushed[group], 1e-6, "%s of %s in %q", stat, column, group)
+			}
+		}
+	}
+
+	counts, err := svc.GroupedCount(ctx, datasetID, user.ID, "region")
+	require.NoError(t, err)
+	goCounts, err := run(false, func() (any, error) {
+		return svc.GroupedCount(ctx, datasetID, user.ID, "region")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, goCounts, counts)
+	assert.Equal(t, 250, counts[""])
+
+	count, err := svc.CountRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, count)
+}
+
+func TestRowEditing(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	csv := "name,age,city\nAlice,30,Oslo\nBob,41,Bergen\nCarol,25,Tromso\n"
+	datasets, err := svc.ImportFile(ctx, user.ID, "people.csv", strings.NewReader(csv), services.ImportOptions{})
+	require.NoError(t, err)
+	datasetID := datasets[0].ID
+
+	before, err := repo.Queries.GetRecordsByDatasetID(ctx, datasetID)
+	require.NoError(t, err)
+
+	// A cell edit changes one value and touches only its record
+	columns, row, err := svc.UpdateCell(ctx, datasetID, user.ID, 1, "age", " 42 ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name", "age", "city"}, columns)
+	assert.Equal(t, services.DatasetRow{RowIndex: 1, Values: []string{"Bob", "42", "Bergen"}}, row)
+
+	after, err := repo.Queries.GetRecordsByDatasetID(ctx, datasetID)
+	require.NoError(t, err)
+	assert.Equal(t, before[0].UpdatedAt, after[0].UpdatedAt)
+	assert.True(t, after[1].UpdatedAt.After(before[1].UpdatedAt))
+	assert.Equal(t, before[2].UpdatedAt, after[2].UpdatedAt)
+
+	// Edited numbers are stored typed for analytics
+	mean, err := svc.ColumnStat(ctx, datasetID, user.ID, "age", services.StatMean)
+	require.NoError(t, err)
+	assert.InDelta(t, (30.0+42+25)/3, mean, 1e-9)
+
+	// Values must match the column's type
+	_, _, err = svc.UpdateCell(ctx, datasetID, user.ID, 0, "age", "thirty")
+	assert.ErrorIs(t, err, services.ErrInvalidValue)
+	_, _, err = svc.UpdateRow(ctx, datasetID, user.ID, 0, map[string]string{"city": "Paris", "country": "FR"})
+	assert.ErrorIs(t, err, services.ErrUnknownColumn)
+	_, _, err = svc.UpdateCell(ctx, datasetID, user.ID, 99, "age", "1")
+	assert.ErrorIs(t, err, services.ErrRowNotFound)
+
+	page, err := svc.ReadRowPage(ctx, datasetID, services.RowQuery{Columns: []string{"city"}}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Oslo"}, page.Rows[0].Values, "a failed patch changes nothing")
+
+	// An empty value clears a cell, and null tokens fit any column and are
+	// stored as NULL like uploaded ones, so numeric analytics skip them
+	_, row, err = svc.UpdateRow(ctx, datasetID, user.ID, 0, map[string]string{"city": "", "age": "NA"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alice", "", ""}, row.Values)
+	mean, err = svc.ColumnStat(ctx, datasetID, user.ID, "age", services.StatMean)
+	require.NoError(t, err)
+	assert.InDelta(t, (42.0+25)/2, mean, 1e-9)
+
+	// Dates must match the format the schema declared for their column
+	schema := services.Schema{"joined": {Type: services.TypeDatetime, Format: "DD/MM/YYYY"}}
+	dated, err := svc.ImportFile(ctx, user.ID, "joined.csv", strings.NewReader("name,joined\nAlice,05/01/2024\n"), services.ImportOptions{Schema: schema})
+	require.NoError(t, err)
+	_, _, err = svc.UpdateCell(ctx, dated[0].ID, user.ID, 0, "joined", "2024-01-06")
+	assert.ErrorIs(t, err, services.ErrInvalidValue)
+	_, row, err = svc.UpdateCell(ctx, dated[0].ID, user.ID, 0, "joined", "06/01/2024")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alice", "06/01/2024"}, row.Values)
+
+	// Inserted rows go after the last one
+	rowIndexes, err := svc.InsertRows(ctx, datasetID, user.ID, []map[string]string{
+		{"name": "Dana", "age": "29"},
+		{"name": "Erik", "city": "Oslo"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 4}, rowIndexes)
+
+	_, err = svc.InsertRows(ctx, datasetID, user.ID, []map[string]string{{"name": "Frida"}, {"age": "old"}})
+	assert.ErrorIs(t, err, services.ErrInvalidValue)
+
+	// Deleting rows keeps the others' row indexes, and deletes nothing if any row is missing
+	_, err = svc.DeleteRows(ctx, datasetID, user.ID, []int64{2, 50})
+	assert.ErrorIs(t, err, services.ErrRowNotFound)
+	deleted, err := svc.DeleteRows(ctx, datasetID, user.ID, []int64{2, 0, 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	page, err = svc.ReadRowPage(ctx, datasetID, services.RowQuery{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []services.DatasetRow{
+		{RowIndex: 1, Values: []string{"Bob", "42", "Bergen"}},
+		{RowIndex: 3, Values: []string{"Dana", "29", ""}},
+		{RowIndex: 4, Values: []string{"Erik", "", "Oslo"}},
+	}, page.Rows)
+}
+
+func TestDatasetVersions(t *testing.T) {
+	db := setupDB()
+	defer db.Close()
+	repo := database.NewRepository(db)
+	cleanDB(repo)
+	logger.Init()
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+
+	svc := services.NewDatasetService(repo)
+	user := testutils.CreateTestUser(t, repo, email)
+	ctx := context.Background()
+
+	csv := "name,age\nAlice,30\nBob,40\n"
+	datasets, err := svc.ImportFile(ctx, user.ID, "people.csv", strings.NewReader(csv), services.ImportOptions{})
+	require.NoError(t, err)
+	datasetID := datasets[0].ID
+
+	versions, err := svc.ListDatasetVersions(ctx, datasetID)
+	require.NoError(t, err)
+	assert.Empty(t, versions, "an unchanged dataset has no versions")
+
+	// The first change also records the dataset as it was
+	_, _, err = svc.UpdateCell(ctx, datasetID, user.ID, 0, "age", "35")
+	require.NoError(t, err)
+	_, err = svc.RenameColumns(ctx, datasetID, user.ID, []string{"name", "years"})
+	require.NoError(t, err)
+
+	// Versions only store the rows that changed: both rows for version 1
+	// and the edited one, while the rename stores none
+	var versionRows int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM dataset_version_rows WHERE dataset_id = $1", datasetID).Scan(&versionRows))
+	assert.Equal(t, 3, versionRows)
+	_, err = svc.InsertRows(ctx, datasetID, user.ID, []map[string]string{{"name": "Cara"}})
+	require.NoError(t, err)
+	cleaned, err := svc.CleanDataset(ctx, datasetID, user.ID, "fill missing values", func(header []string, rows [][]string) ([][]string, error) {
+		for _, row := range rows {
+			if row[1] == "" {
+				row[1] = "0"
+			}
+		}
+		return rows, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), cleaned.Version)
+	assert.Equal(t, int64(3), cleaned.RowCount)
+
+	versions, err = svc.ListDatasetVersions(ctx, datasetID)
+	require.NoError(t, err)
+	require.Len(t, versions, 5)
+	operations := make([]string, len(versions))
+	for i, v := range versions {
+		operations[i] = v.Operation
+		assert.Equal(t, int32(i+1), v.Version)
+		assert.Equal(t, user.ID, v.AuthorID.UUID)
+	}
+	assert.Equal(t, []string{
+		"initial state",
+		"edit row 0: age",
+		"rename columns age to years",
+		"insert 1 row",
+		"fill missing values",
+	}, operations)
+	assert.Equal(t, []string{"name", "age"}, versions[0].Columns)
+	assert.Equal(t, []string{"name", "years"}, versions[4].Columns)
+
+	// Analytics can read an earlier version, while the dataset stays as it is
+	first := services.WithVersion(ctx, versions[0].ID)
+	mean, err := svc.ColumnStat(first, datasetID, user.ID, "age", services.StatMean)
+	require.NoError(t, err)
+	assert.InDelta(t, 35.0, mean, 1e-9)
+	mean, err = svc.ColumnStat(ctx, datasetID, user.ID, "years", services.StatMean)
+	require.NoError(t, err)
+	assert.InDelta(t, 25.0, mean, 1e-9)
+	_, err = svc.ColumnStat(first, datasetID, user.ID, "years", services.StatMean)
+	assert.Error(t, err)
+
+	_, err = svc.GetDatasetVersion(ctx, datasetID, 99)
+	assert.ErrorIs(t, err, services.ErrVersionNotFound)
+
+	// Rolling back restores the columns and rows, as a new version
+	rollback, err := svc.RollbackDataset(ctx, datasetID, user.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int32(6), rollback.Version)
+	assert.Equal(t, "rollback to version 1", rollback.Operation)
+
+	header, rows, err := svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name", "age"}, header)
+	assert.Equal(t, [][]string{{"Alice", "30"}, {"Bob", "40"}}, rows)
+
+	// Versions after the one restored can still be restored
+	_, err = svc.RollbackDataset(ctx, datasetID, user.ID, 5)
+	require.NoError(t, err)
+	header, rows, err = svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name", "years"}, header)
+	assert.Equal(t, [][]string{{"Alice", "35"}, {"Bob", "40"}, {"Cara", "0"}}, rows)
+
+	// Only the most recent versions are kept, and they can still be read
+	svc.MaxVersions = 3
+	for _, age := range []string{"36", "37"} {
+		_, _, err = svc.UpdateCell(ctx, datasetID, user.ID, 0, "years", age)
+		require.NoError(t, err)
+	}
+	versions, err = svc.ListDatasetVersions(ctx, datasetID)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, int32(7), versions[0].Version)
+	_, err = svc.RollbackDataset(ctx, datasetID, user.ID, 1)
+	assert.ErrorIs(t, err, services.ErrVersionNotFound)
+
+	mean, err = svc.ColumnStat(services.WithVersion(ctx, versions[0].ID), datasetID, user.ID, "years", services.StatMean)
+	require.NoError(t, err)
+	assert.InDelta(t, 25.0, mean, 1e-9)
+	_, err = svc.RollbackDataset(ctx, datasetID, user.ID, 8)
+	require.NoError(t, err)
+	_, rows, err = svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"Alice", "36"}, {"Bob", "40"}, {"Cara", "0"}}, rows)
+
+	// Cleared cells and dropped columns are told apart from unchanged rows
+	_, _, err = svc.UpdateCell(ctx, datasetID, user.ID, 1, "years", "")
+	require.NoError(t, err)
+	fields, err := repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	require.NoError(t, err)
+	require.Len(t, fields, 2)
+	dropped, err := svc.DropColumns(ctx, datasetID, user.ID, []uuid.UUID{fields[1].ID})
+	require.NoError(t, err)
+	_, rows, err = svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"Alice"}, {"Bob"}, {"Cara"}}, rows)
+
+	_, err = svc.RollbackDataset(ctx, datasetID, user.ID, dropped.Version-1)
+	require.NoError(t, err)
+	_, rows, err = svc.GetDatasetRows(ctx, datasetID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"Alice", "36"}, {"Bob", ""}, {"Cara", "0"}}, rows)
+}
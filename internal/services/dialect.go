@@ -0,0 +1,243 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+)
+
+// NoQuote is the DialectOptions.Quote of files whose quote characters are
+// ordinary text.
+const NoQuote rune = -1
+
+// dialectSniffSize is how much of a file is examined to detect its dialect.
+const dialectSniffSize = 64 << 10
+
+// sniffDelimiters are the delimiters tried when none is given.
+var sniffDelimiters = []rune{',', ';', '\t', '|'}
+
+var ErrInvalidDialect = errors.New("invalid CSV dialect")
+
+// Dialect describes how a delimited text file is laid out.
+type Dialect struct {
+	Delimiter rune
+	// Quote encloses fields containing delimiters or line breaks. It is '"',
+	// '\'' or NoQuote.
+	Quote     rune
+	HasHeader bool
+}
+
+// DialectOptions overrides parts of the detected dialect. Zero values are
+// detected.
+type DialectOptions struct {
+	Delimiter rune
+	// ExtraDelimiters are tried alongside , ; tab and | when the delimiter
+	// is detected.
+	ExtraDelimiters []rune
+	// Quote is '"', '\'' or NoQuote.
+	Quote rune
+	// Header says whether the first row holds the column names.
+	Header *bool
+}
+
+func (o DialectOptions) validate() error {
+	for _, d := range append([]rune{o.Delimiter}, o.ExtraDelimiters...) {
+		if d == 0 {
+			continue
+		}
+		if d == '"' || d == '\'' || d == '\r' || d == '\n' || d == utf8.RuneError || !utf8.ValidRune(d) {
+			return fmt.Errorf("%w: %q cannot be a delimiter", ErrInvalidDialect, d)
+		}
+	}
+	switch o.Quote {
+	case 0, '"', '\'', NoQuote:
+	default:
+		return fmt.Errorf("%w: quote must be \" or ' or none", ErrInvalidDialect)
+	}
+	return nil
+}
+
+// sniffDialect detects the dialect of a file from its first bytes. Every
+// candidate delimiter and quote is used to parse the sample and the one
+// giving the most consistent number of columns, more than one, wins. Ties
+// go to the earlier delimiter and to double quotes. truncated reports
+// whether the sample stops part way through the file.
+func sniffDialect(sample []byte, truncated bool, opts DialectOptions) (Dialect, error) {
+	if err := opts.validate(); err != nil {
+		return Dialect{}, err
+	}
+
+	delimiters := sniffDelimiters
+	if opts.Delimiter != 0 {
+		delimiters = []rune{opts.Delimiter}
+	} else if len(opts.ExtraDelimiters) > 0 {
+		delimiters = append(append([]rune{}, sniffDelimiters...), opts.ExtraDelimiters...)
+	}
+	quotes := []rune{'"', '\''}
+	if opts.Quote != 0 {
+		quotes = []rune{opts.Quote}
+	}
+
+	best := Dialect{Delimiter: delimiters[0], Quote: quotes[0]}
+	var bestRecords [][]string
+	bestScore := -1.0
+	for _, d := range delimiters {
+		for _, q := range quotes {
+			dialect := Dialect{Delimiter: d, Quote: q}
+			records := sampleRecords(sample, truncated, dialect)
+			score, width := columnConsistency(records)
+			if width < 2 && len(delimiters) > 1 {
+				continue
+			}
+			if score > bestScore {
+				best, bestRecords, bestScore = dialect, records, score
+			}
+		}
+	}
+	if bestRecords == nil {
+		bestRecords = sampleRecords(sample, truncated, best)
+	}
+
+	if opts.Header != nil {
+		best.HasHeader = *opts.Header
+	} else {
+		best.HasHeader = sniffHeader(bestRecords)
+	}
+	return best, nil
+}
+
+// sampleRecords parses the sample with a dialect. Parsing stops at the first
+// malformed record, and the last record is dropped if the sample was cut
+// short.
+func sampleRecords(sample []byte, truncated bool, dialect Dialect) [][]string {
+	r := csv.NewReader(swapQuotes(strings.NewReader(string(sample)), dialect.Quote))
+	r.Comma = dialect.Delimiter
+	r.FieldsPerRecord = -1
+
+	var records [][]string
+	for {
+		record, err := r.Read()
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	if truncated && len(records) > 1 {
+		records = records[:len(records)-1]
+	}
+
+	swap := quoteSwap(dialect.Quote)
+	if swap != nil {
+		for _, record := range records {
+			for i := range record {
+				record[i] = strings.Map(swap, record[i])
+			}
+		}
+	}
+	return records
+}
+
+// columnConsistency returns the share of records having the most common
+// number of fields, and that number.
+func columnConsistency(records [][]string) (float64, int) {
+	if len(records) == 0 {
+		return 0, 0
+	}
+	counts := make(map[int]int)
+	for _, record := range records {
+		counts[len(record)]++
+	}
+	width, most := 0, 0
+	for w, n := range counts {
+		if n > most || (n == most && w > width) {
+			width, most = w, n
+		}
+	}
+	return float64(most) / float64(len(records)), width
+}
+
+// sniffHeader decides whether the first record names the columns. Each
+// column votes: a typed column whose first value does not fit the type, or a
+// column of fixed-length values whose first value has another length, votes
+// for a header, and the opposite against. Ties keep the header, which was
+// always assumed before detection existed.
+func sniffHeader(records [][]string) bool {
+	if len(records) < 2 {
+		return true
+	}
+	first, rest := records[0], records[1:]
+
+	votes := 0
+	for i, name := range first {
+		name = strings.TrimSpace(name)
+		ti := newTypeInferrer()
+		length, fixed := -1, true
+		for _, record := range rest {
+			if i >= len(record) {
+				continue
+			}
+			val := strings.TrimSpace(record[i])
+			ti.observe(val)
+			if length < 0 {
+				length = utf8.RuneCountInString(val)
+			} else if utf8.RuneCountInString(val) != length {
+				fixed = false
+			}
+		}
+
+		res := ti.result()
+		switch {
+		case res.Type != TypeText && res.Confidence > 0:
+			if !isNullToken(name) && valueMatchesType(name, res.Type, "") {
+				votes--
+			} else {
+				votes++
+			}
+		case fixed && length > 0:
+			if utf8.RuneCountInString(name) == length {
+				votes--
+			} else {
+				votes++
+			}
+		}
+	}
+	return votes >= 0
+}
+
+// swapQuotes applies quoteSwap to a stream.
+func swapQuotes(r io.Reader, quote rune) io.Reader {
+	if swap := quoteSwap(quote); swap != nil {
+		return transform.NewReader(r, runes.Map(swap))
+	}
+	return r
+}
+
+// quoteSwap makes encoding/csv, which only knows double quotes, honour
+// another quote character by exchanging it with the double quote before
+// parsing. Applying the swap again restores the original text. With NoQuote
+// double quotes are exchanged with a private use character so nothing is
+// treated as quoting. It returns nil for double quotes.
+func quoteSwap(quote rune) func(rune) rune {
+	other := quote
+	switch quote {
+	case '"', 0:
+		return nil
+	case NoQuote:
+		other = '\uE000'
+	}
+	return func(r rune) rune {
+		switch r {
+		case '"':
+			return other
+		case other:
+			return '"'
+		}
+		return r
+	}
+}
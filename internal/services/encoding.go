@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Text encodings understood for uploads, as recorded on datasets.
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+	EncodingLatin1      = "iso-8859-1"
+)
+
+var ErrUnknownEncoding = errors.New("unknown encoding")
+
+// encodingSniffSize is how much of a file is examined to detect its
+// encoding.
+const encodingSniffSize = 64 << 10
+
+// NormalizeEncoding maps the usual spellings of a supported encoding to its
+// canonical name. An empty name stays empty, meaning detect.
+func NormalizeEncoding(name string) (string, error) {
+	key := strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+	switch key {
+	case "":
+		return "", nil
+	case "utf8", "utf8bom", "utf8sig":
+		return EncodingUTF8, nil
+	case "utf16", "utf16le":
+		return EncodingUTF16LE, nil
+	case "utf16be":
+		return EncodingUTF16BE, nil
+	case "windows1252", "cp1252":
+		return EncodingWindows1252, nil
+	case "latin1", "iso88591", "l1":
+		return EncodingLatin1, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownEncoding, name)
+}
+
+// decodeText returns a reader producing the text of file as UTF-8 without a
+// byte order mark, along with the encoding it was read as. The encoding is
+// detected when none is given.
+func decodeText(file io.Reader, encoding string) (io.Reader, string, error) {
+	encoding, err := NormalizeEncoding(encoding)
+	if err != nil {
+		return nil, "", err
+	}
+
+	br := bufio.NewReaderSize(file, encodingSniffSize)
+	if encoding == "" {
+		head, err := br.Peek(encodingSniffSize)
+		if err != nil && err != io.EOF {
+			return nil, "", fmt.Errorf("failed to read file: %w", err)
+		}
+		encoding = detectEncoding(head, len(head) == encodingSniffSize)
+	}
+
+	var decoder transform.Transformer
+	switch encoding {
+	case EncodingUTF16LE:
+		decoder = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case EncodingUTF16BE:
+		decoder = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()
+	case EncodingWindows1252:
+		decoder = charmap.Windows1252.NewDecoder()
+	case EncodingLatin1:
+		decoder = charmap.ISO8859_1.NewDecoder()
+	default:
+		// Also replaces invalid sequences, which PostgreSQL would refuse
+		decoder = unicode.UTF8BOM.NewDecoder()
+	}
+	return transform.NewReader(br, decoder), encoding, nil
+}
+
+// detectEncoding guesses the encoding of a file from its first bytes. A
+// byte order mark settles it; otherwise UTF-16 is recognised by the zero
+// bytes of ASCII text, and anything that is not valid UTF-8 is taken to be
+// Windows-1252, the usual encoding of spreadsheet exports. truncated reports
+// whether head stops part way through the file.
+func detectEncoding(head []byte, truncated bool) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	if enc := detectUTF16(head); enc != "" {
+		return enc
+	}
+
+	if truncated {
+		// Ignore a character cut in half by the end of the sample
+		for i := 0; i < utf8.UTFMax-1 && len(head) > 0 && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if utf8.Valid(head) {
+		return EncodingUTF8
+	}
+	return EncodingWindows1252
+}
+
+// detectUTF16 recognises UTF-16 without a byte order mark by the zero high
+// bytes of ASCII characters.
+func detectUTF16(head []byte) string {
+	n := min(len(head), 1024) &^ 1
+	if n < 4 {
+		return ""
+	}
+	var evenZeros, oddZeros int
+	for i := 0; i < n; i += 2 {
+		if head[i] == 0 {
+			evenZeros++
+		}
+		if head[i+1] == 0 {
+			oddZeros++
+		}
+	}
+	pairs := n / 2
+	switch {
+	case oddZeros*10 > pairs*4 && evenZeros*20 < pairs:
+		return EncodingUTF16LE
+	case evenZeros*10 > pairs*4 && oddZeros*20 < pairs:
+		return EncodingUTF16BE
+	}
+	return ""
+}
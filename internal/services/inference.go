@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// minTypeConfidence is the share of a column's non-null values that must
+// parse as a type for the column to be given it. Columns below it for every
+// type are text.
+const minTypeConfidence = 0.95
+
+// maxCounterexamples caps the values kept to show why a type did not fit.
+const maxCounterexamples = 5
+
+// inferenceCandidates are the types a column can be inferred as. When two
+// fit equally well the earlier one wins, so a column of 0s and 1s is boolean
+// rather than integer.
+var inferenceCandidates = []string{TypeBoolean, TypeInteger, TypeFloat, TypeDatetime}
+
+// nullTokens are values treated as missing, compared case-insensitively.
+var nullTokens = map[string]bool{
+	"na": true, "n/a": true, "nan": true, "null": true, "none": true, "nil": true, "-": true, "--": true,
+}
+
+func isNullToken(val string) bool {
+	return val == "" || nullTokens[strings.ToLower(val)]
+}
+
+// storedValue is a cell's value as it is stored: trimmed, and NULL when it
+// is empty or a null token, however the row got into the dataset.
+func storedValue(val string) sql.NullString {
+	val = strings.TrimSpace(val)
+	if isNullToken(val) {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: val, Valid: true}
+}
+
+// TypeInference is the outcome of inferring a column's type. Confidence is
+// the share of non-null values that parse as Type; for text it is the share
+// that no other type accepts. Counterexamples are values that did not parse
+// as Type or, for text, as the closest other type.
+type TypeInference struct {
+	Type            string
+	Confidence      float64
+	Counterexamples []string
+}
+
+// typeInferrer counts, over every value of a column, how many parse as each
+// candidate type.
+type typeInferrer struct {
+	values          int64
+	nulls           int64
+	matches         []int64
+	counterexamples [][]string
+}
+
+func newTypeInferrer() *typeInferrer {
+	return &typeInferrer{
+		matches:         make([]int64, len(inferenceCandidates)),
+		counterexamples: make([][]string, len(inferenceCandidates)),
+	}
+}
+
+func (ti *typeInferrer) observe(val string) {
+	val = strings.TrimSpace(val)
+	if isNullToken(val) {
+		ti.nulls++
+		return
+	}
+	ti.values++
+	for k, t := range inferenceCandidates {
+		if valueMatchesType(val, t, "") {
+			ti.matches[k]++
+		} else if len(ti.counterexamples[k]) < maxCounterexamples && !slices.Contains(ti.counterexamples[k], val) {
+			ti.counterexamples[k] = append(ti.counterexamples[k], val)
+		}
+	}
+}
+
+func (ti *typeInferrer) result() TypeInference {
+	if ti.values == 0 {
+		return TypeInference{Type: TypeText, Counterexamples: []string{}}
+	}
+
+	best, bestShare := -1, 0.0
+	for k := range inferenceCandidates {
+		share := float64(ti.matches[k]) / float64(ti.values)
+		if share > bestShare {
+			best, bestShare = k, share
+		}
+	}
+	if best < 0 {
+		return TypeInference{Type: TypeText, Confidence: 1, Counterexamples: []string{}}
+	}
+
+	counterexamples := append([]string{}, ti.counterexamples[best]...)
+	if bestShare >= minTypeConfidence {
+		return TypeInference{Type: inferenceCandidates[best], Confidence: bestShare, Counterexamples: counterexamples}
+	}
+	return TypeInference{Type: TypeText, Confidence: 1 - bestShare, Counterexamples: counterexamples}
+}
+
+// columnInference infers the types of some of a file's columns from every
+// value stored for them. Columns that are not tracked keep the type they
+// were created with.
+type columnInference struct {
+	columns []*typeInferrer
+}
+
+func newColumnInference(width int) *columnInference {
+	return &columnInference{columns: make([]*typeInferrer, width)}
+}
+
+func (ci *columnInference) track(i int) {
+	ci.columns[i] = newTypeInferrer()
+}
+
+func (ci *columnInference) observe(row []string) {
+	if ci == nil {
+		return
+	}
+	for i, ti := range ci.columns {
+		if ti != nil && i < len(row) {
+			ti.observe(row[i])
+		}
+	}
+}
+
+// storeInferredTypes saves the inferred type of every tracked column on its
+// field. fieldIDs are indexed like the file's columns.
+func (s *DatasetService) storeInferredTypes(ctx context.Context, fieldIDs []uuid.UUID, ci *columnInference) error {
+	for i, ti := range ci.columns {
+		if ti == nil {
+			continue
+		}
+		res := ti.result()
+		err := s.Repo.Queries.UpdateDatasetFieldType(ctx, database.UpdateDatasetFieldTypeParams{
+			ID:                  fieldIDs[i],
+			DataType:            res.Type,
+			TypeConfidence:      sql.NullFloat64{Float64: res.Confidence, Valid: true},
+			TypeCounterexamples: res.Counterexamples,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update field type: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseBoolean accepts true/false, yes/no, their initials and 1/0.
+func parseBoolean(val string) (bool, bool) {
+	switch strings.ToLower(val) {
+	case "true", "t", "yes", "y", "1":
+		return true, true
+	case "false", "f", "no", "n", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// parseInteger accepts whole numbers, with or without thousands separators.
+func parseInteger(val string) (int64, bool) {
+	num, percent, currency, ok := cleanNumber(val)
+	if !ok || percent || currency {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	return n, err == nil
+}
+
+// parseNumber accepts decimal numbers written with thousands separators, a
+// currency symbol or a percent sign. Percentages are returned as fractions.
+func parseNumber(val string) (float64, bool) {
+	num, percent, _, ok := cleanNumber(val)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, false
+	}
+	if percent {
+		f /= 100
+	}
+	return f, true
+}
+
+var currencySymbols = []string{"$", "€", "£", "¥"}
+
+// cleanNumber strips a currency symbol, a trailing percent sign and
+// thousands separators from val, leaving a number strconv can parse.
+func cleanNumber(val string) (num string, percent, currency, ok bool) {
+	s := strings.TrimSpace(val)
+	sign := ""
+	takeSign := func() {
+		if sign == "" && (strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+")) {
+			sign, s = s[:1], strings.TrimSpace(s[1:])
+		}
+	}
+
+	takeSign()
+	for _, sym := range currencySymbols {
+		if strings.HasPrefix(s, sym) {
+			s, currency = strings.TrimSpace(strings.TrimPrefix(s, sym)), true
+			break
+		}
+		if strings.HasSuffix(s, sym) {
+			s, currency = strings.TrimSpace(strings.TrimSuffix(s, sym)), true
+			break
+		}
+	}
+	takeSign()
+	if strings.HasSuffix(s, "%") {
+		s, percent = strings.TrimSpace(strings.TrimSuffix(s, "%")), true
+	}
+	if percent && currency {
+		return "", false, false, false
+	}
+
+	if strings.Contains(s, ",") {
+		whole, frac, hasFrac := strings.Cut(s, ".")
+		groups := strings.Split(whole, ",")
+		if len(groups[0]) == 0 || len(groups[0]) > 3 {
+			return "", false, false, false
+		}
+		for _, g := range groups[1:] {
+			if len(g) != 3 {
+				return "", false, false, false
+			}
+		}
+		s = strings.Join(groups, "")
+		if hasFrac {
+			s += "." + frac
+		}
+	}
+
+	digits := false
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r == '.' || r == 'e' || r == 'E' || r == '+' || r == '-':
+		default:
+			return "", false, false, false
+		}
+	}
+	if !digits {
+		return "", false, false, false
+	}
+	return sign + s, percent, currency, true
+}
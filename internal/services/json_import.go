@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+)
+
+// maxJSONLineSize caps a single NDJSON record.
+const maxJSONLineSize = 16 << 20
+
+// UploadJSON imports a JSON array of records or newline-delimited JSON
+// (one record per line). The columns are the union of keys across every
+// record, nested objects are flattened into dotted column names such as
+// "user.address.city" and arrays are stored as their JSON encoding.
+func (s *DatasetService) UploadJSON(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+) (database.Dataset, error) {
+	return s.importOne(ctx, ImportOptions{}, func(tx *DatasetService, opts ImportOptions) (database.Dataset, error) {
+		return tx.importJSON(ctx, userID, filename, file, opts)
+	})
+}
+
+func (s *DatasetService) importJSON(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) (database.Dataset, error) {
+	text, encoding, err := decodeText(file, opts.Encoding)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+
+	headers, rows, rejected, err := parseJSONRecords(text)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+
+	dataset, err := s.createImportDataset(ctx, userID, filename, "Uploaded dataset file", encoding, opts)
+	if err != nil {
+		logger.Logger.Printf("Error uploading dataset: %v", err)
+		return database.Dataset{}, err
+	}
+
+	ingest := opts.ingestOptions()
+	ingest.rejected = rejected
+	if err := s.ingestRows(ctx, dataset, headers, &sliceRowReader{rows: rows}, ingest); err != nil {
+		return dataset, err
+	}
+
+	return dataset, nil
+}
+
+// parseJSONRecords decodes every record and lays them out as a table. Columns
+// appear in the order they were first seen; keys introduced by the same record
+// are sorted by name since object key order is not preserved. Malformed
+// NDJSON lines are returned as rejections rather than failing the import.
+func parseJSONRecords(r io.Reader) ([]string, [][]string, []RowRejection, error) {
+	br := bufio.NewReader(r)
+	first, err := firstNonSpace(br)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read JSON: %w", err)
+	}
+
+	var records []map[string]string
+	var rejected []RowRejection
+	columnIndex := make(map[string]int)
+	var headers []string
+
+	add := func(raw interface{}) {
+		flat := make(map[string]string)
+		flattenJSON("", raw, flat)
+
+		keys := make([]string, 0, len(flat))
+		for k := range flat {
+			if _, ok := columnIndex[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			columnIndex[k] = len(headers)
+			headers = append(headers, k)
+		}
+		records = append(records, flat)
+	}
+
+	if first == '[' {
+		dec := json.NewDecoder(br)
+		dec.UseNumber()
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+		for dec.More() {
+			var raw interface{}
+			if err := dec.Decode(&raw); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid JSON record %d: %w", len(records)+1, err)
+			}
+			add(raw)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+	} else {
+		// Newline-delimited JSON: one record per line
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(make([]byte, 64*1024), maxJSONLineSize)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			raw, err := decodeJSONValue(line)
+			if err != nil {
+				rejected = append(rejected, RowRejection{
+					RowNumber: int64(lineNum),
+					Raw:       string(line),
+					Reason:    fmt.Sprintf("invalid JSON: %v", err),
+				})
+				continue
+			}
+			add(raw)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read JSON lines: %w", err)
+		}
+	}
+
+	if len(headers) == 0 {
+		return nil, nil, nil, fmt.Errorf("no JSON records found")
+	}
+
+	rows := make([][]string, len(records))
+	for i, rec := range records {
+		row := make([]string, len(headers))
+		for k, v := range rec {
+			row[columnIndex[k]] = v
+		}
+		rows[i] = row
+	}
+
+	return headers, rows, rejected, nil
+}
+
+func decodeJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return raw, nil
+}
+
+// firstNonSpace reports the first significant byte of the stream without
+// consuming it.
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case 0xEF:
+			// Skip a UTF-8 byte order mark
+			if bom, err := br.Peek(2); err == nil && bom[0] == 0xBB && bom[1] == 0xBF {
+				_, _ = br.Discard(2)
+				continue
+			}
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// flattenJSON writes every leaf of v into out. Object keys are joined with
+// dots, arrays are serialised back to JSON and nulls become empty values.
+// A top-level value that is not an object is stored under "value".
+func flattenJSON(prefix string, v interface{}, out map[string]string) {
+	key := prefix
+	if key == "" {
+		key = "value"
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 && prefix != "" {
+			out[key] = ""
+			return
+		}
+		for k, child := range val {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			flattenJSON(name, child, out)
+		}
+	case []interface{}:
+		encoded, _ := json.Marshal(val)
+		out[key] = string(encoded)
+	case nil:
+		out[key] = ""
+	case string:
+		out[key] = val
+	case json.Number:
+		out[key] = val.String()
+	case bool:
+		if val {
+			out[key] = "true"
+		} else {
+			out[key] = "false"
+		}
+	default:
+		out[key] = fmt.Sprint(val)
+	}
+}
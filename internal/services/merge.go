@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateKey is returned when the existing rows of a dataset are not
+// unique on the key columns of a merge.
+var ErrDuplicateKey = errors.New("dataset rows are not unique on the key columns")
+
+// mergeChunkSize bounds the number of record IDs sent in one statement.
+const mergeChunkSize = 1000
+
+// keySeparator joins the values of a composite key. It is the ASCII unit
+// separator, which does not occur in ordinary text.
+const keySeparator = "\x1f"
+
+// mergeRows upserts the rows of the stream into the dataset. Rows whose key
+// matches an existing record overwrite that record's values for the file's
+// columns; other rows are inserted. keyColumns are indexes into the file's
+// columns.
+func (s *DatasetService) mergeRows(
+	ctx context.Context,
+	datasetID uuid.UUID,
+	fieldIDs []uuid.UUID,
+	keyColumns []int,
+	deleteMissing bool,
+	stream *rowStream,
+) (AppendResult, error) {
+	var result AppendResult
+
+	existing, unkeyed, err := s.recordsByKey(ctx, datasetID, keyColumns, fieldIDs)
+	if err != nil {
+		return result, err
+	}
+
+	batch := &valueBatch{store: s.values(), upsert: true, inference: stream.inference, onFlush: stream.report}
+	rowIndex, err := s.Repo.Queries.GetNextRowIndex(ctx, datasetID)
+	if err != nil {
+		return result, fmt.Errorf("failed to get next row index: %w", err)
+	}
+	seen := make(map[string]bool)
+	var updated []uuid.UUID
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		row, pos, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		key, empty := rowKey(row, keyColumns)
+		if empty {
+			pos.Reason = "key column is empty"
+			if err := stream.reject(pos); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if seen[key] {
+			pos.Reason = "duplicate key in file"
+			if err := stream.reject(pos); err != nil {
+				return result, err
+			}
+			continue
+		}
+		seen[key] = true
+
+		recordID, ok := existing[key]
+		if ok {
+			updated = append(updated, recordID)
+			result.RowsUpdated++
+		} else {
+			recordID = uuid.New()
+			err = s.Repo.Queries.CreateDatasetRecord(ctx, database.CreateDatasetRecordParams{
+				ID:        recordID,
+				DatasetID: datasetID,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+				RowIndex:  rowIndex,
+			})
+			if err != nil {
+				return result, fmt.Errorf("failed to insert record for row %d: %w", pos.RowNumber, err)
+			}
+			s.changes.add(rowIndex)
+			rowIndex++
+			result.RowsAppended++
+		}
+		stream.progress.RowsProcessed++
+
+		if err := batch.addRow(ctx, recordID, fieldIDs, row); err != nil {
+			return result, err
+		}
+	}
+
+	if err := batch.flush(ctx); err != nil {
+		return result, fmt.Errorf("final batch insert failed: %w", err)
+	}
+	stream.report()
+
+	if err := s.touchRows(ctx, datasetID, updated); err != nil {
+		return result, err
+	}
+
+	if deleteMissing {
+		missing := unkeyed
+		for key, recordID := range existing {
+			if !seen[key] {
+				missing = append(missing, recordID)
+			}
+		}
+		if err := s.deleteRecords(ctx, datasetID, missing); err != nil {
+			return result, err
+		}
+		result.RowsDeleted = int64(len(missing))
+	}
+
+	return result, stream.checkLimits()
+}
+
+// recordsByKey maps the key of every existing record to its ID. Records
+// with an empty key column can never match a row and are returned
+// separately.
+func (s *DatasetService) recordsByKey(ctx context.Context, datasetID uuid.UUID, keyColumns []int, fieldIDs []uuid.UUID) (map[string]uuid.UUID, []uuid.UUID, error) {
+	records, err := s.Repo.Queries.GetRecordsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	parts := make(map[uuid.UUID][]string, len(records))
+	for _, r := range records {
+		parts[r.ID] = make([]string, len(keyColumns))
+	}
+	for k, col := range keyColumns {
+		values, err := s.Repo.Queries.GetRecordValuesForField(ctx, fieldIDs[col])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get key values: %w", err)
+		}
+		for _, v := range values {
+			if p, ok := parts[v.RecordID]; ok && v.Value.Valid {
+				p[k] = v.Value.String
+			}
+		}
+	}
+
+	byKey := make(map[string]uuid.UUID, len(records))
+	var unkeyed []uuid.UUID
+	for _, r := range records {
+		key, empty := rowKey(parts[r.ID], allColumns(len(keyColumns)))
+		if empty {
+			unkeyed = append(unkeyed, r.ID)
+			continue
+		}
+		if _, dup := byKey[key]; dup {
+			return nil, nil, fmt.Errorf("%w: key %q appears more than once", ErrDuplicateKey, strings.ReplaceAll(key, keySeparator, ", "))
+		}
+		byKey[key] = r.ID
+	}
+	return byKey, unkeyed, nil
+}
+
+func allColumns(n int) []int {
+	cols := make([]int, n)
+	for i := range cols {
+		cols[i] = i
+	}
+	return cols
+}
+
+// rowKey builds the key of a row, reporting whether any part of it is empty.
+// Values are trimmed the same way they are when stored.
+func rowKey(row []string, keyColumns []int) (string, bool) {
+	parts := make([]string, len(keyColumns))
+	empty := false
+	for k, col := range keyColumns {
+		parts[k] = strings.TrimSpace(row[col])
+		if parts[k] == "" {
+			empty = true
+		}
+	}
+	return strings.Join(parts, keySeparator), empty
+}
+
+func chunkIDs(ids []uuid.UUID) [][]uuid.UUID {
+	var chunks [][]uuid.UUID
+	for len(ids) > 0 {
+		n := min(len(ids), mergeChunkSize)
+		chunks = append(chunks, ids[:n])
+		ids = ids[n:]
+	}
+	return chunks
+}
@@ -0,0 +1,518 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetColumnOrderKey is the key-value metadata entry recording the
+// dataset's column order in exported files, since Parquet groups are stored
+// sorted by name.
+const parquetColumnOrderKey = "insightforge.columns"
+
+// parquetColumn describes how a Parquet leaf column maps onto a dataset field.
+type parquetColumn struct {
+	name     string
+	dataType string
+	repeated bool
+	format   func(parquet.Value) string
+}
+
+// UploadParquet imports a Parquet file. Column types come from the Parquet
+// schema rather than from sampling, so typed data keeps its type. Nested
+// groups become dotted column names and repeated columns are stored as
+// JSON arrays.
+func (s *DatasetService) UploadParquet(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+) (database.Dataset, error) {
+	return s.importOne(ctx, ImportOptions{}, func(tx *DatasetService, opts ImportOptions) (database.Dataset, error) {
+		return tx.importParquet(ctx, userID, filename, file, opts)
+	})
+}
+
+func (s *DatasetService) importParquet(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) (database.Dataset, error) {
+	readerAt, size, err := asReaderAt(file)
+	if err != nil {
+		return database.Dataset{}, fmt.Errorf("failed to read parquet file: %w", err)
+	}
+
+	pf, err := parquet.OpenFile(readerAt, size)
+	if err != nil {
+		return database.Dataset{}, fmt.Errorf("invalid parquet file: %w", err)
+	}
+
+	columns, err := parquetColumns(pf.Schema())
+	if err != nil {
+		return database.Dataset{}, err
+	}
+
+	order := parquetColumnOrder(pf, columns)
+	headers := make([]string, len(columns))
+	fieldTypes := make([]string, len(columns))
+	for i, idx := range order {
+		headers[i] = columns[idx].name
+		fieldTypes[i] = columns[idx].dataType
+	}
+
+	dataset, err := s.createImportDataset(ctx, userID, filename, "Uploaded dataset file", "", opts)
+	if err != nil {
+		logger.Logger.Printf("Error uploading dataset: %v", err)
+		return database.Dataset{}, err
+	}
+
+	reader := &parquetRowReader{columns: columns, order: order, groups: pf.RowGroups(), total: pf.NumRows()}
+	defer reader.Close()
+
+	ingest := opts.ingestOptions()
+	ingest.fieldTypes = fieldTypes
+	if err := s.ingestRows(ctx, dataset, headers, reader, ingest); err != nil {
+		return dataset, err
+	}
+
+	return dataset, nil
+}
+
+// asReaderAt exposes the upload as an io.ReaderAt, which the Parquet footer
+// lookup requires. Multipart files already support random access; anything
+// else is buffered in memory.
+func asReaderAt(r io.Reader) (io.ReaderAt, int64, error) {
+	if ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		size, err := ra.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := ra.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		return ra, size, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+func parquetColumns(schema *parquet.Schema) ([]parquetColumn, error) {
+	paths := schema.Columns()
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("parquet file has no columns")
+	}
+
+	columns := make([]parquetColumn, len(paths))
+	for _, path := range paths {
+		leaf, ok := schema.Lookup(path...)
+		if !ok {
+			return nil, fmt.Errorf("parquet column %s not found", strings.Join(path, "."))
+		}
+		dataType, format := parquetTypeMapping(leaf.Node.Type())
+		columns[leaf.ColumnIndex] = parquetColumn{
+			name:     parquetColumnName(path),
+			dataType: dataType,
+			repeated: leaf.MaxRepetitionLevel > 0,
+			format:   format,
+		}
+		if columns[leaf.ColumnIndex].repeated {
+			columns[leaf.ColumnIndex].dataType = "text"
+		}
+	}
+	return columns, nil
+}
+
+// parquetColumnOrder returns the column indexes in the order they should
+// become dataset fields: the order recorded by ExportParquet when present,
+// otherwise the file's own column order.
+func parquetColumnOrder(pf *parquet.File, columns []parquetColumn) []int {
+	order := make([]int, len(columns))
+	for i := range order {
+		order[i] = i
+	}
+
+	raw, ok := pf.Lookup(parquetColumnOrderKey)
+	if !ok {
+		return order
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(raw), &names); err != nil || len(names) != len(columns) {
+		return order
+	}
+
+	byName := make(map[string]int, len(columns))
+	for i, col := range columns {
+		byName[col.name] = i
+	}
+	recorded := make([]int, 0, len(names))
+	for _, name := range names {
+		idx, ok := byName[name]
+		if !ok {
+			return order
+		}
+		recorded = append(recorded, idx)
+	}
+	return recorded
+}
+
+// parquetColumnName joins a column path with dots, dropping the
+// "list"/"element" wrapper levels of the standard LIST encoding.
+func parquetColumnName(path []string) string {
+	parts := make([]string, 0, len(path))
+	for i, p := range path {
+		if i > 0 && (p == "list" || p == "element" || p == "item") && len(parts) > 0 {
+			continue
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// parquetTypeMapping returns the dataset data_type matching a Parquet type
+// and a formatter producing values type inference would classify the same
+// way.
+func parquetTypeMapping(t parquet.Type) (string, func(parquet.Value) string) {
+	if lt := t.LogicalType(); lt != nil {
+		switch {
+		case lt.Date != nil:
+			return "datetime", func(v parquet.Value) string {
+				return time.Unix(int64(v.Int32())*86400, 0).UTC().Format("2006-01-02")
+			}
+		case lt.Timestamp != nil:
+			unit := time.Millisecond
+			switch {
+			case lt.Timestamp.Unit.Micros != nil:
+				unit = time.Microsecond
+			case lt.Timestamp.Unit.Nanos != nil:
+				unit = time.Nanosecond
+			}
+			return "datetime", func(v parquet.Value) string {
+				return unixInUnit(v.Int64(), unit).Format(time.RFC3339Nano)
+			}
+		case lt.Time != nil:
+			unit := time.Millisecond
+			switch {
+			case lt.Time.Unit.Micros != nil:
+				unit = time.Microsecond
+			case lt.Time.Unit.Nanos != nil:
+				unit = time.Nanosecond
+			}
+			return "text", func(v parquet.Value) string {
+				n := v.Int64()
+				if t.Kind() == parquet.Int32 {
+					n = int64(v.Int32())
+				}
+				return unixInUnit(n, unit).Format("15:04:05.999999999")
+			}
+		case lt.Decimal != nil:
+			scale := lt.Decimal.Scale
+			return "float", func(v parquet.Value) string {
+				return formatDecimal(parquetUnscaled(v), scale)
+			}
+		case lt.Integer != nil:
+			if !lt.Integer.IsSigned {
+				return "integer", func(v parquet.Value) string {
+					if lt.Integer.BitWidth == 64 {
+						return strconv.FormatUint(v.Uint64(), 10)
+					}
+					return strconv.FormatUint(uint64(v.Uint32()), 10)
+				}
+			}
+		case lt.UUID != nil:
+			return "text", func(v parquet.Value) string {
+				id, err := uuid.FromBytes(v.ByteArray())
+				if err != nil {
+					return string(v.ByteArray())
+				}
+				return id.String()
+			}
+		}
+	}
+
+	switch t.Kind() {
+	case parquet.Boolean:
+		return "boolean", func(v parquet.Value) string { return strconv.FormatBool(v.Boolean()) }
+	case parquet.Int32:
+		return "integer", func(v parquet.Value) string { return strconv.FormatInt(int64(v.Int32()), 10) }
+	case parquet.Int64:
+		return "integer", func(v parquet.Value) string { return strconv.FormatInt(v.Int64(), 10) }
+	case parquet.Int96:
+		// Legacy timestamps: nanoseconds within the day plus a Julian day number
+		return "datetime", func(v parquet.Value) string {
+			i := v.Int96()
+			nanos := int64(i[1])<<32 | int64(i[0])
+			days := int64(i[2]) - 2440588
+			return time.Unix(days*86400, nanos).UTC().Format(time.RFC3339Nano)
+		}
+	case parquet.Float:
+		return "float", func(v parquet.Value) string { return strconv.FormatFloat(float64(v.Float()), 'g', -1, 32) }
+	case parquet.Double:
+		return "float", func(v parquet.Value) string { return strconv.FormatFloat(v.Double(), 'g', -1, 64) }
+	default:
+		return "text", func(v parquet.Value) string { return string(v.ByteArray()) }
+	}
+}
+
+func unixInUnit(n int64, unit time.Duration) time.Time {
+	switch unit {
+	case time.Millisecond:
+		return time.UnixMilli(n).UTC()
+	case time.Microsecond:
+		return time.UnixMicro(n).UTC()
+	default:
+		return time.Unix(0, n).UTC()
+	}
+}
+
+// parquetUnscaled returns the unscaled integer of a DECIMAL value, which may
+// be stored as INT32, INT64 or a big-endian two's complement byte array.
+func parquetUnscaled(v parquet.Value) *big.Int {
+	switch v.Kind() {
+	case parquet.Int32:
+		return big.NewInt(int64(v.Int32()))
+	case parquet.Int64:
+		return big.NewInt(v.Int64())
+	}
+
+	b := v.ByteArray()
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
+
+func formatDecimal(unscaled *big.Int, scale int32) string {
+	if scale <= 0 {
+		return unscaled.String()
+	}
+	digits := new(big.Int).Abs(unscaled).String()
+	for len(digits) <= int(scale) {
+		digits = "0" + digits
+	}
+	point := len(digits) - int(scale)
+	s := digits[:point] + "." + digits[point:]
+	if unscaled.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// parquetRowReader adapts the row groups of a Parquet file to rowReader.
+type parquetRowReader struct {
+	columns []parquetColumn
+	order   []int
+	groups  []parquet.RowGroup
+	rows    parquet.Rows
+	buf     []parquet.Row
+	total   int64
+}
+
+// Len reports the number of rows recorded in the file footer.
+func (r *parquetRowReader) Len() int {
+	return int(r.total)
+}
+
+func (r *parquetRowReader) Read() ([]string, error) {
+	if r.buf == nil {
+		r.buf = make([]parquet.Row, 1)
+	}
+	for {
+		if r.rows == nil {
+			if len(r.groups) == 0 {
+				return nil, io.EOF
+			}
+			r.rows = r.groups[0].Rows()
+			r.groups = r.groups[1:]
+		}
+
+		n, err := r.rows.ReadRows(r.buf)
+		if n == 1 {
+			return r.decode(r.buf[0]), nil
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		r.rows.Close()
+		r.rows = nil
+	}
+}
+
+func (r *parquetRowReader) decode(row parquet.Row) []string {
+	out := make([]string, len(r.columns))
+	var lists map[int][]string
+	for _, v := range row {
+		idx := v.Column()
+		if idx < 0 || idx >= len(r.columns) {
+			continue
+		}
+		col := r.columns[idx]
+		if col.repeated {
+			if lists == nil {
+				lists = make(map[int][]string)
+			}
+			if _, ok := lists[idx]; !ok {
+				lists[idx] = []string{}
+			}
+			if !v.IsNull() {
+				lists[idx] = append(lists[idx], col.format(v))
+			}
+			continue
+		}
+		if !v.IsNull() {
+			out[idx] = col.format(v)
+		}
+	}
+	for idx, values := range lists {
+		if len(values) == 0 {
+			continue
+		}
+		encoded, _ := json.Marshal(values)
+		out[idx] = string(encoded)
+	}
+
+	ordered := make([]string, len(r.order))
+	for i, idx := range r.order {
+		ordered[i] = out[idx]
+	}
+	return ordered
+}
+
+func (r *parquetRowReader) Close() {
+	if r.rows != nil {
+		r.rows.Close()
+		r.rows = nil
+	}
+}
+
+// ExportParquet writes a dataset as a Parquet file with one optional column
+// per field. Columns keep their data_type when every value converts cleanly
+// and fall back to strings otherwise, so no value is lost.
+func (s *DatasetService) ExportParquet(ctx context.Context, datasetID, userID uuid.UUID, w io.Writer) error {
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return fmt.Errorf("failed to get fields: %w", err)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("dataset has no columns")
+	}
+
+	_, rows, err := s.GetDatasetRows(ctx, datasetID, userID)
+	if err != nil {
+		return err
+	}
+
+	group := parquet.Group{}
+	converters := make([]func(string) (parquet.Value, bool), len(fields))
+	for i, f := range fields {
+		node, convert := parquetNodeFor(f.DataType)
+		for _, row := range rows {
+			if isNullToken(strings.TrimSpace(row[i])) {
+				continue
+			}
+			if _, ok := convert(row[i]); !ok {
+				node, convert = parquetNodeFor("text")
+				break
+			}
+		}
+		group[f.Name] = parquet.Optional(node)
+		converters[i] = convert
+	}
+
+	schema := parquet.NewSchema("dataset", group)
+	columnIndex := make([]int, len(fields))
+	for i, f := range fields {
+		leaf, ok := schema.Lookup(f.Name)
+		if !ok {
+			return fmt.Errorf("column %q missing from parquet schema", f.Name)
+		}
+		columnIndex[i] = leaf.ColumnIndex
+	}
+
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	order, _ := json.Marshal(names)
+
+	writer := parquet.NewWriter(w, schema, parquet.KeyValueMetadata(parquetColumnOrderKey, string(order)))
+	const batchSize = 1000
+	batch := make([]parquet.Row, 0, batchSize)
+	for _, row := range rows {
+		out := make(parquet.Row, len(fields))
+		for i, raw := range row {
+			value := parquet.NullValue()
+			def := 0
+			if !isNullToken(strings.TrimSpace(raw)) {
+				value, _ = converters[i](raw)
+				def = 1
+			}
+			out[columnIndex[i]] = value.Level(0, def, columnIndex[i])
+		}
+		batch = append(batch, out)
+		if len(batch) == batchSize {
+			if _, err := writer.WriteRows(batch); err != nil {
+				return fmt.Errorf("failed to write parquet rows: %w", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	if _, err := writer.WriteRows(batch); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+
+	return writer.Close()
+}
+
+// parquetNodeFor maps a dataset data_type onto a Parquet node and a
+// converter from the stored string to a Parquet value.
+func parquetNodeFor(dataType string) (parquet.Node, func(string) (parquet.Value, bool)) {
+	switch dataType {
+	case "integer":
+		return parquet.Int(64), func(raw string) (parquet.Value, bool) {
+			n, ok := parseInteger(raw)
+			return parquet.Int64Value(n), ok
+		}
+	case "float":
+		return parquet.Leaf(parquet.DoubleType), func(raw string) (parquet.Value, bool) {
+			f, ok := parseNumber(raw)
+			return parquet.DoubleValue(f), ok
+		}
+	case "boolean":
+		return parquet.Leaf(parquet.BooleanType), func(raw string) (parquet.Value, bool) {
+			b, ok := parseBoolean(strings.TrimSpace(raw))
+			return parquet.BooleanValue(b), ok
+		}
+	case "datetime":
+		return parquet.TimestampAdjusted(parquet.Microsecond, true), func(raw string) (parquet.Value, bool) {
+			t, ok := parseDate(strings.TrimSpace(raw))
+			return parquet.Int64Value(t.UnixMicro()), ok
+		}
+	default:
+		return parquet.String(), func(raw string) (parquet.Value, bool) {
+			return parquet.ByteArrayValue([]byte(raw)), true
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxStoredRejections caps the rejections kept per dataset so a file that is
+// entirely malformed cannot flood the database. Rejected rows past the cap
+// are still counted.
+const maxStoredRejections = 10000
+
+// maxRejectedRawSize caps the raw text stored for a single rejected row.
+const maxRejectedRawSize = 8 << 10
+
+// ErrTooManyRejections is returned when an upload rejects more rows than
+// ImportOptions allows.
+var ErrTooManyRejections = errors.New("too many rejected rows")
+
+// RowRejection describes a row that could not be imported. RowNumber is the
+// 1-based line in the file for CSV uploads (the header is line 1) and the
+// position of the record for other formats.
+type RowRejection struct {
+	RowNumber int64
+	Raw       string
+	Reason    string
+}
+
+// RejectionLimits fails an upload once too many rows are rejected. Zero
+// values mean no limit.
+type RejectionLimits struct {
+	// MaxRows is the number of rejected rows tolerated per dataset.
+	MaxRows int64
+	// MaxPercent is the share of rows, from 0 to 100, that may be rejected.
+	MaxPercent float64
+}
+
+// exceeded reports why the limits are broken, or "" if they are not. The
+// percentage is only meaningful once every row has been read.
+func (l RejectionLimits) exceeded(p IngestProgress, final bool) string {
+	if l.MaxRows > 0 && p.RowsRejected > l.MaxRows {
+		return fmt.Sprintf("rejected %d rows, more than the limit of %d", p.RowsRejected, l.MaxRows)
+	}
+	total := p.RowsProcessed + p.RowsRejected
+	if final && l.MaxPercent > 0 && total > 0 {
+		pct := float64(p.RowsRejected) / float64(total) * 100
+		if pct > l.MaxPercent {
+			return fmt.Sprintf("rejected %d of %d rows (%.1f%%), more than the limit of %g%%", p.RowsRejected, total, pct, l.MaxPercent)
+		}
+	}
+	return ""
+}
+
+// isRowError reports whether a read error is confined to one malformed row,
+// which can be skipped. Other errors mean the file itself could not be read.
+func isRowError(err error) bool {
+	var parseErr *csv.ParseError
+	return errors.As(err, &parseErr)
+}
+
+// rejectionReason describes a read error in the words of the report.
+func rejectionReason(err error, row []string, expected int) string {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		if errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			return fmt.Sprintf("expected %d fields, got %d", expected, len(row))
+		}
+		return parseErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// rowPositioner is implemented by row readers that know where the row they
+// last returned came from.
+type rowPositioner interface {
+	position() RowRejection
+}
+
+// csvRowReader wraps a csv.Reader and keeps the raw text and line number of
+// the last record read, so rejected rows can be reported as they appeared in
+// the file.
+type csvRowReader struct {
+	r       *csv.Reader
+	src     *recordingReader
+	dialect Dialect
+	swap    func(rune) rune
+	line    int64
+	last    RowRejection
+
+	// unread holds a row to return again before reading on
+	unread     []string
+	unreadLast RowRejection
+}
+
+func newCSVRowReader(r io.Reader, dialect Dialect) *csvRowReader {
+	src := &recordingReader{r: swapQuotes(r, dialect.Quote)}
+	cr := csv.NewReader(src)
+	cr.Comma = dialect.Delimiter
+	return &csvRowReader{r: cr, src: src, dialect: dialect, swap: quoteSwap(dialect.Quote), line: 1}
+}
+
+func (c *csvRowReader) Read() ([]string, error) {
+	if c.unread != nil {
+		row := c.unread
+		c.unread, c.last = nil, c.unreadLast
+		return row, nil
+	}
+
+	start := c.r.InputOffset()
+	row, err := c.r.Read()
+	end := c.r.InputOffset()
+
+	taken := c.src.take(start, end)
+	raw := strings.TrimRight(string(taken), "\r\n")
+	if c.swap != nil {
+		raw = strings.Map(c.swap, raw)
+		for i := range row {
+			row[i] = strings.Map(c.swap, row[i])
+		}
+	}
+	c.last = RowRejection{
+		RowNumber: c.line,
+		Raw:       raw,
+	}
+	c.line += int64(bytes.Count(taken, []byte("\n")))
+	return row, err
+}
+
+// unreadRow makes the next Read return the row just read again.
+func (c *csvRowReader) unreadRow(row []string) {
+	c.unread, c.unreadLast = row, c.last
+}
+
+func (c *csvRowReader) position() RowRejection {
+	return c.last
+}
+
+// recordingReader keeps the bytes read through it until they are taken, so
+// the text of a record can be recovered from its offsets.
+type recordingReader struct {
+	r    io.Reader
+	buf  []byte
+	base int64
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+// take returns the bytes between two stream offsets and forgets everything
+// before end.
+func (rr *recordingReader) take(start, end int64) []byte {
+	from, to := start-rr.base, end-rr.base
+	if from < 0 || to > int64(len(rr.buf)) || from > to {
+		return nil
+	}
+	out := append([]byte(nil), rr.buf[from:to]...)
+	rr.buf = rr.buf[to:]
+	rr.base = end
+	return out
+}
+
+// encodeRow renders a parsed row as a CSV line for the report.
+func encodeRow(row []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(row)
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// rejectionRecorder logs rejected rows and passes the first
+// maxStoredRejections of them to the import's sink.
+type rejectionRecorder struct {
+	sink      func(datasetID uuid.UUID, rej RowRejection)
+	datasetID uuid.UUID
+	stored    int
+}
+
+func (r *rejectionRecorder) record(rej RowRejection) {
+	log.Printf("Skipping malformed row %d: %s", rej.RowNumber, rej.Reason)
+	if r.sink == nil || r.stored >= maxStoredRejections {
+		return
+	}
+	if len(rej.Raw) > maxRejectedRawSize {
+		rej.Raw = strings.ToValidUTF8(rej.Raw[:maxRejectedRawSize], "")
+	}
+	r.sink(r.datasetID, rej)
+	r.stored++
+}
+
+type datasetRejection struct {
+	RowRejection
+	datasetID uuid.UUID
+}
+
+// storeRejections saves the rows rejected by an upload job. The dataset is
+// only referenced if the import was committed.
+func (s *DatasetService) storeRejections(ctx context.Context, jobID uuid.NullUUID, rejected []datasetRejection, committed bool) {
+	if !jobID.Valid {
+		return
+	}
+	for _, rej := range rejected {
+		err := s.Repo.Queries.CreateUploadRejection(ctx, database.CreateUploadRejectionParams{
+			ID:          uuid.New(),
+			UploadJobID: jobID.UUID,
+			DatasetID:   uuid.NullUUID{UUID: rej.datasetID, Valid: committed},
+			RowNumber:   rej.RowNumber,
+			Raw:         rej.Raw,
+			Reason:      rej.Reason,
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			log.Printf("Failed to store rejection for row %d: %v", rej.RowNumber, err)
+		}
+	}
+}
+
+// ListUploadRejections returns the rows rejected by one of the user's
+// uploads.
+func (s *DatasetService) ListUploadRejections(ctx context.Context, jobID, userID uuid.UUID) ([]database.UploadRejection, error) {
+	job, err := s.Repo.Queries.GetUploadJob(ctx, jobID)
+	if err != nil || job.UserID != userID {
+		return nil, ErrUploadJobNotFound
+	}
+
+	rejections, err := s.Repo.Queries.ListUploadRejections(ctx, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rejections: %w", err)
+	}
+	return rejections, nil
+}
+
+// WriteRejectionsCSV writes a rejection report as CSV.
+func WriteRejectionsCSV(w io.Writer, rejections []database.UploadRejection) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"dataset_id", "row_number", "reason", "raw"}); err != nil {
+		return err
+	}
+	for _, rej := range rejections {
+		datasetID := ""
+		if rej.DatasetID.Valid {
+			datasetID = rej.DatasetID.UUID.String()
+		}
+		record := []string{datasetID, strconv.FormatInt(rej.RowNumber, 10), rej.Reason, rej.Raw}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRowNotFound  = errors.New("row not found")
+	ErrInvalidValue = errors.New("invalid value")
+)
+
+// UpdateCell sets the value of one column of a row and returns the row as
+// updated, along with the names of its columns.
+func (s *DatasetService) UpdateCell(ctx context.Context, datasetID, userID uuid.UUID, rowIndex int64, column, value string) ([]string, DatasetRow, error) {
+	return s.UpdateRow(ctx, datasetID, userID, rowIndex, map[string]string{column: value})
+}
+
+// UpdateRow sets the values of the named columns of a row, leaving its other
+// columns as they are, and returns the row as updated, along with the names
+// of its columns. Values are checked against the data type of their column;
+// an empty value or a null token clears a cell. Each edit is recorded as a
+// version of the dataset.
+func (s *DatasetService) UpdateRow(ctx context.Context, datasetID, userID uuid.UUID, rowIndex int64, values map[string]string) ([]string, DatasetRow, error) {
+	if len(values) == 0 {
+		return nil, DatasetRow{}, fmt.Errorf("%w: no values given", ErrInvalidValue)
+	}
+
+	var (
+		columns []string
+		row     DatasetRow
+	)
+	_, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		fields, err := tx.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get fields: %w", err)
+		}
+		checked, err := checkValues(fields, values)
+		if err != nil {
+			return "", err
+		}
+		recordIDs, err := tx.recordsByRowIndex(ctx, datasetID, []int64{rowIndex})
+		if err != nil {
+			return "", err
+		}
+
+		var edited []string
+		cells := make([]database.CreateRecordValueParams, 0, len(checked))
+		for _, f := range fields {
+			if val, ok := checked[f.ID]; ok {
+				cells = append(cells, database.CreateRecordValueParams{
+					RecordID: recordIDs[0],
+					FieldID:  f.ID,
+					Value:    storedValue(val),
+				})
+				edited = append(edited, f.Name)
+			}
+		}
+		if err := tx.values().UpsertRecords(ctx, nil, cells); err != nil {
+			return "", fmt.Errorf("failed to update values: %w", err)
+		}
+		if err := tx.touchRows(ctx, datasetID, recordIDs); err != nil {
+			return "", err
+		}
+
+		var fieldIDs []uuid.UUID
+		columns, fieldIDs, _ = projectFields(fields, nil)
+		rows, err := tx.values().RowsAfter(ctx, datasetID, fieldIDs, rowIndex-1, 1)
+		if err != nil {
+			return "", err
+		}
+		row = rows[0]
+		return fmt.Sprintf("edit row %d: %s", rowIndex, strings.Join(edited, ", ")), nil
+	})
+	s.Cache.Invalidate(datasetID)
+	if err != nil {
+		return nil, DatasetRow{}, err
+	}
+	return columns, row, nil
+}
+
+// InsertRows adds rows after the last row of a dataset and returns their row
+// indexes. Each row holds values by column name; columns it leaves out are
+// empty. Values are checked like those given to UpdateRow.
+func (s *DatasetService) InsertRows(ctx context.Context, datasetID, userID uuid.UUID, rows []map[string]string) ([]int64, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no rows given", ErrInvalidValue)
+	}
+
+	var rowIndexes []int64
+	_, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		fields, err := tx.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get fields: %w", err)
+		}
+		if len(fields) == 0 {
+			return "", fmt.Errorf("%w: dataset has no columns", ErrInvalidValue)
+		}
+		next, err := tx.Repo.Queries.GetNextRowIndex(ctx, datasetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get next row index: %w", err)
+		}
+
+		now := time.Now()
+		records := make([]database.CreateDatasetRecordParams, 0, len(rows))
+		cells := make([]database.CreateRecordValueParams, 0, len(rows)*len(fields))
+		for i, values := range rows {
+			checked, err := checkValues(fields, values)
+			if err != nil {
+				return "", fmt.Errorf("row %d: %w", i+1, err)
+			}
+			record := database.CreateDatasetRecordParams{
+				ID:        uuid.New(),
+				DatasetID: datasetID,
+				CreatedAt: now,
+				UpdatedAt: now,
+				RowIndex:  next + int64(i),
+			}
+			records = append(records, record)
+			rowIndexes = append(rowIndexes, record.RowIndex)
+			for _, f := range fields {
+				val := checked[f.ID]
+				cells = append(cells, database.CreateRecordValueParams{
+					RecordID: record.ID,
+					FieldID:  f.ID,
+					Value:    storedValue(val),
+				})
+			}
+		}
+		if err := tx.values().InsertRecords(ctx, records, cells); err != nil {
+			return "", fmt.Errorf("failed to insert rows: %w", err)
+		}
+		return "insert " + countRows(int64(len(rows))), tx.touchRows(ctx, datasetID, nil)
+	})
+	s.Cache.Invalidate(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	return rowIndexes, nil
+}
+
+// DeleteRows deletes rows by row index. Nothing is deleted unless every row
+// exists. The row indexes of the remaining rows are left unchanged.
+func (s *DatasetService) DeleteRows(ctx context.Context, datasetID, userID uuid.UUID, rowIndexes []int64) (int64, error) {
+	if len(rowIndexes) == 0 {
+		return 0, fmt.Errorf("%w: no rows given", ErrInvalidValue)
+	}
+
+	var deleted int64
+	_, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		recordIDs, err := tx.recordsByRowIndex(ctx, datasetID, rowIndexes)
+		if err != nil {
+			return "", err
+		}
+		if err := tx.deleteRecords(ctx, datasetID, recordIDs); err != nil {
+			return "", err
+		}
+		deleted = int64(len(recordIDs))
+		return "delete " + countRows(deleted), tx.touchRows(ctx, datasetID, nil)
+	})
+	s.Cache.Invalidate(datasetID)
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// checkValues trims values and checks each against the data type of its
+// column, and the date format its schema declared, returning them by field
+// ID. Null tokens pass in any column and are stored with storedValue, as
+// uploaded ones are.
+func checkValues(fields []database.GetFieldsByDatasetIDRow, values map[string]string) (map[uuid.UUID]string, error) {
+	checked := make(map[uuid.UUID]string, len(values))
+	for _, f := range fields {
+		val, ok := values[f.Name]
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		if !isNullToken(val) && !valueMatchesType(val, f.DataType, dateLayout(f.DateFormat.String)) {
+			if f.DateFormat.Valid {
+				return nil, fmt.Errorf("%w: column %q: %q does not match the date format %q", ErrInvalidValue, f.Name, val, f.DateFormat.String)
+			}
+			return nil, fmt.Errorf("%w: column %q: %q is not a valid %s", ErrInvalidValue, f.Name, val, f.DataType)
+		}
+		checked[f.ID] = val
+	}
+
+	if len(checked) < len(values) {
+		var unknown []string
+		for name := range values {
+			if !slices.ContainsFunc(fields, func(f database.GetFieldsByDatasetIDRow) bool { return f.Name == name }) {
+				unknown = append(unknown, name)
+			}
+		}
+		slices.Sort(unknown)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, strings.Join(unknown, ", "))
+	}
+	return checked, nil
+}
+
+// recordsByRowIndex returns the IDs of the records with the given row
+// indexes, failing with ErrRowNotFound if any of them does not exist.
+func (s *DatasetService) recordsByRowIndex(ctx context.Context, datasetID uuid.UUID, rowIndexes []int64) ([]uuid.UUID, error) {
+	wanted := slices.Clone(rowIndexes)
+	slices.Sort(wanted)
+	wanted = slices.Compact(wanted)
+
+	records, err := s.Repo.Queries.GetRecordsByRowIndex(ctx, database.GetRecordsByRowIndexParams{
+		DatasetID:  datasetID,
+		RowIndexes: wanted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	if len(records) < len(wanted) {
+		found := make(map[int64]bool, len(records))
+		for _, r := range records {
+			found[r.RowIndex] = true
+		}
+		var missing []string
+		for _, idx := range wanted {
+			if !found[idx] {
+				missing = append(missing, strconv.FormatInt(idx, 10))
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRowNotFound, strings.Join(missing, ", "))
+	}
+
+	ids := make([]uuid.UUID, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+	return ids, nil
+}
+
+// deleteRecords deletes records of a dataset by ID. Their rows count as
+// changed by the version being recorded, if any.
+func (s *DatasetService) deleteRecords(ctx context.Context, datasetID uuid.UUID, recordIDs []uuid.UUID) error {
+	for _, ids := range chunkIDs(recordIDs) {
+		rowIndexes, err := s.Repo.Queries.DeleteDatasetRecords(ctx, database.DeleteDatasetRecordsParams{
+			DatasetID: datasetID,
+			Ids:       ids,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete rows: %w", err)
+		}
+		s.changes.add(rowIndexes...)
+	}
+	return nil
+}
+
+// countRows formats a number of rows for the description of a version.
+func countRows(n int64) string {
+	if n == 1 {
+		return "1 row"
+	}
+	return strconv.FormatInt(n, 10) + " rows"
+}
+
+// touchRows bumps updated_at on the dataset and on the given records only.
+// Their rows count as changed by the version being recorded, if any.
+func (s *DatasetService) touchRows(ctx context.Context, datasetID uuid.UUID, recordIDs []uuid.UUID) error {
+	now := time.Now()
+	for _, ids := range chunkIDs(recordIDs) {
+		rowIndexes, err := s.Repo.Queries.TouchDatasetRecords(ctx, database.TouchDatasetRecordsParams{
+			UpdatedAt: now,
+			Ids:       ids,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update records: %w", err)
+		}
+		s.changes.add(rowIndexes...)
+	}
+
+	err := s.Repo.Queries.TouchDataset(ctx, database.TouchDatasetParams{
+		ID:        datasetID,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update dataset: %w", err)
+	}
+	return nil
+}
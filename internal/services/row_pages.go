@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultRowPageSize is the number of rows in a page when no limit is
+	// given.
+	DefaultRowPageSize = 100
+	// MaxRowPageSize is the largest page that can be requested.
+	MaxRowPageSize = 10000
+	// rowBatchSize is the number of rows a RowCursor reads per query.
+	rowBatchSize = 1000
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrUnknownColumn = errors.New("unknown column")
+)
+
+// DatasetRow is one row of a dataset, with its values in column order.
+type DatasetRow struct {
+	RowIndex int64    `json:"row_index"`
+	Values   []string `json:"values"`
+}
+
+// RowQuery selects rows of a dataset.
+type RowQuery struct {
+	// Columns projects rows onto these columns, in this order. Every column
+	// is returned when it is empty.
+	Columns []string
+	// Cursor continues from the end of the page it was returned with. The
+	// first rows are returned when it is empty.
+	Cursor string
+}
+
+// RowPage is a page of rows. NextCursor is empty on the last page.
+type RowPage struct {
+	Columns    []string
+	Rows       []DatasetRow
+	NextCursor string
+}
+
+// encodeCursor makes a cursor for the rows after rowIndex. Cursors are
+// opaque to clients so the keyset behind them can change.
+func encodeCursor(rowIndex int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(rowIndex, 10)))
+}
+
+// decodeCursor returns the row index a cursor continues after, or -1 for
+// an empty cursor.
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return -1, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	after, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || after < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return after, nil
+}
+
+// ReadRowPage returns up to limit rows of a dataset in row index order,
+// starting after q.Cursor.
+func (s *DatasetService) ReadRowPage(ctx context.Context, datasetID uuid.UUID, q RowQuery, limit int) (RowPage, error) {
+	if limit <= 0 {
+		limit = DefaultRowPageSize
+	}
+	if limit > MaxRowPageSize {
+		limit = MaxRowPageSize
+	}
+
+	cursor, err := s.OpenRowCursor(ctx, datasetID, q)
+	if err != nil {
+		return RowPage{}, err
+	}
+	cursor.batch = limit + 1
+
+	page := RowPage{Columns: cursor.Columns, Rows: []DatasetRow{}}
+	for len(page.Rows) < limit {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			return page, nil
+		}
+		if err != nil {
+			return RowPage{}, err
+		}
+		page.Rows = append(page.Rows, row)
+	}
+
+	// The extra row read with the page tells whether there is another.
+	if _, err := cursor.Next(ctx); err == nil {
+		page.NextCursor = encodeCursor(page.Rows[len(page.Rows)-1].RowIndex)
+	} else if err != io.EOF {
+		return RowPage{}, err
+	}
+	return page, nil
+}
+
+// RowCursor iterates over the rows of a dataset in row index order. It
+// reads them from the database a batch at a time, so a whole dataset can
+// be streamed without holding it in memory.
+type RowCursor struct {
+	// Columns names the values of each row.
+	Columns []string
+
+	store    rowPager
+	dataset  uuid.UUID
+	fieldIDs []uuid.UUID
+	after    int64
+	batch    int
+	buffered []DatasetRow
+	done     bool
+}
+
+// rowPager reads rows a batch at a time, like ValueStore.RowsAfter.
+type rowPager interface {
+	RowsAfter(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, after int64, limit int) ([]DatasetRow, error)
+}
+
+// OpenRowCursor starts reading the rows selected by q.
+func (s *DatasetService) OpenRowCursor(ctx context.Context, datasetID uuid.UUID, q RowQuery) (*RowCursor, error) {
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fields: %w", err)
+	}
+	columns, fieldIDs, err := projectFields(fields, q.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RowCursor{
+		Columns:  columns,
+		store:    s.values(),
+		dataset:  datasetID,
+		fieldIDs: fieldIDs,
+		after:    after,
+		batch:    rowBatchSize,
+		done:     len(fields) == 0,
+	}, nil
+}
+
+// Next returns the next row, or io.EOF once every row has been read.
+func (c *RowCursor) Next(ctx context.Context) (DatasetRow, error) {
+	if len(c.buffered) == 0 && !c.done {
+		rows, err := c.store.RowsAfter(ctx, c.dataset, c.fieldIDs, c.after, c.batch)
+		if err != nil {
+			return DatasetRow{}, err
+		}
+		c.buffered = rows
+		c.done = len(rows) < c.batch
+		if len(rows) > 0 {
+			c.after = rows[len(rows)-1].RowIndex
+		}
+	}
+	if len(c.buffered) == 0 {
+		return DatasetRow{}, io.EOF
+	}
+	row := c.buffered[0]
+	c.buffered = c.buffered[1:]
+	return row, nil
+}
+
+// projectFields returns the names and IDs of the named fields, or of every
+// field when names is empty.
+func projectFields(fields []database.GetFieldsByDatasetIDRow, names []string) ([]string, []uuid.UUID, error) {
+	if len(names) == 0 {
+		columns := make([]string, len(fields))
+		fieldIDs := make([]uuid.UUID, len(fields))
+		for i, f := range fields {
+			columns[i] = f.Name
+			fieldIDs[i] = f.ID
+		}
+		return columns, fieldIDs, nil
+	}
+
+	byName := make(map[string]uuid.UUID, len(fields))
+	for _, f := range fields {
+		byName[f.Name] = f.ID
+	}
+
+	var unknown []string
+	fieldIDs := make([]uuid.UUID, len(names))
+	for i, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		fieldIDs[i] = id
+	}
+	if len(unknown) > 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownColumn, strings.Join(unknown, ", "))
+	}
+	return names, fieldIDs, nil
+}
+
+// readRowsAfter reads up to limit rows after the row index after, with a
+// column per field in fieldIDs.
+func readRowsAfter(ctx context.Context, repo *database.Repository, datasetID uuid.UUID, fieldIDs []uuid.UUID, after int64, limit int) ([]DatasetRow, error) {
+	values, err := repo.Queries.GetDatasetValuesPage(ctx, database.GetDatasetValuesPageParams{
+		DatasetID: datasetID,
+		After:     after,
+		Limit:     int32(limit),
+		FieldIds:  fieldIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record values: %w", err)
+	}
+
+	columns := make(map[uuid.UUID][]int, len(fieldIDs))
+	for i, id := range fieldIDs {
+		columns[id] = append(columns[id], i)
+	}
+
+	var (
+		rows   []DatasetRow
+		record uuid.UUID
+	)
+	for _, v := range values {
+		if len(rows) == 0 || v.RecordID != record {
+			rows = append(rows, DatasetRow{RowIndex: v.RowIndex, Values: make([]string, len(fieldIDs))})
+			record = v.RecordID
+		}
+		if !v.FieldID.Valid || !v.Value.Valid {
+			continue
+		}
+		row := rows[len(rows)-1]
+		for _, col := range columns[v.FieldID.UUID] {
+			row.Values[col] = v.Value.String
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Column data types, as stored in dataset_fields.data_type.
+const (
+	TypeText     = "text"
+	TypeInteger  = "integer"
+	TypeFloat    = "float"
+	TypeBoolean  = "boolean"
+	TypeDatetime = "datetime"
+)
+
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// ColumnSchema fixes the type of an uploaded column instead of inferring it.
+// Every value in the column is checked against it and rows that do not
+// conform are rejected.
+type ColumnSchema struct {
+	Type string `json:"type"`
+	// Format is the layout of datetime values, either with YYYY, MM, DD, HH,
+	// mm and ss placeholders or as a Go reference time. Without it the
+	// formats recognised by inference are accepted.
+	Format string `json:"format,omitempty"`
+	// Nullable allows empty values. It defaults to true.
+	Nullable    *bool  `json:"nullable,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema maps column names to their declared schema. Columns it does not
+// mention are inferred as usual.
+type Schema map[string]ColumnSchema
+
+// ParseSchema decodes and checks a JSON schema such as
+//
+//	{"zip": {"type": "text"}, "joined": {"type": "datetime", "format": "DD/MM/YYYY", "nullable": false}}
+func ParseSchema(data []byte) (Schema, error) {
+	var schema Schema
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+
+	for name, col := range schema {
+		switch col.Type {
+		case TypeText, TypeInteger, TypeFloat, TypeBoolean, TypeDatetime:
+		case "":
+			return nil, fmt.Errorf("%w: column %q has no type", ErrInvalidSchema, name)
+		default:
+			return nil, fmt.Errorf("%w: column %q has unknown type %q", ErrInvalidSchema, name, col.Type)
+		}
+		if col.Format != "" && col.Type != TypeDatetime {
+			return nil, fmt.Errorf("%w: column %q has a format but is not a datetime", ErrInvalidSchema, name)
+		}
+	}
+	return schema, nil
+}
+
+// compiledSchema is a Schema resolved against the columns of a file.
+type compiledSchema struct {
+	headers []string
+	columns []*ColumnSchema
+	layouts []string
+}
+
+// compile matches the schema to the file's header. Every column named in
+// the schema must be in the file.
+func (sc Schema) compile(headers []string) (*compiledSchema, error) {
+	if len(sc) == 0 {
+		return nil, nil
+	}
+
+	cs := &compiledSchema{
+		headers: headers,
+		columns: make([]*ColumnSchema, len(headers)),
+		layouts: make([]string, len(headers)),
+	}
+	found := make(map[string]bool, len(sc))
+	for i, h := range headers {
+		col, ok := sc[h]
+		if !ok {
+			continue
+		}
+		cs.columns[i] = &col
+		cs.layouts[i] = dateLayout(col.Format)
+		found[h] = true
+	}
+
+	var missing []string
+	for name := range sc {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: schema columns not in the file: %s", ErrInvalidSchema, strings.Join(missing, ", "))
+	}
+	return cs, nil
+}
+
+// declaredType returns the type of column i, or "" if the schema does not
+// mention it.
+func (cs *compiledSchema) declaredType(i int) string {
+	if cs == nil || cs.columns[i] == nil {
+		return ""
+	}
+	return cs.columns[i].Type
+}
+
+// format returns the date format declared for column i, or "".
+func (cs *compiledSchema) format(i int) string {
+	if cs == nil || cs.columns[i] == nil {
+		return ""
+	}
+	return cs.columns[i].Format
+}
+
+func (cs *compiledSchema) description(i int) string {
+	if cs == nil || cs.columns[i] == nil {
+		return ""
+	}
+	return cs.columns[i].Description
+}
+
+// validate returns why a row breaks the schema, or "" if it conforms.
+func (cs *compiledSchema) validate(row []string) string {
+	for i, col := range cs.columns {
+		if col == nil || i >= len(row) {
+			continue
+		}
+		val := strings.TrimSpace(row[i])
+		if isNullToken(val) {
+			if col.Nullable != nil && !*col.Nullable {
+				return fmt.Sprintf("column %q is empty but not nullable", cs.headers[i])
+			}
+			continue
+		}
+		if !valueMatchesType(val, col.Type, cs.layouts[i]) {
+			if col.Format != "" {
+				return fmt.Sprintf("column %q: %q does not match the date format %q", cs.headers[i], val, col.Format)
+			}
+			return fmt.Sprintf("column %q: %q is not a valid %s", cs.headers[i], val, col.Type)
+		}
+	}
+	return ""
+}
+
+func valueMatchesType(val, dataType, layout string) bool {
+	var ok bool
+	switch dataType {
+	case TypeInteger:
+		_, ok = parseInteger(val)
+	case TypeFloat:
+		_, ok = parseNumber(val)
+	case TypeBoolean:
+		_, ok = parseBoolean(val)
+	case TypeDatetime:
+		if layout == "" {
+			return isDate(val)
+		}
+		_, err := time.Parse(layout, val)
+		ok = err == nil
+	default:
+		ok = true
+	}
+	return ok
+}
+
+// dateLayout turns a format with YYYY/MM/DD/HH/mm/ss placeholders into a Go
+// time layout. Go layouts contain none of the placeholders and are returned
+// unchanged.
+func dateLayout(format string) string {
+	return strings.NewReplacer(
+		"YYYY", "2006",
+		"YY", "06",
+		"MM", "01",
+		"DD", "02",
+		"HH", "15",
+		"mm", "04",
+		"ss", "05",
+	).Replace(format)
+}
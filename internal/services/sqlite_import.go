@@ -0,0 +1,352 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteHeader starts every SQLite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// ErrInvalidSQLiteSource is returned for uploads that are not SQLite
+// databases, and for tables or queries that cannot be imported from them.
+var ErrInvalidSQLiteSource = errors.New("invalid SQLite source")
+
+// SQLiteOptions selects what to import from a SQLite database. When neither
+// is set the database must hold exactly one table or view.
+type SQLiteOptions struct {
+	// Table names the table or view to import.
+	Table string
+	// Query is a SELECT statement whose result is imported instead.
+	Query string
+}
+
+// SQLiteTable describes a table or view of an uploaded SQLite database.
+type SQLiteTable struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Columns []SQLiteColumn `json:"columns"`
+}
+
+// SQLiteColumn is a column of a SQLite table with its declared type and the
+// dataset type it is imported as. DataType is empty when the type will be
+// inferred from the values.
+type SQLiteColumn struct {
+	Name         string `json:"name"`
+	DeclaredType string `json:"declared_type"`
+	DataType     string `json:"data_type"`
+}
+
+// ListSQLiteTables lists the tables and views of an uploaded SQLite
+// database, so one can be picked for import.
+func (s *DatasetService) ListSQLiteTables(ctx context.Context, file io.Reader) ([]SQLiteTable, error) {
+	db, cleanup, err := openSQLite(file)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return sqliteTables(ctx, db)
+}
+
+// importSQLite imports a table, a view or the result of a query from a
+// SQLite database. Columns are typed from their declared SQLite types, and
+// columns without one, such as computed columns, are inferred.
+func (s *DatasetService) importSQLite(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) (database.Dataset, error) {
+	db, cleanup, err := openSQLite(file)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+	defer cleanup()
+
+	query, source, err := sqliteSource(ctx, db, opts.SQLite)
+	if err != nil {
+		return database.Dataset{}, err
+	}
+
+	var total int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+query+")").Scan(&total); err != nil {
+		return database.Dataset{}, fmt.Errorf("%w: %v", ErrInvalidSQLiteSource, err)
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return database.Dataset{}, fmt.Errorf("%w: %v", ErrInvalidSQLiteSource, err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return database.Dataset{}, fmt.Errorf("failed to read SQLite columns: %w", err)
+	}
+	headers := make([]string, len(columnTypes))
+	fieldTypes := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		headers[i] = ct.Name()
+		fieldTypes[i] = sqliteDataType(ct.DatabaseTypeName())
+	}
+	headers = normalizeHeaders(headers)
+
+	name := fmt.Sprintf("%s - %s", filename, source)
+	dataset, err := s.createImportDataset(ctx, userID, name, fmt.Sprintf("Imported from SQLite %s", source), "", opts)
+	if err != nil {
+		logger.Logger.Printf("Error uploading SQLite database: %v", err)
+		return database.Dataset{}, err
+	}
+
+	ingest := opts.ingestOptions()
+	ingest.fieldTypes = fieldTypes
+	reader := &sqlRowReader{rows: rows, width: len(headers), total: total}
+	if err := s.ingestRows(ctx, dataset, headers, reader, ingest); err != nil {
+		return dataset, err
+	}
+	return dataset, nil
+}
+
+// openSQLite copies the upload to a temporary file, which SQLite needs, and
+// opens it read-only. cleanup closes the database and removes the copy.
+func openSQLite(file io.Reader) (*sql.DB, func(), error) {
+	spooled, err := os.CreateTemp("", "upload-*.sqlite")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to buffer database: %w", err)
+	}
+	removeSpooled := func() {
+		spooled.Close()
+		os.Remove(spooled.Name())
+	}
+
+	if _, err := io.Copy(spooled, file); err != nil {
+		removeSpooled()
+		return nil, nil, fmt.Errorf("failed to buffer database: %w", err)
+	}
+	header := make([]byte, len(sqliteHeader))
+	if _, err := spooled.ReadAt(header, 0); err != nil || !bytes.Equal(header, sqliteHeader) {
+		removeSpooled()
+		return nil, nil, fmt.Errorf("%w: file is not a SQLite database", ErrInvalidSQLiteSource)
+	}
+
+	// query_only refuses writes even if a query tries to make one
+	db, err := sql.Open("sqlite", "file:"+spooled.Name()+"?mode=ro&_pragma=query_only(1)")
+	if err != nil {
+		removeSpooled()
+		return nil, nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, func() {
+		db.Close()
+		removeSpooled()
+	}, nil
+}
+
+func sqliteTables(ctx context.Context, db *sql.DB) ([]SQLiteTable, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, type FROM sqlite_master
+		WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSQLiteSource, err)
+	}
+	tables := []SQLiteTable{}
+	for rows.Next() {
+		var t SQLiteTable
+		if err := rows.Scan(&t.Name, &t.Type); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to list SQLite tables: %w", err)
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list SQLite tables: %w", err)
+	}
+
+	for i := range tables {
+		cols, err := db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?)", tables[i].Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list columns of %s: %w", tables[i].Name, err)
+		}
+		tables[i].Columns = []SQLiteColumn{}
+		for cols.Next() {
+			var c SQLiteColumn
+			if err := cols.Scan(&c.Name, &c.DeclaredType); err != nil {
+				cols.Close()
+				return nil, fmt.Errorf("failed to list columns of %s: %w", tables[i].Name, err)
+			}
+			c.DataType = sqliteDataType(c.DeclaredType)
+			tables[i].Columns = append(tables[i].Columns, c)
+		}
+		cols.Close()
+		if err := cols.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list columns of %s: %w", tables[i].Name, err)
+		}
+	}
+	return tables, nil
+}
+
+// sqliteSource returns the query reading what opts selects, and a
+// description of it for the dataset name.
+func sqliteSource(ctx context.Context, db *sql.DB, opts SQLiteOptions) (string, string, error) {
+	if opts.Table != "" && opts.Query != "" {
+		return "", "", fmt.Errorf("%w: give a table or a query, not both", ErrInvalidSQLiteSource)
+	}
+	if query := strings.TrimSuffix(strings.TrimSpace(opts.Query), ";"); query != "" {
+		keyword := strings.ToUpper(strings.Fields(query)[0])
+		if keyword != "SELECT" && keyword != "WITH" {
+			return "", "", fmt.Errorf("%w: query must be a SELECT statement", ErrInvalidSQLiteSource)
+		}
+		return query, "query", nil
+	}
+
+	tables, err := sqliteTables(ctx, db)
+	if err != nil {
+		return "", "", err
+	}
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.Name
+	}
+
+	var table *SQLiteTable
+	switch {
+	case opts.Table != "":
+		for i := range tables {
+			if strings.EqualFold(tables[i].Name, opts.Table) {
+				table = &tables[i]
+				break
+			}
+		}
+		if table == nil {
+			return "", "", fmt.Errorf("%w: table %q not found, the database has %s", ErrInvalidSQLiteSource, opts.Table, strings.Join(names, ", "))
+		}
+	case len(tables) == 1:
+		table = &tables[0]
+	case len(tables) == 0:
+		return "", "", fmt.Errorf("%w: the database has no tables", ErrInvalidSQLiteSource)
+	default:
+		return "", "", fmt.Errorf("%w: choose a table from %s", ErrInvalidSQLiteSource, strings.Join(names, ", "))
+	}
+	return "SELECT * FROM " + quoteSQLiteIdent(table.Name), table.Name, nil
+}
+
+// sqliteDataType maps a declared SQLite column type onto a dataset type,
+// following SQLite's type affinity rules. Columns of NUMERIC affinity that
+// are declared as booleans or dates keep those types. Columns without a
+// declared type, whose affinity is BLOB, return "" to have their type
+// inferred, while declared blobs are imported as text.
+func sqliteDataType(declared string) string {
+	t := strings.ToUpper(declared)
+	switch {
+	case t == "":
+		return ""
+	case strings.Contains(t, "INT"):
+		return TypeInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"), strings.Contains(t, "BLOB"):
+		return TypeText
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return TypeFloat
+	case strings.Contains(t, "BOOL"):
+		return TypeBoolean
+	case strings.Contains(t, "DATE"), strings.Contains(t, "TIME"):
+		return TypeDatetime
+	}
+	return TypeFloat
+}
+
+func quoteSQLiteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlRowReader reads the rows of a database/sql query as text.
+type sqlRowReader struct {
+	rows  *sql.Rows
+	width int
+	// total is the number of rows, or zero when unknown
+	total int64
+	// limit, when positive, fails the read past that many rows
+	limit int64
+	read  int64
+}
+
+// Len reports the total number of rows, letting ingestion report progress.
+func (r *sqlRowReader) Len() int {
+	return int(r.total)
+}
+
+func (r *sqlRowReader) Read() ([]string, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		return nil, io.EOF
+	}
+	r.read++
+	if r.limit > 0 && r.read > r.limit {
+		return nil, fmt.Errorf("%w: more than %d", ErrSourceRowLimit, r.limit)
+	}
+
+	values := make([]interface{}, r.width)
+	ptrs := make([]interface{}, r.width)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := r.rows.Scan(ptrs...); err != nil {
+		return nil, fmt.Errorf("failed to read row: %w", err)
+	}
+
+	row := make([]string, r.width)
+	for i, v := range values {
+		row[i] = formatSQLValue(v)
+	}
+	return row, nil
+}
+
+// formatSQLValue renders a value scanned from a database the way it would
+// appear in a CSV export. Binary values are base64 encoded.
+func formatSQLValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		if v.Year() == 0 && v.YearDay() == 1 {
+			// A time of day without a date
+			return v.Format("15:04:05.999999999")
+		}
+		if h, m, sec := v.Clock(); h == 0 && m == 0 && sec == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+// Storage layouts for the values of dataset cells.
+const (
+	// StorageTyped stores each value as text alongside its number, when it
+	// is one, so numeric columns are read without parsing.
+	StorageTyped = "typed"
+	// StorageText stores values as text only and parses them when read.
+	StorageText = "text"
+)
+
+// ValueStore reads and writes the cells of datasets. Every layout keeps
+// the text of each value in record_values, so data written through one
+// layout can be read through the other.
+type ValueStore interface {
+	// InsertRecords stores new records along with their values.
+	InsertRecords(ctx context.Context, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error
+	// UpsertRecords stores new records and writes their values, overwriting
+	// values that already exist.
+	UpsertRecords(ctx context.Context, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error
+	// Rows returns every record of a dataset in row index order, with a
+	// column per field in fieldIDs. Missing values are "".
+	Rows(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID) ([][]string, error)
+	// RowsAfter returns up to limit records with a row index greater than
+	// after, in row index order, with a column per field in fieldIDs.
+	RowsAfter(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, after int64, limit int) ([]DatasetRow, error)
+	// NumericColumn returns the non-empty values of a field as numbers, in
+	// row index order, along with the row index of each. It fails if any of
+	// them is not a number.
+	NumericColumn(ctx context.Context, datasetID, fieldID uuid.UUID) ([]float64, []int64, error)
+}
+
+// values returns the store for the service's storage layout.
+func (s *DatasetService) values() ValueStore {
+	if s.Storage == StorageText {
+		return textStore{repo: s.Repo, copy: s.CopyIngest, changes: s.changes}
+	}
+	return typedStore{repo: s.Repo, copy: s.CopyIngest, changes: s.changes}
+}
+
+// typedStore keeps the number of every numeric value in
+// record_values.num_value.
+type typedStore struct {
+	repo    *database.Repository
+	copy    bool
+	changes *rowChanges
+}
+
+func (st typedStore) InsertRecords(ctx context.Context, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error {
+	setNumericValues(values)
+	st.changes.addRecords(records)
+	return writeRecords(ctx, st.repo, st.copy, false, records, values)
+}
+
+func (st typedStore) UpsertRecords(ctx context.Context, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error {
+	setNumericValues(values)
+	st.changes.addRecords(records)
+	return writeRecords(ctx, st.repo, st.copy, true, records, values)
+}
+
+// Rows reads the values already sorted by record, so each row is filled in
+// as its values arrive.
+func (st typedStore) Rows(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID) ([][]string, error) {
+	values, err := st.repo.Queries.GetDatasetValues(ctx, datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record values: %w", err)
+	}
+
+	columns := make(map[uuid.UUID]int, len(fieldIDs))
+	for i, id := range fieldIDs {
+		columns[id] = i
+	}
+
+	var (
+		rows   [][]string
+		row    []string
+		record uuid.UUID
+	)
+	for _, v := range values {
+		if row == nil || v.RecordID != record {
+			row = make([]string, len(fieldIDs))
+			rows = append(rows, row)
+			record = v.RecordID
+		}
+		if col, ok := columns[v.FieldID.UUID]; ok && v.FieldID.Valid && v.Value.Valid {
+			row[col] = v.Value.String
+		}
+	}
+	return rows, nil
+}
+
+func (st typedStore) RowsAfter(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, after int64, limit int) ([]DatasetRow, error) {
+	return readRowsAfter(ctx, st.repo, datasetID, fieldIDs, after, limit)
+}
+
+// NumericColumn reads num_value, falling back to parsing the text of values
+// that have none, such as those written through the text layout.
+func (st typedStore) NumericColumn(ctx context.Context, datasetID, fieldID uuid.UUID) ([]float64, []int64, error) {
+	values, err := st.repo.Queries.GetNumericValuesForField(ctx, fieldID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get column values: %w", err)
+	}
+
+	numbers := make([]float64, len(values))
+	rowIndexes := make([]int64, len(values))
+	for i, v := range values {
+		rowIndexes[i] = v.RowIndex
+		if v.NumValue.Valid {
+			numbers[i] = v.NumValue.Float64
+			continue
+		}
+		parsed, ok := parseStoredNumber(v.Value.String)
+		if !ok {
+			return nil, nil, fmt.Errorf("row %d: cannot parse '%s' as float64", v.RowIndex, v.Value.String)
+		}
+		numbers[i] = parsed
+	}
+	return numbers, rowIndexes, nil
+}
+
+// textStore is the original layout, with every value stored only as text.
+type textStore struct {
+	repo    *database.Repository
+	copy    bool
+	changes *rowChanges
+}
+
+func (st textStore) InsertRecords(ctx context.Context, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error {
+	clearNumericValues(values)
+	st.changes.addRecords(records)
+	return writeRecords(ctx, st.repo, st.copy, false, records, values)
+}
+
+func (st textStore) UpsertRecords(ctx context.Context, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error {
+	clearNumericValues(values)
+	st.changes.addRecords(records)
+	return writeRecords(ctx, st.repo, st.copy, true, records, values)
+}
+
+func (st textStore) Rows(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID) ([][]string, error) {
+	_, rows, err := st.indexedRows(ctx, datasetID, fieldIDs)
+	return rows, err
+}
+
+func (st textStore) RowsAfter(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, after int64, limit int) ([]DatasetRow, error) {
+	return readRowsAfter(ctx, st.repo, datasetID, fieldIDs, after, limit)
+}
+
+// indexedRows is Rows along with the row index of each row.
+func (st textStore) indexedRows(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID) ([]int64, [][]string, error) {
+	records, err := st.repo.Queries.GetRecordsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	fieldIndexMap := make(map[uuid.UUID]int, len(fieldIDs))
+	for i, id := range fieldIDs {
+		fieldIndexMap[id] = i
+	}
+
+	values, err := st.repo.Queries.GetRecordValuesByDatasetID(ctx, datasetID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get record values: %w", err)
+	}
+
+	// Build value map per record
+	recordValueMap := make(map[uuid.UUID]map[int]string)
+	for _, val := range values {
+		colIdx, ok := fieldIndexMap[val.FieldID]
+		if !ok || !val.Value.Valid {
+			continue
+		}
+		if _, exists := recordValueMap[val.RecordID]; !exists {
+			recordValueMap[val.RecordID] = make(map[int]string)
+		}
+		recordValueMap[val.RecordID][colIdx] = val.Value.String
+	}
+
+	// Reconstruct all rows
+	rowIndexes := make([]int64, len(records))
+	rows := make([][]string, len(records))
+	for r, record := range records {
+		row := make([]string, len(fieldIDs))
+		for i, v := range recordValueMap[record.ID] {
+			row[i] = v
+		}
+		rowIndexes[r] = record.RowIndex
+		rows[r] = row
+	}
+	return rowIndexes, rows, nil
+}
+
+func (st textStore) NumericColumn(ctx context.Context, datasetID, fieldID uuid.UUID) ([]float64, []int64, error) {
+	indexes, rows, err := st.indexedRows(ctx, datasetID, []uuid.UUID{fieldID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		numbers    []float64
+		rowIndexes []int64
+	)
+	for i, row := range rows {
+		raw := row[0]
+		if raw == "" {
+			continue
+		}
+		parsed, ok := parseStoredNumber(raw)
+		if !ok {
+			return nil, nil, fmt.Errorf("row %d: cannot parse '%s' as float64", indexes[i], raw)
+		}
+		numbers = append(numbers, parsed)
+		rowIndexes = append(rowIndexes, indexes[i])
+	}
+	return numbers, rowIndexes, nil
+}
+
+// writeRecords inserts records and then their values, with COPY when copy
+// is set and values are not being upserted, which COPY cannot do.
+func writeRecords(ctx context.Context, repo *database.Repository, copy, upsert bool, records []database.CreateDatasetRecordParams, values []database.CreateRecordValueParams) error {
+	if copy && !upsert {
+		return repo.CopyRecords(ctx, records, values)
+	}
+	for _, rec := range records {
+		if err := repo.Queries.CreateDatasetRecord(ctx, rec); err != nil {
+			return fmt.Errorf("failed to insert record: %w", err)
+		}
+	}
+	if upsert {
+		return repo.BatchUpsertRecordValues(ctx, values)
+	}
+	return repo.BatchInsertRecordValues(ctx, values)
+}
+
+func setNumericValues(values []database.CreateRecordValueParams) {
+	for i := range values {
+		values[i].NumValue = numericValue(values[i].Value)
+	}
+}
+
+func clearNumericValues(values []database.CreateRecordValueParams) {
+	for i := range values {
+		values[i].NumValue = sql.NullFloat64{}
+	}
+}
+
+// numericValue is the number a stored value holds, if it is a finite
+// number. NaN and infinities are left to be parsed from the text.
+func numericValue(v sql.NullString) sql.NullFloat64 {
+	if !v.Valid {
+		return sql.NullFloat64{}
+	}
+	f, ok := parseStoredNumber(v.String)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+// parseStoredNumber reads a stored value as a number, written any way
+// inference accepts, such as "1,000", "$5" or "45%", or as strconv reads
+// it, which covers NaN and infinities.
+func parseStoredNumber(val string) (float64, bool) {
+	if f, ok := parseNumber(val); ok {
+		return f, true
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	return f, err == nil
+}
@@ -0,0 +1,472 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+)
+
+// Upload job phases. Queued, parsing and inserting are in progress; the
+// rest are final.
+const (
+	JobPhaseQueued    = "queued"
+	JobPhaseParsing   = "parsing"
+	JobPhaseInserting = "inserting"
+	JobPhaseCompleted = "completed"
+	JobPhaseFailed    = "failed"
+	JobPhaseCancelled = "cancelled"
+)
+
+// A running job refreshes its heartbeat every jobHeartbeatInterval. Jobs
+// whose heartbeat is older than jobStaleAfter are taken to have been left
+// behind by a process that stopped.
+const (
+	jobHeartbeatInterval = 30 * time.Second
+	jobStaleAfter        = 4 * jobHeartbeatInterval
+)
+
+var (
+	ErrUploadJobNotFound = errors.New("upload job not found")
+	ErrUploadJobFinished = errors.New("upload job has already finished")
+)
+
+// UploadJobStatus is an upload job together with the datasets it created and
+// an estimate of the time left, which is nil when it cannot be estimated.
+type UploadJobStatus struct {
+	Job        database.UploadJob
+	DatasetIDs []uuid.UUID
+	ETA        *time.Duration
+}
+
+// StartUploadJob stages the upload in UploadDir and ingests it in the
+// background, returning the queued job straight away. The datasets it
+// creates stay hidden until the job completes.
+func (s *DatasetService) StartUploadJob(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) (database.UploadJob, error) {
+	jobID := uuid.New()
+
+	if err := os.MkdirAll(s.UploadDir, 0755); err != nil {
+		return database.UploadJob{}, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	stagedPath := filepath.Join(s.UploadDir, jobID.String()+filepath.Ext(filename))
+	size, err := stageFile(stagedPath, file)
+	if err != nil {
+		return database.UploadJob{}, err
+	}
+	return s.startStagedUploadJob(ctx, jobID, userID, filename, stagedPath, size, opts)
+}
+
+// startStagedUploadJob queues a job for a file already staged in UploadDir.
+// The staged file is removed if the job cannot be created.
+func (s *DatasetService) startStagedUploadJob(
+	ctx context.Context,
+	jobID, userID uuid.UUID,
+	filename, stagedPath string,
+	size int64,
+	opts ImportOptions,
+) (database.UploadJob, error) {
+	job, err := s.Repo.Queries.CreateUploadJob(ctx, database.CreateUploadJobParams{
+		ID:         jobID,
+		UserID:     userID,
+		Filename:   filename,
+		Phase:      JobPhaseQueued,
+		BytesTotal: size,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		os.Remove(stagedPath)
+		return database.UploadJob{}, fmt.Errorf("failed to create upload job: %w", err)
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	s.trackUploadJob(job.ID, cancel)
+
+	go s.runUploadJob(jobCtx, job, stagedPath, opts)
+
+	return job, nil
+}
+
+func stageFile(path string, file io.Reader) (int64, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stage upload: %w", err)
+	}
+	size, err := io.Copy(out, file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to stage upload: %w", err)
+	}
+	return size, nil
+}
+
+// uploadFunc performs the import behind an upload job.
+type uploadFunc func(ctx context.Context, file io.Reader, opts ImportOptions) ([]database.Dataset, error)
+
+// ImportUpload imports a file straight away, recording the import as an
+// upload job so its progress and rejected rows can be looked up like those of
+// a background upload. The returned status is that of the finished job.
+func (s *DatasetService) ImportUpload(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) (UploadJobStatus, []database.Dataset, error) {
+	return s.runUpload(ctx, userID, filename, file, opts, func(ctx context.Context, file io.Reader, opts ImportOptions) ([]database.Dataset, error) {
+		return s.ImportFile(ctx, userID, filename, file, opts)
+	})
+}
+
+// AppendUpload appends a file to a dataset, recording it as an upload job
+// like ImportUpload does.
+func (s *DatasetService) AppendUpload(
+	ctx context.Context,
+	userID, datasetID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts AppendOptions,
+) (UploadJobStatus, AppendResult, error) {
+	var result AppendResult
+	status, _, err := s.runUpload(ctx, userID, filename, file, opts.ImportOptions, func(ctx context.Context, file io.Reader, importOpts ImportOptions) ([]database.Dataset, error) {
+		opts.ImportOptions = importOpts
+		var err error
+		result, err = s.AppendToDataset(ctx, userID, datasetID, file, opts)
+		return nil, err
+	})
+	return status, result, err
+}
+
+func (s *DatasetService) runUpload(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+	upload uploadFunc,
+) (UploadJobStatus, []database.Dataset, error) {
+	job, err := s.Repo.Queries.CreateUploadJob(ctx, database.CreateUploadJobParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Filename:  filename,
+		Phase:     JobPhaseQueued,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return UploadJobStatus{}, nil, fmt.Errorf("failed to create upload job: %w", err)
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	s.trackUploadJob(job.ID, cancel)
+
+	datasets, importErr := s.executeUploadJob(jobCtx, job, file, opts, upload)
+
+	status, err := s.GetUploadJob(context.WithoutCancel(ctx), job.ID, userID)
+	if err != nil {
+		status = UploadJobStatus{Job: job}
+	}
+	return status, datasets, importErr
+}
+
+func (s *DatasetService) trackUploadJob(jobID uuid.UUID, cancel context.CancelFunc) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if s.jobCancels == nil {
+		s.jobCancels = make(map[uuid.UUID]context.CancelFunc)
+	}
+	s.jobCancels[jobID] = cancel
+}
+
+func (s *DatasetService) runUploadJob(ctx context.Context, job database.UploadJob, stagedPath string, opts ImportOptions) {
+	defer os.Remove(stagedPath)
+
+	file, err := os.Open(stagedPath)
+	if err != nil {
+		s.untrackUploadJob(job.ID)
+		s.finishUploadJob(job.ID, fmt.Errorf("failed to open staged upload: %w", err), false)
+		return
+	}
+	defer file.Close()
+
+	_, _ = s.executeUploadJob(ctx, job, file, opts, func(ctx context.Context, file io.Reader, opts ImportOptions) ([]database.Dataset, error) {
+		return s.ImportFile(ctx, job.UserID, job.Filename, file, opts)
+	})
+}
+
+func (s *DatasetService) untrackUploadJob(jobID uuid.UUID) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if cancel, ok := s.jobCancels[jobID]; ok {
+		cancel()
+		delete(s.jobCancels, jobID)
+	}
+}
+
+// executeUploadJob imports file on behalf of a tracked job, keeping the job's
+// progress up to date and recording its outcome.
+func (s *DatasetService) executeUploadJob(ctx context.Context, job database.UploadJob, file io.Reader, opts ImportOptions, upload uploadFunc) ([]database.Dataset, error) {
+	defer s.untrackUploadJob(job.ID)
+
+	// Job bookkeeping must still be written after the job is cancelled
+	bg := context.WithoutCancel(ctx)
+
+	err := s.Repo.Queries.StartUploadJob(bg, database.StartUploadJobParams{
+		ID:        job.ID,
+		Phase:     JobPhaseParsing,
+		StartedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		logger.Logger.Printf("Failed to start upload job %s: %v", job.ID, err)
+	}
+	stopHeartbeat := s.heartbeat(bg, job.ID, func() { s.untrackUploadJob(job.ID) })
+	defer stopHeartbeat()
+
+	counter := &countingReader{r: file}
+
+	// Several datasets may be created by one job, so progress is summed
+	var progressMu sync.Mutex
+	perDataset := make(map[uuid.UUID]IngestProgress)
+	opts.UploadJobID = uuid.NullUUID{UUID: job.ID, Valid: true}
+	opts.Progress = func(p IngestProgress) {
+		progressMu.Lock()
+		perDataset[p.DatasetID] = p
+		var total IngestProgress
+		for _, dp := range perDataset {
+			total.RowsProcessed += dp.RowsProcessed
+			total.RowsRejected += dp.RowsRejected
+			total.RowsTotal += dp.RowsTotal
+		}
+		progressMu.Unlock()
+
+		err := s.Repo.Queries.UpdateUploadJobProgress(bg, database.UpdateUploadJobProgressParams{
+			ID:            job.ID,
+			Phase:         JobPhaseInserting,
+			RowsProcessed: total.RowsProcessed,
+			RowsRejected:  total.RowsRejected,
+			RowsTotal:     total.RowsTotal,
+			BytesRead:     counter.Count(),
+		})
+		if err != nil {
+			logger.Logger.Printf("Failed to update upload job %s: %v", job.ID, err)
+		}
+	}
+
+	datasets, err := upload(ctx, counter, opts)
+	s.finishUploadJob(job.ID, err, ctx.Err() != nil)
+	return datasets, err
+}
+
+// heartbeat refreshes the heartbeat of a running job until the returned
+// function is called, so that other instances do not recover the job. It
+// calls cancel once another instance has asked for the job to be cancelled.
+func (s *DatasetService) heartbeat(ctx context.Context, jobID uuid.UUID, cancel func()) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				cancelRequested, err := s.Repo.Queries.HeartbeatUploadJob(ctx, database.HeartbeatUploadJobParams{
+					ID:          jobID,
+					HeartbeatAt: sql.NullTime{Time: now, Valid: true},
+				})
+				if err != nil {
+					logger.Logger.Printf("Failed to update heartbeat of upload job %s: %v", jobID, err)
+				}
+				if cancelRequested {
+					cancel()
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// finishUploadJob records the outcome of a job. Successful jobs publish their
+// datasets; failed or cancelled jobs remove them.
+func (s *DatasetService) finishUploadJob(jobID uuid.UUID, jobErr error, cancelled bool) {
+	bg := context.Background()
+	jobRef := uuid.NullUUID{UUID: jobID, Valid: true}
+
+	phase := JobPhaseCompleted
+	var errMsg sql.NullString
+	switch {
+	case cancelled:
+		phase = JobPhaseCancelled
+	case jobErr != nil:
+		phase = JobPhaseFailed
+		errMsg = sql.NullString{String: jobErr.Error(), Valid: true}
+	}
+
+	if phase == JobPhaseCompleted {
+		err := s.Repo.Queries.PublishUploadJobDatasets(bg, database.PublishUploadJobDatasetsParams{
+			UploadJobID: jobRef,
+			UpdatedAt:   time.Now(),
+		})
+		if err != nil {
+			phase = JobPhaseFailed
+			errMsg = sql.NullString{String: fmt.Sprintf("failed to publish datasets: %v", err), Valid: true}
+		}
+	}
+	if phase != JobPhaseCompleted {
+		if err := s.Repo.Queries.DeletePendingUploadJobDatasets(bg, jobRef); err != nil {
+			logger.Logger.Printf("Failed to remove datasets of upload job %s: %v", jobID, err)
+		}
+	}
+
+	err := s.Repo.Queries.FinishUploadJob(bg, database.FinishUploadJobParams{
+		ID:         jobID,
+		Phase:      phase,
+		Error:      errMsg,
+		FinishedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		logger.Logger.Printf("Failed to finish upload job %s: %v", jobID, err)
+	}
+}
+
+// GetUploadJob returns the status of one of the user's upload jobs.
+func (s *DatasetService) GetUploadJob(ctx context.Context, jobID, userID uuid.UUID) (UploadJobStatus, error) {
+	job, err := s.Repo.Queries.GetUploadJob(ctx, jobID)
+	if err != nil || job.UserID != userID {
+		return UploadJobStatus{}, ErrUploadJobNotFound
+	}
+
+	datasets, err := s.Repo.Queries.GetDatasetsByUploadJob(ctx, uuid.NullUUID{UUID: job.ID, Valid: true})
+	if err != nil {
+		return UploadJobStatus{}, fmt.Errorf("failed to get job datasets: %w", err)
+	}
+
+	status := UploadJobStatus{Job: job, DatasetIDs: []uuid.UUID{}}
+	for _, d := range datasets {
+		status.DatasetIDs = append(status.DatasetIDs, d.ID)
+	}
+	status.ETA = estimateRemaining(job, time.Now())
+
+	return status, nil
+}
+
+// estimateRemaining extrapolates the elapsed time of a running job. Rows are
+// used when the total is known, otherwise the share of the file read so far.
+func estimateRemaining(job database.UploadJob, now time.Time) *time.Duration {
+	if !isJobActive(job.Phase) || !job.StartedAt.Valid {
+		return nil
+	}
+
+	var fraction float64
+	switch {
+	case job.RowsTotal > 0:
+		fraction = float64(job.RowsProcessed+job.RowsRejected) / float64(job.RowsTotal)
+	case job.BytesTotal > 0:
+		fraction = float64(job.BytesRead) / float64(job.BytesTotal)
+	}
+	if fraction <= 0 || fraction > 1 {
+		return nil
+	}
+
+	elapsed := now.Sub(job.StartedAt.Time)
+	remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+	return &remaining
+}
+
+func isJobActive(phase string) bool {
+	return phase == JobPhaseQueued || phase == JobPhaseParsing || phase == JobPhaseInserting
+}
+
+// CancelUploadJob stops a running upload job. Its datasets are discarded.
+// A job running in another instance is only asked to stop, which it does
+// at its next heartbeat, unless its heartbeat is stale and it was left
+// behind by a process that stopped.
+func (s *DatasetService) CancelUploadJob(ctx context.Context, jobID, userID uuid.UUID) error {
+	job, err := s.Repo.Queries.GetUploadJob(ctx, jobID)
+	if err != nil || job.UserID != userID {
+		return ErrUploadJobNotFound
+	}
+	if !isJobActive(job.Phase) {
+		return ErrUploadJobFinished
+	}
+
+	s.jobsMu.Lock()
+	cancel, ok := s.jobCancels[jobID]
+	s.jobsMu.Unlock()
+	if !ok {
+		lastSeen := job.CreatedAt
+		if job.HeartbeatAt.Valid {
+			lastSeen = job.HeartbeatAt.Time
+		}
+		if time.Since(lastSeen) > jobStaleAfter {
+			// Nothing else will finish it
+			s.finishUploadJob(jobID, nil, true)
+			return nil
+		}
+		if err := s.Repo.Queries.RequestUploadJobCancel(ctx, jobID); err != nil {
+			return fmt.Errorf("failed to cancel upload job: %w", err)
+		}
+		return nil
+	}
+
+	cancel()
+	return nil
+}
+
+// RecoverUploadJobs marks jobs left running by a process that stopped as
+// failed and removes their unpublished datasets. It is meant to run at
+// startup. Only jobs whose heartbeat is stale are recovered, so jobs that
+// other instances are still running are left alone.
+func (s *DatasetService) RecoverUploadJobs(ctx context.Context) error {
+	now := time.Now()
+	err := s.Repo.Queries.FailInterruptedUploadJobs(ctx, database.FailInterruptedUploadJobsParams{
+		FinishedAt:  sql.NullTime{Time: now, Valid: true},
+		HeartbeatAt: now.Add(-jobStaleAfter),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark interrupted upload jobs: %w", err)
+	}
+	if err := s.Repo.Queries.DeleteOrphanedPendingDatasets(ctx); err != nil {
+		return fmt.Errorf("failed to remove unpublished datasets: %w", err)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it so far.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReader) Count() int64 {
+	return c.n.Load()
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultChunkSize is the chunk size of sessions created without one.
+	DefaultChunkSize = 8 << 20
+	// MaxChunkSize caps the chunk size a session may be created with.
+	MaxChunkSize = 64 << 20
+
+	// maxUploadChunks caps the number of chunks in a session, so tiny chunks
+	// cannot be used to fill the staging directory with files.
+	maxUploadChunks = 10000
+	// uploadSessionTTL is how long a session can be resumed after it was
+	// created.
+	uploadSessionTTL = 24 * time.Hour
+
+	chunkFileExt = ".chunk"
+)
+
+var (
+	ErrUploadSessionNotFound  = errors.New("upload session not found")
+	ErrUploadSessionFinalized = errors.New("upload session has already been finalized")
+	ErrInvalidUploadSession   = errors.New("invalid upload session")
+	ErrInvalidChunk           = errors.New("invalid chunk")
+	ErrChecksumMismatch       = errors.New("checksum mismatch")
+	ErrUploadIncomplete       = errors.New("upload is incomplete")
+)
+
+// UploadSessionStatus is a resumable upload together with the chunks
+// received so far. Offset is the number of bytes received without a gap from
+// the start of the file, which is where a sequential client resumes.
+type UploadSessionStatus struct {
+	Session       database.UploadSession
+	Chunks        int64
+	Received      []int64
+	Missing       []int64
+	BytesReceived int64
+	Offset        int64
+}
+
+// CreateUploadSession starts a resumable upload of a file of the given size,
+// to be sent in chunks of chunkSize bytes numbered from 0. Every chunk but the
+// last is exactly chunkSize bytes. checksum, when given, is the hex SHA-256 of
+// the whole file and is verified when the session is finalized.
+func (s *DatasetService) CreateUploadSession(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	size, chunkSize int64,
+	checksum string,
+) (database.UploadSession, error) {
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	switch {
+	case strings.TrimSpace(filename) == "":
+		return database.UploadSession{}, fmt.Errorf("%w: filename is required", ErrInvalidUploadSession)
+	case size <= 0:
+		return database.UploadSession{}, fmt.Errorf("%w: size must be positive", ErrInvalidUploadSession)
+	case chunkSize < 0 || chunkSize > MaxChunkSize:
+		return database.UploadSession{}, fmt.Errorf("%w: chunk_size must be between 1 and %d", ErrInvalidUploadSession, MaxChunkSize)
+	case chunkCount(size, chunkSize) > maxUploadChunks:
+		return database.UploadSession{}, fmt.Errorf("%w: more than %d chunks, use a larger chunk_size", ErrInvalidUploadSession, maxUploadChunks)
+	}
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	if checksum != "" && !isSHA256(checksum) {
+		return database.UploadSession{}, fmt.Errorf("%w: checksum must be a hex SHA-256 digest", ErrInvalidUploadSession)
+	}
+
+	sessionID := uuid.New()
+	if err := os.MkdirAll(s.uploadSessionDir(sessionID), 0755); err != nil {
+		return database.UploadSession{}, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	now := time.Now()
+	session, err := s.Repo.Queries.CreateUploadSession(ctx, database.CreateUploadSessionParams{
+		ID:        sessionID,
+		UserID:    userID,
+		Filename:  filepath.Base(filename),
+		Size:      size,
+		ChunkSize: chunkSize,
+		Checksum:  sql.NullString{String: checksum, Valid: checksum != ""},
+		CreatedAt: now,
+		ExpiresAt: now.Add(uploadSessionTTL),
+	})
+	if err != nil {
+		os.RemoveAll(s.uploadSessionDir(sessionID))
+		return database.UploadSession{}, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	return session, nil
+}
+
+// PutUploadChunk stores chunk index of a session. The chunk must have the
+// expected length and match checksum, the hex SHA-256 of its bytes. Sending a
+// chunk again replaces it, so a chunk whose response was lost can simply be
+// retried. It returns the number of bytes stored.
+func (s *DatasetService) PutUploadChunk(
+	ctx context.Context,
+	sessionID, userID uuid.UUID,
+	index int64,
+	chunk io.Reader,
+	checksum string,
+) (int64, error) {
+	session, err := s.loadUploadSession(ctx, sessionID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if session.FinalizedAt.Valid {
+		return 0, ErrUploadSessionFinalized
+	}
+
+	chunks := chunkCount(session.Size, session.ChunkSize)
+	if index < 0 || index >= chunks {
+		return 0, fmt.Errorf("%w: chunk must be between 0 and %d", ErrInvalidChunk, chunks-1)
+	}
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	if !isSHA256(checksum) {
+		return 0, fmt.Errorf("%w: a hex SHA-256 checksum is required", ErrInvalidChunk)
+	}
+	expected := min(session.ChunkSize, session.Size-index*session.ChunkSize)
+
+	dir := s.uploadSessionDir(sessionID)
+	tmp, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to stage chunk: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(chunk, expected+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to stage chunk: %w", err)
+	}
+	if n != expected {
+		return 0, fmt.Errorf("%w: chunk %d must be %d bytes", ErrInvalidChunk, index, expected)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return 0, fmt.Errorf("%w: chunk %d", ErrChecksumMismatch, index)
+	}
+
+	// Renaming makes the chunk appear complete or not at all
+	if err := os.Rename(tmp.Name(), s.chunkPath(sessionID, index)); err != nil {
+		return 0, fmt.Errorf("failed to stage chunk: %w", err)
+	}
+	return n, nil
+}
+
+// GetUploadSession returns the status of one of the user's upload sessions.
+func (s *DatasetService) GetUploadSession(ctx context.Context, sessionID, userID uuid.UUID) (UploadSessionStatus, error) {
+	session, err := s.loadUploadSession(ctx, sessionID, userID)
+	if err != nil {
+		return UploadSessionStatus{}, err
+	}
+	return s.uploadSessionStatus(session)
+}
+
+// FinalizeUploadSession assembles the chunks of a session in order and
+// ingests the file in the background, like StartUploadJob. Every chunk must
+// have been received, and the file must match the session's checksum if it
+// has one. A file the user has uploaded before is refused with a
+// DuplicateUploadError unless force is set. The session can be finalized
+// again if this fails.
+func (s *DatasetService) FinalizeUploadSession(
+	ctx context.Context,
+	sessionID, userID uuid.UUID,
+	opts ImportOptions,
+	force bool,
+) (database.UploadJob, error) {
+	session, err := s.loadUploadSession(ctx, sessionID, userID)
+	if err != nil {
+		return database.UploadJob{}, err
+	}
+	if session.FinalizedAt.Valid {
+		return database.UploadJob{}, ErrUploadSessionFinalized
+	}
+	status, err := s.uploadSessionStatus(session)
+	if err != nil {
+		return database.UploadJob{}, err
+	}
+	if len(status.Missing) > 0 {
+		return database.UploadJob{}, fmt.Errorf("%w: %d of %d chunks missing", ErrUploadIncomplete, len(status.Missing), status.Chunks)
+	}
+
+	// Claiming the session stops a concurrent finalize from starting a
+	// second job for the same file
+	claimed, err := s.Repo.Queries.ClaimUploadSession(ctx, database.ClaimUploadSessionParams{
+		ID:          sessionID,
+		FinalizedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		return database.UploadJob{}, fmt.Errorf("failed to finalize upload session: %w", err)
+	}
+	if claimed == 0 {
+		return database.UploadJob{}, ErrUploadSessionFinalized
+	}
+
+	job, err := s.startUploadSessionJob(ctx, session, opts, force)
+	if err != nil {
+		if releaseErr := s.Repo.Queries.ReleaseUploadSession(context.WithoutCancel(ctx), sessionID); releaseErr != nil {
+			logger.Logger.Printf("Failed to release upload session %s: %v", sessionID, releaseErr)
+		}
+		return database.UploadJob{}, err
+	}
+
+	err = s.Repo.Queries.SetUploadSessionJob(ctx, database.SetUploadSessionJobParams{
+		ID:          sessionID,
+		UploadJobID: uuid.NullUUID{UUID: job.ID, Valid: true},
+	})
+	if err != nil {
+		logger.Logger.Printf("Failed to record job of upload session %s: %v", sessionID, err)
+	}
+	if err := os.RemoveAll(s.uploadSessionDir(sessionID)); err != nil {
+		logger.Logger.Printf("Failed to remove chunks of upload session %s: %v", sessionID, err)
+	}
+	return job, nil
+}
+
+func (s *DatasetService) startUploadSessionJob(ctx context.Context, session database.UploadSession, opts ImportOptions, force bool) (database.UploadJob, error) {
+	jobID := uuid.New()
+	stagedPath := filepath.Join(s.UploadDir, jobID.String()+filepath.Ext(session.Filename))
+	contentHash, err := s.assembleChunks(session, stagedPath)
+	if err != nil {
+		return database.UploadJob{}, err
+	}
+	opts.ContentHash = contentHash
+
+	if !force {
+		duplicates, err := s.FindDuplicateUploads(ctx, session.UserID, contentHash)
+		if err == nil && len(duplicates) > 0 {
+			err = &DuplicateUploadError{Datasets: duplicates}
+		}
+		if err != nil {
+			os.Remove(stagedPath)
+			return database.UploadJob{}, err
+		}
+	}
+	return s.startStagedUploadJob(ctx, jobID, session.UserID, session.Filename, stagedPath, session.Size, opts)
+}
+
+// assembleChunks writes the chunks of a session to path in order, checking
+// the file against the session's checksum. It returns the hex SHA-256 of the
+// file.
+func (s *DatasetService) assembleChunks(session database.UploadSession, path string) (string, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to assemble upload: %w", err)
+	}
+
+	hash := sha256.New()
+	w := io.MultiWriter(out, hash)
+	err = func() error {
+		for i := range chunkCount(session.Size, session.ChunkSize) {
+			chunk, err := os.Open(s.chunkPath(session.ID, i))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, chunk)
+			chunk.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to assemble upload: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if session.Checksum.Valid && sum != session.Checksum.String {
+		os.Remove(path)
+		return "", fmt.Errorf("%w: the assembled file does not match the session checksum", ErrChecksumMismatch)
+	}
+	return sum, nil
+}
+
+// CancelUploadSession deletes a session and the chunks received for it.
+func (s *DatasetService) CancelUploadSession(ctx context.Context, sessionID, userID uuid.UUID) error {
+	if _, err := s.loadUploadSession(ctx, sessionID, userID); err != nil {
+		return err
+	}
+	if err := s.Repo.Queries.DeleteUploadSession(ctx, sessionID); err != nil {
+		return fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	if err := os.RemoveAll(s.uploadSessionDir(sessionID)); err != nil {
+		return fmt.Errorf("failed to remove chunks: %w", err)
+	}
+	return nil
+}
+
+// CleanupUploadSessions deletes expired sessions and their chunks. It is
+// meant to run at startup.
+func (s *DatasetService) CleanupUploadSessions(ctx context.Context) error {
+	ids, err := s.Repo.Queries.DeleteExpiredUploadSessions(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to delete expired upload sessions: %w", err)
+	}
+	for _, id := range ids {
+		if err := os.RemoveAll(s.uploadSessionDir(id)); err != nil {
+			logger.Logger.Printf("Failed to remove chunks of upload session %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func (s *DatasetService) loadUploadSession(ctx context.Context, sessionID, userID uuid.UUID) (database.UploadSession, error) {
+	session, err := s.Repo.Queries.GetUploadSession(ctx, sessionID)
+	if err != nil || session.UserID != userID || time.Now().After(session.ExpiresAt) {
+		return database.UploadSession{}, ErrUploadSessionNotFound
+	}
+	return session, nil
+}
+
+// uploadSessionStatus lists the chunk files of a session. Chunks are only
+// renamed into place once verified, so every one found is complete.
+func (s *DatasetService) uploadSessionStatus(session database.UploadSession) (UploadSessionStatus, error) {
+	status := UploadSessionStatus{
+		Session:  session,
+		Chunks:   chunkCount(session.Size, session.ChunkSize),
+		Received: []int64{},
+		Missing:  []int64{},
+	}
+	if session.FinalizedAt.Valid {
+		// The chunks have been assembled and removed
+		for i := range status.Chunks {
+			status.Received = append(status.Received, i)
+		}
+		status.BytesReceived, status.Offset = session.Size, session.Size
+		return status, nil
+	}
+
+	entries, err := os.ReadDir(s.uploadSessionDir(session.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return UploadSessionStatus{}, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	have := make(map[int64]bool, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), chunkFileExt)
+		if !ok {
+			continue
+		}
+		if i, err := strconv.ParseInt(name, 10, 64); err == nil && i >= 0 && i < status.Chunks {
+			have[i] = true
+		}
+	}
+
+	contiguous := true
+	for i := range status.Chunks {
+		if !have[i] {
+			status.Missing = append(status.Missing, i)
+			contiguous = false
+			continue
+		}
+		size := min(session.ChunkSize, session.Size-i*session.ChunkSize)
+		status.Received = append(status.Received, i)
+		status.BytesReceived += size
+		if contiguous {
+			status.Offset += size
+		}
+	}
+	return status, nil
+}
+
+func (s *DatasetService) uploadSessionDir(sessionID uuid.UUID) string {
+	return filepath.Join(s.UploadDir, "sessions", sessionID.String())
+}
+
+func (s *DatasetService) chunkPath(sessionID uuid.UUID, index int64) string {
+	return filepath.Join(s.uploadSessionDir(sessionID), strconv.FormatInt(index, 10)+chunkFileExt)
+}
+
+func chunkCount(size, chunkSize int64) int64 {
+	return (size + chunkSize - 1) / chunkSize
+}
+
+func isSHA256(checksum string) bool {
+	b, err := hex.DecodeString(checksum)
+	return err == nil && len(b) == sha256.Size
+}
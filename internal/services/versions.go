@@ -0,0 +1,572 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/google/uuid"
+)
+
+var ErrVersionNotFound = errors.New("dataset version not found")
+
+// DefaultMaxVersions is the number of versions kept of each dataset when
+// none is configured.
+const DefaultMaxVersions = 50
+
+// initialVersionOperation describes the version holding a dataset as it was
+// before its first recorded change.
+const initialVersionOperation = "initial state"
+
+// DatasetVersion describes a dataset as it was after a change to its columns
+// or rows. Versions never change once recorded.
+type DatasetVersion struct {
+	ID        uuid.UUID     `json:"id"`
+	DatasetID uuid.UUID     `json:"dataset_id"`
+	Version   int32         `json:"version"`
+	AuthorID  uuid.NullUUID `json:"author_id"`
+	Operation string        `json:"operation"`
+	CreatedAt time.Time     `json:"created_at"`
+	RowCount  int64         `json:"row_count"`
+	Columns   []string      `json:"columns"`
+}
+
+type versionKey struct{}
+
+// WithVersion returns a context in which datasets are read as they were in
+// the version with the given ID instead of as they are now. Only reads for
+// analytics honour it: GetDatasetRows, GetNumericColumn and the statistics
+// built on them.
+func WithVersion(ctx context.Context, versionID uuid.UUID) context.Context {
+	return context.WithValue(ctx, versionKey{}, versionID)
+}
+
+func versionFromContext(ctx context.Context) (uuid.UUID, bool) {
+	versionID, ok := ctx.Value(versionKey{}).(uuid.UUID)
+	return versionID, ok && versionID != uuid.Nil
+}
+
+// ListDatasetVersions returns the versions of a dataset, oldest first. A
+// dataset that was never changed has none.
+func (s *DatasetService) ListDatasetVersions(ctx context.Context, datasetID uuid.UUID) ([]DatasetVersion, error) {
+	rows, err := s.Repo.Queries.ListDatasetVersions(ctx, datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dataset versions: %w", err)
+	}
+	versions := make([]DatasetVersion, len(rows))
+	for i, row := range rows {
+		versions[i] = DatasetVersion(row)
+	}
+	return versions, nil
+}
+
+// GetDatasetVersion returns a version of a dataset by its number.
+func (s *DatasetService) GetDatasetVersion(ctx context.Context, datasetID uuid.UUID, number int32) (DatasetVersion, error) {
+	row, err := s.Repo.Queries.GetDatasetVersion(ctx, database.GetDatasetVersionParams{
+		DatasetID: datasetID,
+		Version:   number,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return DatasetVersion{}, fmt.Errorf("%w: version %d", ErrVersionNotFound, number)
+	}
+	if err != nil {
+		return DatasetVersion{}, fmt.Errorf("failed to get dataset version: %w", err)
+	}
+	return DatasetVersion(row), nil
+}
+
+// RollbackDataset restores the columns and rows of a dataset to those of an
+// earlier version. The rollback is recorded as a new version, so the
+// versions after the one restored are kept and can be restored in turn.
+func (s *DatasetService) RollbackDataset(ctx context.Context, datasetID, userID uuid.UUID, number int32) (DatasetVersion, error) {
+	target, err := s.GetDatasetVersion(ctx, datasetID, number)
+	if err != nil {
+		return DatasetVersion{}, err
+	}
+
+	version, err := s.versioned(ctx, datasetID, userID, func(tx *DatasetService) (string, error) {
+		if err := tx.clearDataset(ctx, datasetID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rollback to version %d", number), tx.restoreVersion(ctx, datasetID, target.ID)
+	})
+	s.Cache.Invalidate(datasetID)
+	return version, err
+}
+
+// versioned runs change, which modifies a dataset, in a transaction and then
+// records the dataset as a new version by authorID. change returns a
+// description of what it did. The first change to a dataset also records
+// the dataset as it was before, as version 1, so it can be rolled back to.
+// Versions beyond MaxVersions are pruned, oldest first.
+func (s *DatasetService) versioned(ctx context.Context, datasetID, authorID uuid.UUID, change func(tx *DatasetService) (string, error)) (DatasetVersion, error) {
+	var version DatasetVersion
+	err := s.Repo.InTx(ctx, func(txRepo *database.Repository) error {
+		tx := s.withRepo(txRepo)
+		tx.changes = &rowChanges{}
+
+		// Changes to a dataset are numbered one at a time
+		dataset, err := tx.Repo.Queries.LockDataset(ctx, datasetID)
+		if err != nil {
+			return fmt.Errorf("error fetching dataset: %w", err)
+		}
+		latest, err := tx.Repo.Queries.GetLatestDatasetVersion(ctx, datasetID)
+		if err != nil {
+			return fmt.Errorf("failed to get dataset version: %w", err)
+		}
+		if latest == 0 {
+			latest = 1
+			tx.changes.addAll()
+			if _, err := tx.recordVersion(ctx, datasetID, latest, dataset.UserID, initialVersionOperation, dataset.UpdatedAt); err != nil {
+				return err
+			}
+			tx.changes = &rowChanges{}
+		}
+
+		operation, err := change(tx)
+		if err != nil {
+			return err
+		}
+		version, err = tx.recordVersion(ctx, datasetID, latest+1, authorID, operation, time.Now())
+		if err != nil {
+			return err
+		}
+		return tx.pruneVersions(ctx, datasetID, version.Version)
+	})
+	if err != nil {
+		return DatasetVersion{}, err
+	}
+	return version, nil
+}
+
+// recordVersion records the dataset as it is now as version number. Rows are
+// stored copy-on-write: only the rows the change wrote or deleted, as
+// collected in s.changes, are compared with the previous version, in
+// PostgreSQL, and those that differ get a new row version.
+func (s *DatasetService) recordVersion(ctx context.Context, datasetID uuid.UUID, number int32, authorID uuid.UUID, operation string, createdAt time.Time) (DatasetVersion, error) {
+	fields, err := s.Repo.Queries.GetFieldsByDatasetID(ctx, datasetID)
+	if err != nil {
+		return DatasetVersion{}, fmt.Errorf("failed to get fields: %w", err)
+	}
+	columns := make([]string, len(fields))
+	stored := make([]versionField, len(fields))
+	for i, f := range fields {
+		columns[i] = f.Name
+		stored[i] = versionField{
+			ID:                  f.ID,
+			Name:                f.Name,
+			DataType:            f.DataType,
+			Description:         f.Description.String,
+			CreatedAt:           f.CreatedAt,
+			TypeCounterexamples: f.TypeCounterexamples,
+			DateFormat:          f.DateFormat.String,
+		}
+		if f.TypeConfidence.Valid {
+			stored[i].TypeConfidence = &f.TypeConfidence.Float64
+		}
+	}
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return DatasetVersion{}, fmt.Errorf("failed to encode version fields: %w", err)
+	}
+
+	var rowCount int64
+	if number > 1 {
+		previous, err := s.Repo.Queries.GetDatasetVersion(ctx, database.GetDatasetVersionParams{
+			DatasetID: datasetID,
+			Version:   number - 1,
+		})
+		if err != nil {
+			return DatasetVersion{}, fmt.Errorf("failed to get dataset version: %w", err)
+		}
+		rowCount = previous.RowCount
+	}
+	added, err := s.storeVersionRows(ctx, datasetID, number)
+	if err != nil {
+		return DatasetVersion{}, err
+	}
+	rowCount += added
+
+	version := DatasetVersion{
+		ID:        uuid.New(),
+		DatasetID: datasetID,
+		Version:   number,
+		AuthorID:  uuid.NullUUID{UUID: authorID, Valid: authorID != uuid.Nil},
+		Operation: operation,
+		CreatedAt: createdAt,
+		RowCount:  rowCount,
+		Columns:   columns,
+	}
+	err = s.Repo.Queries.CreateDatasetVersion(ctx, database.CreateDatasetVersionParams{
+		ID:        version.ID,
+		DatasetID: version.DatasetID,
+		Version:   version.Version,
+		AuthorID:  version.AuthorID,
+		Operation: version.Operation,
+		CreatedAt: version.CreatedAt,
+		RowCount:  version.RowCount,
+		Columns:   version.Columns,
+		Fields:    encoded,
+	})
+	if err != nil {
+		return DatasetVersion{}, fmt.Errorf("failed to store dataset version: %w", err)
+	}
+	return version, nil
+}
+
+// storeVersionRows ends the row versions of the changed rows and starts
+// new ones for them as version number. It returns the number of rows the
+// dataset gained, which is negative when rows were deleted.
+func (s *DatasetService) storeVersionRows(ctx context.Context, datasetID uuid.UUID, number int32) (int64, error) {
+	if s.changes == nil || s.changes.all {
+		closed, err := s.Repo.Queries.CloseChangedVersionRows(ctx, database.CloseChangedVersionRowsParams{
+			DatasetID: datasetID,
+			Version:   number,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to store version rows: %w", err)
+		}
+		inserted, err := s.Repo.Queries.InsertChangedVersionRows(ctx, database.InsertChangedVersionRowsParams{
+			DatasetID: datasetID,
+			Version:   number,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to store version rows: %w", err)
+		}
+		return inserted - closed, nil
+	}
+
+	rowIndexes := slices.Clone(s.changes.rowIndexes)
+	slices.Sort(rowIndexes)
+	rowIndexes = slices.Compact(rowIndexes)
+
+	var added int64
+	for len(rowIndexes) > 0 {
+		n := min(len(rowIndexes), versionRowsChunkSize)
+		closed, err := s.Repo.Queries.CloseChangedVersionRowsByRowIndex(ctx, database.CloseChangedVersionRowsByRowIndexParams{
+			DatasetID:  datasetID,
+			RowIndexes: rowIndexes[:n],
+			Version:    number,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to store version rows: %w", err)
+		}
+		inserted, err := s.Repo.Queries.InsertChangedVersionRowsByRowIndex(ctx, database.InsertChangedVersionRowsByRowIndexParams{
+			DatasetID:  datasetID,
+			RowIndexes: rowIndexes[:n],
+			Version:    number,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to store version rows: %w", err)
+		}
+		added += inserted - closed
+		rowIndexes = rowIndexes[n:]
+	}
+	return added, nil
+}
+
+// versionRowsChunkSize bounds the number of row indexes compared in one
+// statement.
+const versionRowsChunkSize = 10000
+
+// rowChanges collects the row indexes a change to a dataset wrote or
+// deleted, so that only those rows are compared when the change is recorded
+// as a version. Changes that may rewrite every row, such as dropping a
+// column, mark them all instead. A nil rowChanges, outside versioned,
+// collects nothing.
+type rowChanges struct {
+	all        bool
+	rowIndexes []int64
+}
+
+func (rc *rowChanges) add(rowIndexes ...int64) {
+	if rc != nil && !rc.all {
+		rc.rowIndexes = append(rc.rowIndexes, rowIndexes...)
+	}
+}
+
+func (rc *rowChanges) addRecords(records []database.CreateDatasetRecordParams) {
+	for _, r := range records {
+		rc.add(r.RowIndex)
+	}
+}
+
+func (rc *rowChanges) addAll() {
+	if rc != nil {
+		rc.all = true
+		rc.rowIndexes = nil
+	}
+}
+
+// pruneVersions deletes the oldest versions of a dataset once there are
+// more than MaxVersions up to latest, along with the row versions that only
+// they could read.
+func (s *DatasetService) pruneVersions(ctx context.Context, datasetID uuid.UUID, latest int32) error {
+	if s.MaxVersions <= 0 || int(latest) <= s.MaxVersions {
+		return nil
+	}
+	oldest := latest - int32(s.MaxVersions) + 1
+
+	err := s.Repo.Queries.DeleteDatasetVersionsBefore(ctx, database.DeleteDatasetVersionsBeforeParams{
+		DatasetID: datasetID,
+		Version:   oldest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune dataset versions: %w", err)
+	}
+	err = s.Repo.Queries.DeleteEndedVersionRows(ctx, database.DeleteEndedVersionRowsParams{
+		DatasetID: datasetID,
+		Version:   oldest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune version rows: %w", err)
+	}
+	return nil
+}
+
+// versionField holds what is needed to restore a field with the same ID,
+// type and position.
+type versionField struct {
+	ID                  uuid.UUID `json:"id"`
+	Name                string    `json:"name"`
+	DataType            string    `json:"data_type"`
+	Description         string    `json:"description,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	TypeConfidence      *float64  `json:"type_confidence,omitempty"`
+	TypeCounterexamples []string  `json:"type_counterexamples,omitempty"`
+	DateFormat          string    `json:"date_format,omitempty"`
+}
+
+// loadVersion returns the number and fields of a version of a dataset.
+func (s *DatasetService) loadVersion(ctx context.Context, datasetID, versionID uuid.UUID) (int32, []versionField, error) {
+	stored, err := s.Repo.Queries.GetDatasetVersionFields(ctx, versionID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && stored.DatasetID != datasetID) {
+		return 0, nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get dataset version: %w", err)
+	}
+
+	var fields []versionField
+	if err := json.Unmarshal(stored.Fields, &fields); err != nil {
+		return 0, nil, fmt.Errorf("failed to decode version fields: %w", err)
+	}
+	return stored.Version, fields, nil
+}
+
+// openVersionCursor starts reading the rows of a version of a dataset,
+// projected onto the named columns, or onto every column when names is
+// empty. It also returns every field of the version.
+func (s *DatasetService) openVersionCursor(ctx context.Context, datasetID, versionID uuid.UUID, names []string) (*RowCursor, []versionField, error) {
+	number, fields, err := s.loadVersion(ctx, datasetID, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	named := make([]database.GetFieldsByDatasetIDRow, len(fields))
+	for i, f := range fields {
+		named[i] = database.GetFieldsByDatasetIDRow{ID: f.ID, Name: f.Name}
+	}
+	columns, fieldIDs, err := projectFields(named, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &RowCursor{
+		Columns:  columns,
+		store:    versionPager{repo: s.Repo, version: number},
+		dataset:  datasetID,
+		fieldIDs: fieldIDs,
+		after:    -1,
+		batch:    rowBatchSize,
+		done:     len(fields) == 0,
+	}, fields, nil
+}
+
+// versionPager reads the rows of a version from the row versions it can
+// see.
+type versionPager struct {
+	repo    *database.Repository
+	version int32
+}
+
+func (p versionPager) RowsAfter(ctx context.Context, datasetID uuid.UUID, fieldIDs []uuid.UUID, after int64, limit int) ([]DatasetRow, error) {
+	stored, err := p.repo.Queries.GetVersionRowsAfter(ctx, database.GetVersionRowsAfterParams{
+		DatasetID: datasetID,
+		Version:   p.version,
+		After:     after,
+		Limit:     int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version rows: %w", err)
+	}
+
+	rows := make([]DatasetRow, len(stored))
+	for i, r := range stored {
+		// Cells are keyed by field ID, with null for missing values
+		var cells map[uuid.UUID]string
+		if err := json.Unmarshal(r.Cells, &cells); err != nil {
+			return nil, fmt.Errorf("failed to decode version row %d: %w", r.RowIndex, err)
+		}
+		values := make([]string, len(fieldIDs))
+		for j, id := range fieldIDs {
+			values[j] = cells[id]
+		}
+		rows[i] = DatasetRow{RowIndex: r.RowIndex, Values: values}
+	}
+	return rows, nil
+}
+
+// restoreVersion fills a cleared dataset with the fields and rows of a
+// version. Fields keep their IDs and rows their row indexes. Rows are copied
+// a batch at a time.
+func (s *DatasetService) restoreVersion(ctx context.Context, datasetID, versionID uuid.UUID) error {
+	cursor, fields, err := s.openVersionCursor(ctx, datasetID, versionID, nil)
+	if err != nil {
+		return err
+	}
+
+	fieldIDs := make([]uuid.UUID, len(fields))
+	for i, f := range fields {
+		err := s.Repo.Queries.CreateDatasetField(ctx, database.CreateDatasetFieldParams{
+			ID:          f.ID,
+			DatasetID:   datasetID,
+			Name:        f.Name,
+			DataType:    f.DataType,
+			Description: sql.NullString{String: f.Description, Valid: f.Description != ""},
+			CreatedAt:   f.CreatedAt,
+			DateFormat:  sql.NullString{String: f.DateFormat, Valid: f.DateFormat != ""},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert dataset field: %w", err)
+		}
+		if f.TypeConfidence != nil {
+			err := s.Repo.Queries.UpdateDatasetFieldType(ctx, database.UpdateDatasetFieldTypeParams{
+				ID:                  f.ID,
+				DataType:            f.DataType,
+				TypeConfidence:      sql.NullFloat64{Float64: *f.TypeConfidence, Valid: true},
+				TypeCounterexamples: f.TypeCounterexamples,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update field type: %w", err)
+			}
+		}
+		fieldIDs[i] = f.ID
+	}
+
+	batch := &valueBatch{store: s.values(), copy: s.CopyIngest}
+	now := time.Now()
+	for {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		recordID := uuid.New()
+		batch.records = append(batch.records, database.CreateDatasetRecordParams{
+			ID:        recordID,
+			DatasetID: datasetID,
+			CreatedAt: now,
+			UpdatedAt: now,
+			RowIndex:  row.RowIndex,
+		})
+		if err := batch.addRow(ctx, recordID, fieldIDs, row.Values); err != nil {
+			return err
+		}
+	}
+	if err := batch.flush(ctx); err != nil {
+		return fmt.Errorf("final batch insert failed: %w", err)
+	}
+
+	err = s.Repo.Queries.TouchDataset(ctx, database.TouchDatasetParams{
+		ID:        datasetID,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update dataset: %w", err)
+	}
+	return nil
+}
+
+// versionRows is GetDatasetRows for an earlier version of a dataset.
+// Versions never change, so they are cached under their own ID.
+func (s *DatasetService) versionRows(ctx context.Context, datasetID, versionID uuid.UUID) ([]string, [][]string, error) {
+	if header, rows, ok := s.Cache.getRows(versionID); ok {
+		return header, rows, nil
+	}
+	generation := s.Cache.begin()
+
+	cursor, _, err := s.openVersionCursor(ctx, datasetID, versionID, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows [][]string
+	for {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row.Values)
+	}
+	if len(rows) == 0 {
+		return []string{}, [][]string{}, nil // empty dataset
+	}
+
+	s.Cache.putRows(versionID, generation, cursor.Columns, rows)
+	return cursor.Columns, rows, nil
+}
+
+// versionNumericColumn reads a numeric column of an earlier version of a
+// dataset, parsing its values like the text layout does.
+func (s *DatasetService) versionNumericColumn(ctx context.Context, datasetID, versionID uuid.UUID, column string) ([]float64, []int64, error) {
+	cursor, _, err := s.openVersionCursor(ctx, datasetID, versionID, []string{column})
+	if errors.Is(err, ErrUnknownColumn) {
+		return nil, nil, fmt.Errorf("column '%s' not found in dataset", column)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		numbers    []float64
+		rowIndexes []int64
+	)
+	for {
+		row, err := cursor.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := row.Values[0]
+		if raw == "" {
+			continue
+		}
+		parsed, ok := parseStoredNumber(raw)
+		if !ok {
+			return nil, nil, fmt.Errorf("row %d: cannot parse '%s' as float64", row.RowIndex, raw)
+		}
+		numbers = append(numbers, parsed)
+		rowIndexes = append(rowIndexes, row.RowIndex)
+	}
+	return numbers, rowIndexes, nil
+}
+
+// pushdown reports whether aggregates can be computed by PostgreSQL, which
+// only holds the current version of each dataset.
+func (s *DatasetService) pushdown(ctx context.Context) bool {
+	_, versioned := versionFromContext(ctx)
+	return s.SQLAggregates && !versioned
+}
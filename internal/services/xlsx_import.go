@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Bgoodwin24/insightforge/internal/database"
+	"github.com/Bgoodwin24/insightforge/logger"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+// AllSheets can be passed in WorkbookOptions.Sheets to import every sheet.
+const AllSheets = "*"
+
+type WorkbookOptions struct {
+	// Sheets lists the sheet names to import. Empty means the first sheet.
+	Sheets []string
+	// HeaderRow is the 1-based row holding the column names. Rows above it
+	// are ignored. Zero means the first row.
+	HeaderRow int
+}
+
+// UploadWorkbook imports an .xlsx workbook, creating one dataset per selected
+// sheet. Values are read as Excel displays them and typed exactly like CSV
+// uploads.
+func (s *DatasetService) UploadWorkbook(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts WorkbookOptions,
+) ([]database.Dataset, error) {
+	return s.importAtomically(ctx, ImportOptions{Workbook: opts}, func(tx *DatasetService, opts ImportOptions) ([]database.Dataset, error) {
+		return tx.importWorkbook(ctx, userID, filename, file, opts)
+	})
+}
+
+func (s *DatasetService) importWorkbook(
+	ctx context.Context,
+	userID uuid.UUID,
+	filename string,
+	file io.Reader,
+	opts ImportOptions,
+) ([]database.Dataset, error) {
+	wb, err := excelize.OpenReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer wb.Close()
+
+	sheets, err := selectSheets(wb, opts.Workbook.Sheets)
+	if err != nil {
+		return nil, err
+	}
+
+	headerRow := opts.Workbook.HeaderRow
+	if headerRow <= 0 {
+		headerRow = 1
+	}
+
+	var datasets []database.Dataset
+	for _, sheet := range sheets {
+		headers, rows, err := readSheet(wb, sheet, headerRow)
+		if err != nil {
+			return datasets, err
+		}
+
+		name := filename
+		if len(sheets) > 1 {
+			name = fmt.Sprintf("%s - %s", filename, sheet)
+		}
+
+		dataset, err := s.createImportDataset(ctx, userID, name, fmt.Sprintf("Uploaded from sheet %q", sheet), "", opts)
+		if err != nil {
+			logger.Logger.Printf("Error uploading workbook sheet %s: %v", sheet, err)
+			return datasets, err
+		}
+
+		if err := s.ingestRows(ctx, dataset, headers, &sliceRowReader{rows: rows}, opts.ingestOptions()); err != nil {
+			return datasets, fmt.Errorf("sheet %q: %w", sheet, err)
+		}
+		datasets = append(datasets, dataset)
+	}
+
+	return datasets, nil
+}
+
+func selectSheets(wb *excelize.File, requested []string) ([]string, error) {
+	available := wb.GetSheetList()
+	if len(available) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+
+	if len(requested) == 0 {
+		return available[:1], nil
+	}
+
+	var sheets []string
+	for _, name := range requested {
+		if name == AllSheets {
+			return available, nil
+		}
+		found := false
+		for _, sheet := range available {
+			if strings.EqualFold(sheet, name) {
+				sheets = append(sheets, sheet)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("sheet %q not found in workbook", name)
+		}
+	}
+	return sheets, nil
+}
+
+// readSheet returns the header and data rows of a sheet. Merged ranges are
+// filled with the value of their top-left cell, every row is padded to the
+// same width and rows with no values at all are dropped.
+func readSheet(wb *excelize.File, sheet string, headerRow int) ([]string, [][]string, error) {
+	rows, err := wb.GetRows(sheet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read sheet %q: %w", sheet, err)
+	}
+
+	merged, err := wb.GetMergeCells(sheet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read merged cells of sheet %q: %w", sheet, err)
+	}
+	for _, mc := range merged {
+		rows, err = fillMergedCell(rows, mc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sheet %q: %w", sheet, err)
+		}
+	}
+
+	if len(rows) < headerRow {
+		return nil, nil, fmt.Errorf("sheet %q has no header at row %d", sheet, headerRow)
+	}
+
+	width := 0
+	for _, row := range rows[headerRow-1:] {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	if width == 0 {
+		return nil, nil, fmt.Errorf("sheet %q is empty", sheet)
+	}
+
+	headers := normalizeHeaders(padRow(rows[headerRow-1], width))
+
+	var data [][]string
+	for _, row := range rows[headerRow:] {
+		if isBlankRow(row) {
+			continue
+		}
+		data = append(data, padRow(row, width))
+	}
+
+	return headers, data, nil
+}
+
+func fillMergedCell(rows [][]string, mc excelize.MergeCell) ([][]string, error) {
+	startCol, startRow, err := excelize.CellNameToCoordinates(mc.GetStartAxis())
+	if err != nil {
+		return nil, err
+	}
+	endCol, endRow, err := excelize.CellNameToCoordinates(mc.GetEndAxis())
+	if err != nil {
+		return nil, err
+	}
+
+	value := mc.GetCellValue()
+	for len(rows) < endRow {
+		rows = append(rows, nil)
+	}
+	for r := startRow - 1; r < endRow; r++ {
+		rows[r] = padRow(rows[r], endCol)
+		for c := startCol - 1; c < endCol; c++ {
+			rows[r][c] = value
+		}
+	}
+	return rows, nil
+}
+
+func padRow(row []string, width int) []string {
+	if len(row) >= width {
+		return row
+	}
+	padded := make([]string, width)
+	copy(padded, row)
+	return padded
+}
+
+func isBlankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeHeaders names blank header cells after their position and
+// suffixes repeated names, since field names must be unique per dataset.
+func normalizeHeaders(headers []string) []string {
+	out := make([]string, len(headers))
+	seen := make(map[string]int)
+	for i, h := range headers {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			h = fmt.Sprintf("column_%d", i+1)
+		}
+		seen[h]++
+		if n := seen[h]; n > 1 {
+			h = fmt.Sprintf("%s_%d", h, n)
+		}
+		out[i] = h
+	}
+	return out
+}
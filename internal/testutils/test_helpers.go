@@ -54,7 +54,7 @@ func SetupDB() *sql.DB {
 	return db
 }
 
-func CreateTestUser(t *testing.T, repo *database.Repository, email string) database.User {
+func CreateTestUser(t testing.TB, repo *database.Repository, email string) database.User {
 	password, _ := auth.HashPassword("P@ssw0rd!")
 	now := time.Now().UTC()
 	username := fmt.Sprintf("testuser_%d", time.Now().UnixNano())
@@ -80,6 +80,7 @@ func CreateTestDataset(t *testing.T, repo *database.Repository, userID uuid.UUID
 		UserID:      userID,
 		Name:        name,
 		Description: sql.NullString{String: description, Valid: description != ""},
+		Status:      "ready",
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	})
@@ -116,13 +117,16 @@ func CleanDB(db *database.Repository) {
 	}
 }
 
+// nextRowIndex numbers test records in the order they are inserted.
+const nextRowIndex = `(SELECT COALESCE(MAX(row_index) + 1, 0) FROM dataset_records WHERE dataset_id = $2)`
+
 func InsertTestRecord(t *testing.T, repo *database.Repository, datasetID, fieldID uuid.UUID, value string) {
 	recordID := uuid.New()
 	now := time.Now()
 
 	_, err := repo.DB.Exec(`
-        INSERT INTO dataset_records (id, dataset_id, created_at, updated_at)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO dataset_records (id, dataset_id, created_at, updated_at, row_index)
+        VALUES ($1, $2, $3, $4, `+nextRowIndex+`)
     `, recordID, datasetID, now, now)
 	require.NoError(t, err)
 
@@ -152,8 +156,8 @@ func InsertTestValueWithRecordID(t *testing.T, repo *database.Repository, datase
 
 	// Insert dataset_record only if it doesn't exist
 	_, err := repo.DB.Exec(`
-		INSERT INTO dataset_records (id, dataset_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO dataset_records (id, dataset_id, created_at, updated_at, row_index)
+		VALUES ($1, $2, $3, $4, `+nextRowIndex+`)
 		ON CONFLICT (id) DO NOTHING
 	`, recordID, datasetID, now, now)
 	require.NoError(t, err)
@@ -178,8 +182,8 @@ func InsertTestRecordMulti(t *testing.T, repo *database.Repository, datasetID uu
 	now := time.Now()
 
 	_, err := repo.DB.Exec(`
-        INSERT INTO dataset_records (id, dataset_id, created_at, updated_at)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO dataset_records (id, dataset_id, created_at, updated_at, row_index)
+        VALUES ($1, $2, $3, $4, `+nextRowIndex+`)
     `, recordID, datasetID, now, now)
 	require.NoError(t, err)
 
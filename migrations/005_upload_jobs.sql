@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE upload_jobs (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    phase TEXT NOT NULL,
+    rows_processed BIGINT NOT NULL DEFAULT 0,
+    rows_rejected BIGINT NOT NULL DEFAULT 0,
+    rows_total BIGINT NOT NULL DEFAULT 0,
+    bytes_read BIGINT NOT NULL DEFAULT 0,
+    bytes_total BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX idx_upload_jobs_user_id ON upload_jobs(user_id);
+
+ALTER TABLE datasets ADD COLUMN status TEXT NOT NULL DEFAULT 'ready';
+ALTER TABLE datasets ADD COLUMN upload_job_id UUID REFERENCES upload_jobs(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE datasets DROP COLUMN IF EXISTS upload_job_id;
+ALTER TABLE datasets DROP COLUMN IF EXISTS status;
+DROP INDEX IF EXISTS idx_upload_jobs_user_id;
+DROP TABLE IF EXISTS upload_jobs;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE upload_rejections (
+    id UUID PRIMARY KEY,
+    upload_job_id UUID NOT NULL REFERENCES upload_jobs(id) ON DELETE CASCADE,
+    dataset_id UUID REFERENCES datasets(id) ON DELETE SET NULL,
+    row_number BIGINT NOT NULL,
+    raw TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_upload_rejections_upload_job_id ON upload_rejections(upload_job_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_upload_rejections_upload_job_id;
+DROP TABLE IF EXISTS upload_rejections;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE dataset_fields ADD COLUMN type_confidence DOUBLE PRECISION;
+ALTER TABLE dataset_fields ADD COLUMN type_counterexamples TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE dataset_fields DROP COLUMN IF EXISTS type_counterexamples;
+ALTER TABLE dataset_fields DROP COLUMN IF EXISTS type_confidence;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE datasets ADD COLUMN encoding TEXT;
+
+-- +goose Down
+ALTER TABLE datasets DROP COLUMN IF EXISTS encoding;
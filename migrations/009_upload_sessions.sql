@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE upload_sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    chunk_size BIGINT NOT NULL,
+    checksum TEXT,
+    upload_job_id UUID REFERENCES upload_jobs(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    finalized_at TIMESTAMP
+);
+
+CREATE INDEX idx_upload_sessions_user_id ON upload_sessions(user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_upload_sessions_user_id;
+DROP TABLE IF EXISTS upload_sessions;
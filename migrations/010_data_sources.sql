@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE data_sources (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    config BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_data_sources_user_id ON data_sources(user_id);
+
+CREATE TABLE dataset_queries (
+    dataset_id UUID PRIMARY KEY REFERENCES datasets(id) ON DELETE CASCADE,
+    data_source_id UUID NOT NULL REFERENCES data_sources(id) ON DELETE CASCADE,
+    query TEXT NOT NULL,
+    max_rows BIGINT NOT NULL,
+    timeout_seconds INTEGER NOT NULL,
+    refreshed_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS dataset_queries;
+DROP INDEX IF EXISTS idx_data_sources_user_id;
+DROP TABLE IF EXISTS data_sources;
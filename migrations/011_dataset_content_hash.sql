@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE datasets ADD COLUMN content_hash TEXT;
+CREATE INDEX idx_datasets_user_content_hash ON datasets(user_id, content_hash);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_datasets_user_content_hash;
+ALTER TABLE datasets DROP COLUMN IF EXISTS content_hash;
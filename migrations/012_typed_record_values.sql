@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE record_values ADD COLUMN num_value DOUBLE PRECISION;
+
+-- Existing values are converted once here. Text that is not a finite number
+-- is left without a numeric value.
+-- +goose StatementBegin
+CREATE FUNCTION try_float8(v TEXT) RETURNS DOUBLE PRECISION AS $$
+BEGIN
+    RETURN v::DOUBLE PRECISION;
+EXCEPTION WHEN others THEN
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+UPDATE record_values SET num_value = try_float8(value)
+WHERE value ~ '^\s*[-+]?\.?[0-9]';
+
+DROP FUNCTION try_float8(TEXT);
+
+-- +goose Down
+ALTER TABLE record_values DROP COLUMN IF EXISTS num_value;
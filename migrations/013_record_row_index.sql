@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE dataset_records ADD COLUMN row_index BIGINT;
+
+-- Existing rows keep the order they were last read in
+UPDATE dataset_records r
+SET row_index = numbered.row_index
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY dataset_id ORDER BY created_at, id) - 1 AS row_index
+    FROM dataset_records
+) numbered
+WHERE r.id = numbered.id;
+
+ALTER TABLE dataset_records ALTER COLUMN row_index SET NOT NULL;
+CREATE UNIQUE INDEX idx_dataset_records_row_index ON dataset_records(dataset_id, row_index);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_dataset_records_row_index;
+ALTER TABLE dataset_records DROP COLUMN IF EXISTS row_index;
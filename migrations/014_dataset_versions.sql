@@ -0,0 +1,34 @@
+-- +goose Up
+-- Each version records a dataset's columns as they were after the change it
+-- describes. Rows are not copied into every version: a row version holds the
+-- non-empty values of a row, keyed by field ID, from the version that wrote
+-- them until the version that changed or deleted the row, which is NULL
+-- while the row is unchanged.
+CREATE TABLE dataset_versions (
+    id UUID PRIMARY KEY,
+    dataset_id UUID NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    operation TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    row_count BIGINT NOT NULL,
+    columns TEXT[] NOT NULL,
+    fields JSONB NOT NULL,
+    UNIQUE (dataset_id, version)
+);
+
+CREATE TABLE dataset_version_rows (
+    dataset_id UUID NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+    row_index BIGINT NOT NULL,
+    first_version INTEGER NOT NULL,
+    last_version INTEGER,
+    cells JSONB NOT NULL,
+    PRIMARY KEY (dataset_id, row_index, first_version)
+);
+
+CREATE INDEX idx_dataset_version_rows_current ON dataset_version_rows(dataset_id, row_index) WHERE last_version IS NULL;
+CREATE INDEX idx_dataset_version_rows_ended ON dataset_version_rows(dataset_id, last_version) WHERE last_version IS NOT NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS dataset_version_rows;
+DROP TABLE IF EXISTS dataset_versions;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Running jobs refresh heartbeat_at, so a starting instance can tell the
+-- jobs of a crashed process from those another instance is still running
+ALTER TABLE upload_jobs ADD COLUMN heartbeat_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE upload_jobs DROP COLUMN IF EXISTS heartbeat_at;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- The date format a schema declared for a datetime column, so values edited
+-- later are checked against the same format as those uploaded
+ALTER TABLE dataset_fields ADD COLUMN date_format TEXT;
+
+-- +goose Down
+ALTER TABLE dataset_fields DROP COLUMN IF EXISTS date_format;
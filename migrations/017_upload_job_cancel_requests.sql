@@ -0,0 +1,7 @@
+-- +goose Up
+-- A job running in another instance cannot be cancelled directly, so the
+-- request is recorded here and picked up by the job at its next heartbeat
+ALTER TABLE upload_jobs ADD COLUMN cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE upload_jobs DROP COLUMN IF EXISTS cancel_requested;
@@ -0,0 +1,53 @@
+-- name: GetColumnAggregates :one
+SELECT
+    COUNT(value) AS value_count,
+    COUNT(num_value) AS numeric_count,
+    COALESCE(SUM(num_value), 0)::DOUBLE PRECISION AS sum,
+    COALESCE(AVG(num_value), 0)::DOUBLE PRECISION AS mean,
+    COALESCE(MIN(num_value), 0)::DOUBLE PRECISION AS min,
+    COALESCE(MAX(num_value), 0)::DOUBLE PRECISION AS max,
+    COALESCE(VAR_SAMP(num_value), 0)::DOUBLE PRECISION AS variance
+FROM record_values
+WHERE field_id = sqlc.arg(field_id) AND value IS NOT NULL;
+
+-- name: GetColumnMedian :one
+SELECT COALESCE(PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY num_value), 0)::DOUBLE PRECISION AS median
+FROM record_values
+WHERE field_id = sqlc.arg(field_id) AND num_value IS NOT NULL;
+
+-- name: GetGroupedAggregates :many
+SELECT
+    COALESCE(g.value, '')::TEXT AS group_key,
+    COUNT(v.value) AS value_count,
+    COUNT(v.num_value) AS numeric_count,
+    COALESCE(SUM(v.num_value), 0)::DOUBLE PRECISION AS sum,
+    COALESCE(AVG(v.num_value), 0)::DOUBLE PRECISION AS mean,
+    COALESCE(MIN(v.num_value), 0)::DOUBLE PRECISION AS min,
+    COALESCE(MAX(v.num_value), 0)::DOUBLE PRECISION AS max,
+    COALESCE(STDDEV_SAMP(v.num_value), 0)::DOUBLE PRECISION AS stddev
+FROM dataset_records r
+JOIN record_values v ON v.record_id = r.id AND v.field_id = sqlc.arg(value_field_id) AND v.value IS NOT NULL
+LEFT JOIN record_values g ON g.record_id = r.id AND g.field_id = sqlc.arg(group_field_id)
+WHERE r.dataset_id = sqlc.arg(dataset_id)
+GROUP BY 1;
+
+-- name: GetGroupedMedians :many
+SELECT
+    COALESCE(g.value, '')::TEXT AS group_key,
+    PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY v.num_value)::DOUBLE PRECISION AS median
+FROM dataset_records r
+JOIN record_values v ON v.record_id = r.id AND v.field_id = sqlc.arg(value_field_id) AND v.num_value IS NOT NULL
+LEFT JOIN record_values g ON g.record_id = r.id AND g.field_id = sqlc.arg(group_field_id)
+WHERE r.dataset_id = sqlc.arg(dataset_id)
+GROUP BY 1;
+
+-- name: CountDatasetRecords :one
+SELECT COUNT(*) FROM dataset_records
+WHERE dataset_id = $1;
+
+-- name: CountRecordsByGroup :many
+SELECT COALESCE(g.value, '')::TEXT AS group_key, COUNT(*) AS count
+FROM dataset_records r
+LEFT JOIN record_values g ON g.record_id = r.id AND g.field_id = sqlc.arg(group_field_id)
+WHERE r.dataset_id = sqlc.arg(dataset_id)
+GROUP BY 1;
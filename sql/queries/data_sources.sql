@@ -0,0 +1,31 @@
+-- name: CreateDataSource :one
+INSERT INTO data_sources (id, user_id, name, config, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetDataSource :one
+SELECT * FROM data_sources
+WHERE id = $1;
+
+-- name: ListDataSourcesForUser :many
+SELECT * FROM data_sources
+WHERE user_id = $1
+ORDER BY name;
+
+-- name: DeleteDataSource :exec
+DELETE FROM data_sources
+WHERE id = $1 AND user_id = $2;
+
+-- name: GetDatasetQuery :one
+SELECT * FROM dataset_queries
+WHERE dataset_id = $1;
+
+-- name: UpsertDatasetQuery :exec
+INSERT INTO dataset_queries (dataset_id, data_source_id, query, max_rows, timeout_seconds, refreshed_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (dataset_id) DO UPDATE
+SET data_source_id = EXCLUDED.data_source_id,
+    query = EXCLUDED.query,
+    max_rows = EXCLUDED.max_rows,
+    timeout_seconds = EXCLUDED.timeout_seconds,
+    refreshed_at = EXCLUDED.refreshed_at;
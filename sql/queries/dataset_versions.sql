@@ -0,0 +1,122 @@
+-- name: CreateDatasetVersion :exec
+INSERT INTO dataset_versions (id, dataset_id, version, author_id, operation, created_at, row_count, columns, fields)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: GetLatestDatasetVersion :one
+SELECT COALESCE(MAX(version), 0)::INTEGER AS latest_version
+FROM dataset_versions
+WHERE dataset_id = $1;
+
+-- name: ListDatasetVersions :many
+SELECT id, dataset_id, version, author_id, operation, created_at, row_count, columns
+FROM dataset_versions
+WHERE dataset_id = $1
+ORDER BY version;
+
+-- name: GetDatasetVersion :one
+SELECT id, dataset_id, version, author_id, operation, created_at, row_count, columns
+FROM dataset_versions
+WHERE dataset_id = $1 AND version = $2;
+
+-- name: GetDatasetVersionFields :one
+SELECT dataset_id, version, fields
+FROM dataset_versions
+WHERE id = $1;
+
+-- name: CloseChangedVersionRows :execrows
+-- Ends the current row versions of rows that were changed or deleted,
+-- comparing every row of the dataset. Cells hold the values of a row keyed
+-- by field ID, leaving out empty ones.
+WITH current_rows AS (
+    SELECT r.row_index,
+           COALESCE(jsonb_object_agg(v.field_id, v.value) FILTER (WHERE v.value IS NOT NULL), '{}') AS cells
+    FROM dataset_records r
+    LEFT JOIN record_values v ON v.record_id = r.id
+    WHERE r.dataset_id = sqlc.arg(dataset_id)
+    GROUP BY r.row_index
+)
+UPDATE dataset_version_rows vr
+SET last_version = sqlc.arg(version)::INTEGER
+WHERE vr.dataset_id = sqlc.arg(dataset_id) AND vr.last_version IS NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM current_rows c
+      WHERE c.row_index = vr.row_index AND c.cells = vr.cells
+  );
+
+-- name: InsertChangedVersionRows :execrows
+-- Starts row versions for the rows that have no current one, which are those
+-- CloseChangedVersionRows ended and those that are new.
+WITH current_rows AS (
+    SELECT r.row_index,
+           COALESCE(jsonb_object_agg(v.field_id, v.value) FILTER (WHERE v.value IS NOT NULL), '{}') AS cells
+    FROM dataset_records r
+    LEFT JOIN record_values v ON v.record_id = r.id
+    WHERE r.dataset_id = sqlc.arg(dataset_id)
+    GROUP BY r.row_index
+)
+INSERT INTO dataset_version_rows (dataset_id, row_index, first_version, cells)
+SELECT sqlc.arg(dataset_id), c.row_index, sqlc.arg(version)::INTEGER, c.cells
+FROM current_rows c
+WHERE NOT EXISTS (
+    SELECT 1 FROM dataset_version_rows vr
+    WHERE vr.dataset_id = sqlc.arg(dataset_id) AND vr.row_index = c.row_index AND vr.last_version IS NULL
+);
+
+-- name: CloseChangedVersionRowsByRowIndex :execrows
+-- CloseChangedVersionRows for the given rows only.
+WITH current_rows AS (
+    SELECT r.row_index,
+           COALESCE(jsonb_object_agg(v.field_id, v.value) FILTER (WHERE v.value IS NOT NULL), '{}') AS cells
+    FROM dataset_records r
+    LEFT JOIN record_values v ON v.record_id = r.id
+    WHERE r.dataset_id = sqlc.arg(dataset_id)
+      AND r.row_index = ANY(sqlc.arg(row_indexes)::BIGINT[])
+    GROUP BY r.row_index
+)
+UPDATE dataset_version_rows vr
+SET last_version = sqlc.arg(version)::INTEGER
+WHERE vr.dataset_id = sqlc.arg(dataset_id) AND vr.last_version IS NULL
+  AND vr.row_index = ANY(sqlc.arg(row_indexes)::BIGINT[])
+  AND NOT EXISTS (
+      SELECT 1 FROM current_rows c
+      WHERE c.row_index = vr.row_index AND c.cells = vr.cells
+  );
+
+-- name: InsertChangedVersionRowsByRowIndex :execrows
+-- InsertChangedVersionRows for the given rows only.
+WITH current_rows AS (
+    SELECT r.row_index,
+           COALESCE(jsonb_object_agg(v.field_id, v.value) FILTER (WHERE v.value IS NOT NULL), '{}') AS cells
+    FROM dataset_records r
+    LEFT JOIN record_values v ON v.record_id = r.id
+    WHERE r.dataset_id = sqlc.arg(dataset_id)
+      AND r.row_index = ANY(sqlc.arg(row_indexes)::BIGINT[])
+    GROUP BY r.row_index
+)
+INSERT INTO dataset_version_rows (dataset_id, row_index, first_version, cells)
+SELECT sqlc.arg(dataset_id), c.row_index, sqlc.arg(version)::INTEGER, c.cells
+FROM current_rows c
+WHERE NOT EXISTS (
+    SELECT 1 FROM dataset_version_rows vr
+    WHERE vr.dataset_id = sqlc.arg(dataset_id) AND vr.row_index = c.row_index AND vr.last_version IS NULL
+);
+
+-- name: GetVersionRowsAfter :many
+SELECT row_index, cells
+FROM dataset_version_rows
+WHERE dataset_id = sqlc.arg(dataset_id)
+  AND first_version <= sqlc.arg(version)::INTEGER
+  AND (last_version IS NULL OR last_version > sqlc.arg(version)::INTEGER)
+  AND row_index > sqlc.arg(after)
+ORDER BY row_index
+LIMIT sqlc.arg(limit);
+
+-- name: DeleteDatasetVersionsBefore :exec
+DELETE FROM dataset_versions
+WHERE dataset_id = sqlc.arg(dataset_id) AND version < sqlc.arg(version)::INTEGER;
+
+-- name: DeleteEndedVersionRows :exec
+-- Deletes the row versions that ended before the given version, which no
+-- version from it on can read.
+DELETE FROM dataset_version_rows
+WHERE dataset_id = sqlc.arg(dataset_id) AND last_version <= sqlc.arg(version)::INTEGER;
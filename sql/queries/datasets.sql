@@ -5,9 +5,13 @@ INSERT INTO datasets (
     name,
     description,
     created_at,
-    updated_at
+    updated_at,
+    status,
+    upload_job_id,
+    encoding,
+    content_hash
 ) VALUES (
-    sqlc.arg(id), sqlc.arg(user_id), sqlc.arg(name), sqlc.arg(description), sqlc.arg(created_at), sqlc.arg(updated_at)
+    sqlc.arg(id), sqlc.arg(user_id), sqlc.arg(name), sqlc.arg(description), sqlc.arg(created_at), sqlc.arg(updated_at), sqlc.arg(status), sqlc.arg(upload_job_id), sqlc.arg(encoding), sqlc.arg(content_hash)
 )
 RETURNING *;
 
@@ -15,9 +19,14 @@ RETURNING *;
 SELECT * FROM datasets
 WHERE id = sqlc.arg(id);
 
+-- name: LockDataset :one
+SELECT * FROM datasets
+WHERE id = sqlc.arg(id)
+FOR UPDATE;
+
 -- name: ListDatasetsForUser :many
 SELECT * FROM datasets
-WHERE user_id = sqlc.arg(id)
+WHERE user_id = sqlc.arg(id) AND status = 'ready'
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2;
 
@@ -37,6 +46,7 @@ WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id);
 -- name: SearchDatasetByName :many
 SELECT * FROM datasets
 WHERE user_id = $1
+  AND status = 'ready'
   AND (
     $2::text IS NULL OR name ILIKE '%' || $2::text || '%'
   )
@@ -44,16 +54,16 @@ ORDER BY created_at DESC
 LIMIT $3 OFFSET $4;
 
 -- name: CreateDatasetField :exec
-INSERT INTO dataset_fields (id, dataset_id, name, data_type, description, created_at)
-VALUES ($1, $2, $3, $4, $5, $6);
+INSERT INTO dataset_fields (id, dataset_id, name, data_type, description, created_at, date_format)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
 
 -- name: CreateDatasetRecord :exec
-INSERT INTO dataset_records (id, dataset_id, created_at, updated_at)
-VALUES ($1, $2, $3, $4);
+INSERT INTO dataset_records (id, dataset_id, created_at, updated_at, row_index)
+VALUES ($1, $2, $3, $4, $5);
 
 -- name: CreateRecordValue :exec
-INSERT INTO record_values (record_id, field_id, value)
-VALUES ($1, $2, $3);
+INSERT INTO record_values (record_id, field_id, value, num_value)
+VALUES ($1, $2, $3, $4);
 
 -- name: GetDatasetFields :many
 SELECT * FROM dataset_fields
@@ -63,7 +73,7 @@ ORDER BY name;
 -- name: GetDatasetRecords :many
 SELECT * FROM dataset_records
 WHERE dataset_id = $1
-ORDER BY created_at;
+ORDER BY row_index;
 
 -- name: GetRecordValuesByRecordID :many
 SELECT * FROM record_values
@@ -71,7 +81,7 @@ WHERE record_id = $1;
 
 
 -- name: GetFieldsByDatasetID :many
-SELECT id, name, data_type, description, created_at, dataset_id
+SELECT id, name, data_type, description, created_at, dataset_id, type_confidence, type_counterexamples, date_format
 FROM dataset_fields
 WHERE dataset_id = $1
 ORDER BY created_at ASC;
@@ -80,13 +90,24 @@ ORDER BY created_at ASC;
 SELECT name FROM dataset_fields WHERE dataset_id = $1 ORDER BY created_at;
 
 -- name: GetRecordsByDatasetID :many
-SELECT id, dataset_id, created_at, updated_at
+SELECT id, dataset_id, created_at, updated_at, row_index
 FROM dataset_records
 WHERE dataset_id = $1
-ORDER BY created_at ASC;
+ORDER BY row_index ASC;
+
+-- name: GetRecordsByRowIndex :many
+SELECT id, row_index
+FROM dataset_records
+WHERE dataset_id = sqlc.arg(dataset_id) AND row_index = ANY(sqlc.arg(row_indexes)::bigint[])
+ORDER BY row_index;
+
+-- name: GetNextRowIndex :one
+SELECT COALESCE(MAX(row_index) + 1, 0)::BIGINT AS next_row_index
+FROM dataset_records
+WHERE dataset_id = $1;
 
 -- name: GetRecordValuesByDatasetID :many
-SELECT record_id, field_id, value
+SELECT record_id, field_id, value, num_value
 FROM record_values
 WHERE record_id IN (
     SELECT id FROM dataset_records WHERE dataset_id = $1
@@ -100,7 +121,7 @@ WITH updated AS (
     RETURNING id
 )
 UPDATE record_values
-SET value = $3
+SET value = $3, num_value = sqlc.arg(num_value)
 WHERE record_id IN (SELECT id FROM updated) AND field_id = $4;
 
 -- name: GetDatasetField :one
@@ -109,3 +130,94 @@ SELECT * FROM dataset_fields WHERE id = $1 AND dataset_id = $2;
 -- name: DeleteDatasetField :exec
 DELETE FROM dataset_fields
 WHERE id = $1 AND dataset_id = $2;
+
+-- name: GetDatasetValues :many
+SELECT r.id AS record_id, r.row_index, v.field_id, v.value
+FROM dataset_records r
+LEFT JOIN record_values v ON v.record_id = r.id
+WHERE r.dataset_id = $1
+ORDER BY r.row_index;
+
+-- name: GetDatasetValuesPage :many
+SELECT r.id AS record_id, r.row_index, v.field_id, v.value
+FROM (
+    SELECT id, row_index FROM dataset_records
+    WHERE dataset_id = sqlc.arg(dataset_id) AND row_index > sqlc.arg(after)
+    ORDER BY row_index
+    LIMIT sqlc.arg(limit)
+) r
+LEFT JOIN record_values v ON v.record_id = r.id AND v.field_id = ANY(sqlc.arg(field_ids)::uuid[])
+ORDER BY r.row_index;
+
+-- name: GetNumericValuesForField :many
+SELECT r.row_index, v.num_value, CASE WHEN v.num_value IS NULL THEN v.value END AS value
+FROM record_values v
+JOIN dataset_records r ON r.id = v.record_id
+WHERE v.field_id = $1 AND v.value IS NOT NULL
+ORDER BY r.row_index;
+
+-- name: GetDatasetsByContentHash :many
+SELECT * FROM datasets
+WHERE user_id = sqlc.arg(user_id) AND content_hash = sqlc.arg(content_hash) AND status = 'ready'
+ORDER BY created_at;
+
+-- name: GetDatasetsByUploadJob :many
+SELECT * FROM datasets
+WHERE upload_job_id = $1
+ORDER BY created_at;
+
+-- name: PublishUploadJobDatasets :exec
+UPDATE datasets
+SET status = 'ready', updated_at = $2
+WHERE upload_job_id = $1 AND status = 'pending';
+
+-- name: DeletePendingUploadJobDatasets :exec
+DELETE FROM datasets
+WHERE upload_job_id = $1 AND status = 'pending';
+
+-- name: DeleteOrphanedPendingDatasets :exec
+DELETE FROM datasets d
+WHERE d.status = 'pending'
+  AND NOT EXISTS (
+    SELECT 1 FROM upload_jobs j
+    WHERE j.id = d.upload_job_id AND j.phase IN ('queued', 'parsing', 'inserting')
+  );
+
+-- name: TouchDataset :exec
+UPDATE datasets
+SET updated_at = $2
+WHERE id = $1;
+
+-- name: GetRecordValuesForField :many
+SELECT record_id, value
+FROM record_values
+WHERE field_id = $1;
+
+-- name: TouchDatasetRecords :many
+UPDATE dataset_records
+SET updated_at = sqlc.arg(updated_at)
+WHERE id = ANY(sqlc.arg(ids)::uuid[])
+RETURNING row_index;
+
+-- name: DeleteDatasetRecords :many
+DELETE FROM dataset_records
+WHERE dataset_id = sqlc.arg(dataset_id) AND id = ANY(sqlc.arg(ids)::uuid[])
+RETURNING row_index;
+
+-- name: UpdateDatasetFieldType :exec
+UPDATE dataset_fields
+SET data_type = $2, type_confidence = $3, type_counterexamples = $4, date_format = NULL
+WHERE id = $1;
+
+-- name: UpdateDatasetFieldName :exec
+UPDATE dataset_fields
+SET name = $2
+WHERE id = $1;
+
+-- name: DeleteAllDatasetRecords :exec
+DELETE FROM dataset_records
+WHERE dataset_id = $1;
+
+-- name: DeleteAllDatasetFields :exec
+DELETE FROM dataset_fields
+WHERE dataset_id = $1;
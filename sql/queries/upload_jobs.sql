@@ -0,0 +1,49 @@
+-- name: CreateUploadJob :one
+INSERT INTO upload_jobs (id, user_id, filename, phase, bytes_total, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetUploadJob :one
+SELECT * FROM upload_jobs
+WHERE id = $1;
+
+-- name: StartUploadJob :exec
+UPDATE upload_jobs
+SET phase = $2, started_at = $3, heartbeat_at = $3
+WHERE id = $1;
+
+-- name: HeartbeatUploadJob :one
+UPDATE upload_jobs
+SET heartbeat_at = $2
+WHERE id = $1
+RETURNING cancel_requested;
+
+-- name: RequestUploadJobCancel :exec
+UPDATE upload_jobs
+SET cancel_requested = TRUE
+WHERE id = $1;
+
+-- name: UpdateUploadJobProgress :exec
+UPDATE upload_jobs
+SET phase = $2, rows_processed = $3, rows_rejected = $4, rows_total = $5, bytes_read = $6
+WHERE id = $1;
+
+-- name: FinishUploadJob :exec
+UPDATE upload_jobs
+SET phase = $2, error = $3, finished_at = $4
+WHERE id = $1;
+
+-- name: FailInterruptedUploadJobs :exec
+UPDATE upload_jobs
+SET phase = 'failed', error = 'interrupted by server restart', finished_at = $1
+WHERE phase IN ('queued', 'parsing', 'inserting')
+  AND COALESCE(heartbeat_at, created_at) < $2;
+
+-- name: CreateUploadRejection :exec
+INSERT INTO upload_rejections (id, upload_job_id, dataset_id, row_number, raw, reason, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListUploadRejections :many
+SELECT * FROM upload_rejections
+WHERE upload_job_id = $1
+ORDER BY created_at, row_number;
@@ -0,0 +1,32 @@
+-- name: CreateUploadSession :one
+INSERT INTO upload_sessions (id, user_id, filename, size, chunk_size, checksum, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: GetUploadSession :one
+SELECT * FROM upload_sessions
+WHERE id = $1;
+
+-- name: ClaimUploadSession :execrows
+UPDATE upload_sessions
+SET finalized_at = $2
+WHERE id = $1 AND finalized_at IS NULL;
+
+-- name: ReleaseUploadSession :exec
+UPDATE upload_sessions
+SET finalized_at = NULL
+WHERE id = $1 AND upload_job_id IS NULL;
+
+-- name: SetUploadSessionJob :exec
+UPDATE upload_sessions
+SET upload_job_id = $2
+WHERE id = $1;
+
+-- name: DeleteUploadSession :exec
+DELETE FROM upload_sessions
+WHERE id = $1;
+
+-- name: DeleteExpiredUploadSessions :many
+DELETE FROM upload_sessions
+WHERE expires_at < $1
+RETURNING id;